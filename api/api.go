@@ -0,0 +1,124 @@
+// Package api is the HTTP/JSON control API.
+//
+//	GET  /api/scans                 scans started through the api
+//	POST /api/scans                 start a scan, body is a ScanRequest
+//	GET  /api/scans/{id}            one scan with its confirmed proxies
+//	POST /api/scans/{id}/stop       stop a running scan
+//	GET  /api/events?scan=id        confirmed proxies as server-sent events
+//	GET  /api/results               stored results, see Results
+//	GET  /api/history               finished scans of the results database
+//	GET  /api/jobs                  daemon jobs and their last runs
+//	POST /api/jobs/{name}/{kind}    run a daemon job now, kind is sweep or verify
+//
+// Every request needs the token, as "Authorization: Bearer <token>" or as
+// ?token= for EventSource clients that can't set headers.
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/dn-11/proxyScan/daemon"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/store"
+)
+
+var logger = logging.New("api")
+
+type Server struct {
+	Token string
+	DB    *store.Store
+	// Scanner is the tcp scanner backend when a request doesn't name one
+	Scanner string
+	// Daemon enables the jobs endpoints, nil outside of serve
+	Daemon *daemon.Daemon
+	// Lock is held while a scan runs, nil to run scans concurrently
+	Lock sync.Locker
+
+	mu     sync.Mutex
+	scans  []*Scan
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+// New returns a server answering requests carrying token
+func New(token string, db *store.Store) *Server {
+	return &Server{
+		Token:   token,
+		DB:      db,
+		Scanner: "system",
+		subs:    make(map[*subscriber]struct{}),
+	}
+}
+
+// RandomToken returns a token for when none was configured
+func RandomToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/scans", s.listScans)
+	mux.HandleFunc("POST /api/scans", s.startScan)
+	mux.HandleFunc("GET /api/scans/{id}", s.getScan)
+	mux.HandleFunc("POST /api/scans/{id}/stop", s.stopScan)
+	mux.HandleFunc("GET /api/events", s.events)
+	mux.HandleFunc("GET /api/results", s.results)
+	mux.HandleFunc("GET /api/history", s.history)
+	mux.HandleFunc("GET /api/jobs", s.jobs)
+	mux.HandleFunc("POST /api/jobs/{name}/{kind}", s.runJob)
+	return s.auth(mux)
+}
+
+// ListenAndServe serves the api on addr until it fails
+func (s *Server) ListenAndServe(addr string) error {
+	logger.Info("api listening", "addr", addr)
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// Close stops every running scan and ends the event streams
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sc := range s.scans {
+		sc.cancel()
+	}
+	for sub := range s.subs {
+		close(sub.ch)
+		delete(s.subs, sub)
+	}
+	s.closed = true
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/geoip"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/dn-11/proxyScan/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T) (*Server, *httptest.Server) {
+	db, err := store.Open(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New("secret", db)
+	srv.Scanner = simnet.ScannerName
+	hs := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		hs.Close()
+		db.Close()
+	})
+	return srv, hs
+}
+
+func call(t *testing.T, hs *httptest.Server, method, path, body string, out any) int {
+	req, err := http.NewRequest(method, hs.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	}
+	return resp.StatusCode
+}
+
+func TestAuth(t *testing.T) {
+	_, hs := newServer(t)
+	resp, err := http.Get(hs.URL + "/api/scans")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err = http.Get(hs.URL + "/api/scans?token=wrong")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err = http.Get(hs.URL + "/api/scans?token=secret")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestScan(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	udp := n.NewProxy(simnet.Socks5UDP)
+	tcp := n.NewProxy(simnet.Socks5)
+	n.RegisterScanner()
+	geoip.CloudFlareURL = n.Web.URL("/cf/geo")
+	srv, hs := newServer(t)
+
+	// subscribe before starting so no event is missed
+	resp, err := http.Get(hs.URL + "/api/events?token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := make(chan string, 16)
+	go func() {
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+				events <- data
+			}
+		}
+		close(events)
+	}()
+
+	var scan Scan
+	body := fmt.Sprintf(`{"prefix": "127.0.0.1", "ports": "%d,%d"}`, udp.AddrPort().Port(), tcp.AddrPort().Port())
+	assert.Equal(t, http.StatusAccepted, call(t, hs, "POST", "/api/scans", body, &scan))
+	assert.Equal(t, 1, scan.ID)
+	assert.Equal(t, uint64(2), scan.Probes)
+	srv.scans[0].Wait()
+
+	var got []Proxy
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-events:
+			var p Proxy
+			assert.NoError(t, json.Unmarshal([]byte(data), &p))
+			got = append(got, p)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+	}
+	assert.ElementsMatch(t, []string{udp.Addr(), tcp.Addr()}, []string{got[0].AddrPort.String(), got[1].AddrPort.String()})
+	assert.Equal(t, "CN", got[0].Geo.Country)
+
+	assert.Equal(t, http.StatusOK, call(t, hs, "GET", "/api/scans/1", "", &scan))
+	assert.Equal(t, StatusDone, scan.Status)
+	assert.Len(t, scan.Proxies, 2)
+	assert.Equal(t, 2, scan.Run.New)
+	assert.Equal(t, http.StatusNotFound, call(t, hs, "GET", "/api/scans/9", "", nil))
+
+	var records []store.Record
+	assert.Equal(t, http.StatusOK, call(t, hs, "GET", "/api/results?udp=true&country=cn&asn=AS4538&first_seen=1h", "", &records))
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, udp.AddrPort(), records[0].AddrPort)
+	}
+	assert.Equal(t, http.StatusBadRequest, call(t, hs, "GET", "/api/results?udp=maybe", "", nil))
+
+	for format, want := range map[string]string{"clash": "type: socks5", "text": "FIRST SEEN"} {
+		req, _ := http.NewRequest("GET", hs.URL+"/api/results?download=1&format="+format, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Contains(t, string(data), want)
+			assert.Contains(t, resp.Header.Get("Content-Disposition"), "attachment")
+		}
+	}
+
+	var history []store.ScanRun
+	assert.Equal(t, http.StatusOK, call(t, hs, "GET", "/api/history", "", &history))
+	assert.Equal(t, "api", history[0].Job)
+
+	assert.Equal(t, http.StatusBadRequest, call(t, hs, "POST", "/api/scans", `{"prefix": "127.0.0.1", "scanner": "nope"}`, nil))
+	assert.Equal(t, http.StatusBadRequest, call(t, hs, "POST", "/api/scans", `{}`, nil))
+	assert.Equal(t, http.StatusNotFound, call(t, hs, "GET", "/api/jobs", "", nil))
+}
+
+func TestStop(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	p := n.NewProxy(simnet.Socks5)
+	n.RegisterScanner()
+	srv, hs := newServer(t)
+
+	// hold the lock like a running daemon job so the scan stays queued
+	var lock sync.Mutex
+	srv.Lock = &lock
+	lock.Lock()
+
+	var scan Scan
+	call(t, hs, "POST", "/api/scans", fmt.Sprintf(`{"prefix": "127.0.0.1", "ports": "%d"}`, p.AddrPort().Port()), &scan)
+	assert.Equal(t, StatusQueued, scan.Status)
+	assert.Equal(t, http.StatusAccepted, call(t, hs, "POST", "/api/scans/1/stop", "", nil))
+	lock.Unlock()
+	srv.scans[0].Wait()
+
+	call(t, hs, "GET", "/api/scans/1", "", &scan)
+	assert.Equal(t, StatusStopped, scan.Status)
+	assert.Equal(t, 0, scan.Found)
+}
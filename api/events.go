@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type event struct {
+	name string
+	scan int
+	data []byte
+}
+
+type subscriber struct {
+	ch   chan event
+	scan int
+}
+
+// Publish sends a proxy confirmed outside of the api, eg by a daemon job,
+// to the event streams
+func (s *Server) Publish(p Proxy) {
+	s.publish("proxy", p)
+}
+
+func (s *Server) publish(name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("encode event", "event", name, "err", err)
+		return
+	}
+	e := event{name: name, data: data}
+	switch v := v.(type) {
+	case Proxy:
+		e.scan = v.Scan
+	case Scan:
+		e.scan = v.ID
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subs {
+		if sub.scan != 0 && sub.scan != e.scan {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			// a slow client must not block the scan
+		}
+	}
+}
+
+// events streams "proxy" events for confirmed proxies and "scan" events
+// for finished scans, ?scan=id limits them to one scan
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	sub := &subscriber{ch: make(chan event, 256)}
+	if id := r.URL.Query().Get("scan"); id != "" {
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid scan")
+			return
+		}
+		sub.scan = n
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "closed")
+		return
+	}
+	s.subs[sub] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, sub)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+		}
+		flusher.Flush()
+	}
+}
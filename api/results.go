@@ -0,0 +1,165 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/dn-11/proxyScan/store"
+)
+
+var contentTypes = map[string]string{
+	"json":  "application/json",
+	"text":  "text/plain; charset=utf-8",
+	"clash": "application/yaml",
+	"yaml":  "application/yaml",
+}
+
+var extensions = map[string]string{
+	"json":  "json",
+	"text":  "txt",
+	"clash": "yaml",
+	"yaml":  "yaml",
+}
+
+// results queries the results database. Filters are protocol, country,
+// asn, udp, open, first_seen and last_seen (7d, 12h or a date),
+// format is one of store.Formats and download=1 serves it as a file.
+func (s *Server) results(w http.ResponseWriter, r *http.Request) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "no results database")
+		return
+	}
+	f, err := parseFilter(r, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if _, ok := contentTypes[format]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown format %q", format))
+		return
+	}
+	records, err := s.DB.Query(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypes[format])
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		w.Header().Set("Content-Disposition", `attachment; filename="proxies.`+extensions[format]+`"`)
+	}
+	_ = store.Write(w, format, records)
+}
+
+func parseFilter(r *http.Request, now time.Time) (store.Filter, error) {
+	q := r.URL.Query()
+	f := store.Filter{
+		Protocol: q.Get("protocol"),
+		Country:  q.Get("country"),
+	}
+	var err error
+	if v := q.Get("asn"); v != "" {
+		if f.ASN, err = target.ParseASN(v); err != nil {
+			return f, err
+		}
+	}
+	for name, dst := range map[string]**bool{"udp": &f.UDP, "open": &f.Open} {
+		if v := q.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return f, fmt.Errorf("%s: %w", name, err)
+			}
+			*dst = &b
+		}
+	}
+	if f.FirstSeenSince, err = store.ParseSince(q.Get("first_seen"), now); err != nil {
+		return f, fmt.Errorf("first_seen: %w", err)
+	}
+	if f.LastSeenSince, err = store.ParseSince(q.Get("last_seen"), now); err != nil {
+		return f, fmt.Errorf("last_seen: %w", err)
+	}
+	return f, nil
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	if s.DB == nil {
+		writeError(w, http.StatusNotFound, "no results database")
+		return
+	}
+	scans, err := s.DB.Scans()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, scans)
+}
+
+// Job is a daemon job as listed by the api
+type Job struct {
+	Name           string    `json:"name"`
+	Schedule       string    `json:"schedule"`
+	VerifySchedule string    `json:"verify_schedule,omitempty"`
+	Probes         uint64    `json:"probes"`
+	LastSweep      time.Time `json:"last_sweep"`
+	LastVerify     time.Time `json:"last_verify"`
+}
+
+func (s *Server) jobs(w http.ResponseWriter, r *http.Request) {
+	if s.Daemon == nil {
+		writeError(w, http.StatusNotFound, "not running as daemon")
+		return
+	}
+	list := make([]Job, 0, len(s.Daemon.Jobs()))
+	for _, j := range s.Daemon.Jobs() {
+		st, err := s.DB.JobState(j.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		list = append(list, Job{
+			Name:           j.Name,
+			Schedule:       j.Schedule,
+			VerifySchedule: j.VerifySchedule,
+			Probes:         target.CountProbes(j.Groups()),
+			LastSweep:      st.LastSweep,
+			LastVerify:     st.LastVerify,
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// runJob starts a sweep or verify of a daemon job in the background,
+// its proxies show up in the event stream and the run in /api/history
+func (s *Server) runJob(w http.ResponseWriter, r *http.Request) {
+	if s.Daemon == nil {
+		writeError(w, http.StatusNotFound, "not running as daemon")
+		return
+	}
+	j := s.Daemon.Job(r.PathValue("name"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	run := s.Daemon.Sweep
+	switch r.PathValue("kind") {
+	case "sweep":
+	case "verify":
+		run = s.Daemon.Verify
+	default:
+		writeError(w, http.StatusNotFound, "kind must be sweep or verify")
+		return
+	}
+	go func() {
+		if _, err := run(j); err != nil {
+			logger.Error("job failed", "job", j.Name, "err", err)
+		}
+	}()
+	writeJSON(w, http.StatusAccepted, map[string]string{"job": j.Name, "kind": r.PathValue("kind")})
+}
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan"
+	"github.com/dn-11/proxyScan/scan/geoip"
+	"github.com/dn-11/proxyScan/scan/ports"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/dn-11/proxyScan/scan/tcpscanner"
+	"github.com/dn-11/proxyScan/store"
+)
+
+// ScanRequest starts a scan, empty fields take the defaults of the scan command
+type ScanRequest struct {
+	Prefix  string `json:"prefix"`
+	Ports   string `json:"ports"`
+	Rate    int    `json:"rate"`
+	Scanner string `json:"scanner"`
+	// Report runs ProxyTester on the confirmed proxies after the scan
+	Report bool `json:"report"`
+}
+
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusStopped = "stopped"
+	StatusFailed  = "failed"
+)
+
+// Proxy is a confirmed endpoint as listed and streamed by the api
+type Proxy struct {
+	Scan     int            `json:"scan,omitempty"`
+	Job      string         `json:"job,omitempty"`
+	AddrPort netip.AddrPort `json:"addr"`
+	Protocol string         `json:"protocol"`
+	UDP      bool           `json:"udp"`
+	Auth     bool           `json:"auth"`
+	// Findings label ports no prober recognized, eg encrypted inbounds
+	Findings []prober.Finding `json:"findings,omitempty"`
+	UDPStats *prober.UDPStats `json:"udp_stats,omitempty"`
+	DNSLeak  *prober.DNSLeak  `json:"dns_leak,omitempty"`
+	Geo      *geoip.GeoIP     `json:"geo,omitempty"`
+	Time     time.Time        `json:"time"`
+}
+
+// NewProxy describes a confirmed endpoint, Scan and Job are left for the caller
+func NewProxy(f store.Found) Proxy {
+	return Proxy{
+		AddrPort: f.Result.AddrPort,
+		Protocol: f.Protocol,
+		UDP:      f.Result.UDP,
+		Auth:     f.Result.Auth,
+		Findings: f.Result.Findings,
+		UDPStats: f.Result.UDPStats,
+		DNSLeak:  f.Result.DNSLeak,
+		Geo:      f.Geo,
+		Time:     time.Now(),
+	}
+}
+
+// Scan is a scan started through the api. Its fields are guarded by the
+// server, read them through the api or after Wait.
+type Scan struct {
+	ID      int         `json:"id"`
+	Request ScanRequest `json:"request"`
+	Status  string      `json:"status"`
+	Error   string      `json:"error,omitempty"`
+	Start   time.Time   `json:"start"`
+	End     time.Time   `json:"end"`
+	Probes  uint64      `json:"probes"`
+	// Scanned and Total count generated ips
+	Scanned int            `json:"scanned"`
+	Total   int            `json:"total"`
+	Found   int            `json:"found"`
+	Proxies []Proxy        `json:"proxies,omitempty"`
+	Run     *store.ScanRun `json:"run,omitempty"`
+
+	found  []store.Found
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Wait blocks until the scan is finished and returns what it confirmed
+func (sc *Scan) Wait() []store.Found {
+	<-sc.done
+	return sc.found
+}
+
+// StartScan runs groups with sc in the background. Confirmed proxies are
+// published to the event streams and the results are recorded as job.
+func (s *Server) StartScan(job string, sc *scan.Scanner, groups []target.Group, req ScanRequest) *Scan {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	scn := &Scan{
+		ID:      len(s.scans) + 1,
+		Request: req,
+		Status:  StatusQueued,
+		Probes:  target.CountProbes(groups),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	s.scans = append(s.scans, scn)
+	if s.closed {
+		cancel()
+	}
+	s.mu.Unlock()
+
+	go s.run(ctx, job, scn, sc, groups)
+	return scn
+}
+
+func (s *Server) run(ctx context.Context, job string, scn *Scan, sc *scan.Scanner, groups []target.Group) {
+	defer close(scn.done)
+	defer scn.cancel()
+	if s.Lock != nil {
+		s.Lock.Lock()
+		defer s.Lock.Unlock()
+	}
+
+	start := time.Now()
+	s.update(scn, func() {
+		scn.Status = StatusRunning
+		scn.Start = start
+	})
+	// keep the hooks of the caller, eg a dashboard
+	onProgress, onFound := sc.OnProgress, sc.OnFound
+	sc.OnProgress = func(current, all int) {
+		s.update(scn, func() { scn.Scanned, scn.Total = current, all })
+		if onProgress != nil {
+			onProgress(current, all)
+		}
+	}
+	sc.OnFound = func(res *socks5.Result) {
+		if onFound != nil {
+			onFound(res)
+		}
+		f := store.Found{Result: res, Protocol: res.Protocol}
+		if res.Success {
+			f.Geo, _ = geoip.GetGeoVia(res.Protocol, res.AddrPort.String())
+		}
+		p := NewProxy(f)
+		p.Scan = scn.ID
+		s.update(scn, func() {
+			scn.found = append(scn.found, f)
+			scn.Proxies = append(scn.Proxies, p)
+			scn.Found++
+		})
+		s.publish("proxy", p)
+	}
+	var err error
+	if ctx.Err() == nil {
+		_, err = sc.Run(ctx, groups)
+	}
+	stopped := ctx.Err() != nil
+	if stopped {
+		err = nil
+	}
+
+	var run *store.ScanRun
+	if s.DB != nil && err == nil {
+		covered := groups
+		if stopped {
+			// a partial scan must not close endpoints it never reached
+			covered = nil
+		}
+		if run, err = s.DB.RecordScan(job, start, covered, scn.found); err != nil {
+			logger.Error("update results db", "scan", scn.ID, "err", err)
+		}
+	}
+	if scn.Request.Report && !stopped && err == nil {
+		s.report(scn)
+	}
+
+	s.update(scn, func() {
+		scn.End = time.Now()
+		scn.Run = run
+		switch {
+		case err != nil:
+			scn.Status, scn.Error = StatusFailed, err.Error()
+		case stopped:
+			scn.Status = StatusStopped
+		default:
+			scn.Status = StatusDone
+		}
+	})
+	s.publish("scan", s.snapshot(scn, false))
+}
+
+func (s *Server) report(scn *Scan) {
+	var proxies []string
+	for _, f := range scn.found {
+		if f.Result.Success && proxy.Supports(f.Protocol) {
+			proxies = append(proxies, proxy.ProxyURL(f.Protocol, f.Result.AddrPort.String()))
+		}
+	}
+	if len(proxies) == 0 || s.DB == nil {
+		return
+	}
+	if err := s.DB.RecordTests(proxy.NewProxyTester(nil, proxies).Run()); err != nil {
+		logger.Error("store test results", "scan", scn.ID, "err", err)
+	}
+}
+
+func (s *Server) update(scn *Scan, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+// snapshot copies scn for encoding, proxies are only listed when asked
+func (s *Server) snapshot(scn *Scan, proxies bool) Scan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := Scan{
+		ID: scn.ID, Request: scn.Request, Status: scn.Status, Error: scn.Error,
+		Start: scn.Start, End: scn.End, Probes: scn.Probes,
+		Scanned: scn.Scanned, Total: scn.Total, Found: scn.Found, Run: scn.Run,
+	}
+	if proxies {
+		c.Proxies = append([]Proxy{}, scn.Proxies...)
+	}
+	return c
+}
+
+func (s *Server) scan(r *http.Request) *Scan {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id < 1 || id > len(s.scans) {
+		return nil
+	}
+	return s.scans[id-1]
+}
+
+func (s *Server) listScans(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	scans := append([]*Scan{}, s.scans...)
+	s.mu.Unlock()
+	list := make([]Scan, 0, len(scans))
+	for _, scn := range scans {
+		list = append(list, s.snapshot(scn, false))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) getScan(w http.ResponseWriter, r *http.Request) {
+	scn := s.scan(r)
+	if scn == nil {
+		writeError(w, http.StatusNotFound, "scan not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.snapshot(scn, true))
+}
+
+func (s *Server) stopScan(w http.ResponseWriter, r *http.Request) {
+	scn := s.scan(r)
+	if scn == nil {
+		writeError(w, http.StatusNotFound, "scan not found")
+		return
+	}
+	// the scan stops in the background, watch its status or the scan event
+	scn.cancel()
+	writeJSON(w, http.StatusAccepted, s.snapshot(scn, false))
+}
+
+func (s *Server) startScan(w http.ResponseWriter, r *http.Request) {
+	var req ScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "decode request: "+err.Error())
+		return
+	}
+	sc, groups, err := s.prepare(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	scn := s.StartScan("api", sc, groups, req)
+	writeJSON(w, http.StatusAccepted, s.snapshot(scn, false))
+}
+
+// prepare fills the defaults of req and plans its targets
+func (s *Server) prepare(req *ScanRequest) (*scan.Scanner, []target.Group, error) {
+	if req.Ports == "" {
+		req.Ports = ports.Default
+	}
+	if req.Rate == 0 {
+		req.Rate = 3000
+	}
+	if req.Scanner == "" {
+		req.Scanner = s.Scanner
+	}
+	if !(req.Rate == -1 || req.Rate > 0) {
+		return nil, nil, fmt.Errorf("rate must be -1 or >0")
+	}
+	if !tcpscanner.Registered(req.Scanner) {
+		return nil, nil, fmt.Errorf("unknown scanner %q, have %s", req.Scanner, strings.Join(tcpscanner.Names(), ", "))
+	}
+	prefixes, err := target.ParseList(req.Prefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("prefix: %w", err)
+	}
+	groups, err := target.Plan(prefixes, req.Ports, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ports: %w", err)
+	}
+	if len(groups) == 0 {
+		return nil, nil, fmt.Errorf("no target")
+	}
+
+	sc := scan.Default()
+	sc.ScannerType = req.Scanner
+	sc.PortScanRate = req.Rate
+	return sc, groups, nil
+}
@@ -1,76 +1,194 @@
 package cli
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
-	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/dn-11/proxyScan/api"
 	"github.com/dn-11/proxyScan/convert"
+	"github.com/dn-11/proxyScan/dashboard"
+	"github.com/dn-11/proxyScan/diff"
+	"github.com/dn-11/proxyScan/metrics"
+	"github.com/dn-11/proxyScan/proxy"
 	"github.com/dn-11/proxyScan/scan"
+	"github.com/dn-11/proxyScan/scan/geoip"
+	"github.com/dn-11/proxyScan/scan/ports"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/dn-11/proxyScan/store"
 	"gopkg.in/yaml.v3"
 )
 
+// commands are the subcommands, the scan runs when none is given
+var commands = map[string]func(args []string){
+	"scan":  Scan,
+	"db":    DB,
+	"diff":  Diff,
+	"serve": Serve,
+	"watch": Watch,
+	"echo":  Echo,
+}
+
 func Cli() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+	Scan(os.Args[1:])
+}
+
+func Scan(args []string) {
 	var (
-		Prefix  string
-		Port    string
-		TestURL string
-		Output  string
-		Pcap    bool
-		Rate    int
-		Report  bool
+		Prefix      string
+		TargetsFile string
+		ASN         string
+		Country     string
+		PrefixDB    string
+		Port        string
+		TopPorts    string
+		TestURL     string
+		Output      string
+		Pcap        bool
+		Backend     string
+		Rate        int
+		Report      bool
+		DBPath      string
+		DiffOld     string
+		DiffFormat  string
+		DiffOutput  string
+		APIAddr     string
+		APIToken    string
+		Metrics     string
+		TUI         bool
+		Probers     string
+		UDPTargets  string
+		UDPPackets  int
+		NATEcho     string
+		LeakZone    string
+		LeakListen  string
+		LeakTarget  string
+		Integrity   string
+		ChecksFile  string
+		IPAPIs      string
+		EchoServer  string
+		EchoNATPort int
+		EchoURL     string
+		SpeedURL    string
+		LatencyURLs string
+		Samples     int
+		SpeedBudget float64
+		SpeedLink   float64
+		SpeedConc   int
+		SpeedBytes  int64
+		SpeedTime   time.Duration
+		UploadURL   string
 	)
 
-	flag.StringVar(&Prefix, "prefix", "", "prefix")
-	flag.StringVar(&Port, "port", "10808,10809,20170-20172,7890-7893", "split by , use - for range, eg: 10808,10809,20171-20172,7890-7893")
+	flag.StringVar(&Prefix, "prefix", "", "targets split by , : cidr, a.b.c.d-e.f.g.h range, ip or hostname")
+	flag.StringVar(&TargetsFile, "targets-file", "", "file with one target per line, same syntax as -prefix, optionally followed by ports overriding -port")
+	flag.StringVar(&ASN, "asn", "", "scan all prefixes of these ASNs split by , eg: AS4538, needs -prefix-db")
+	flag.StringVar(&Country, "country", "", "scan all prefixes of these country codes split by , eg: CN, needs -prefix-db")
+	flag.StringVar(&PrefixDB, "prefix-db", "", "prefix database, ip2asn tsv or prefix,asn,country csv, optionally gzipped")
+	flag.StringVar(&Port, "port", ports.Default, "split by , use - for range, presets "+strings.Join(ports.PresetNames(), "/")+", top:N for the first ports of the top list, ! to exclude, eg: 10808,20171-20172,clash,!7892")
+	flag.StringVar(&TopPorts, "top-ports", "", "file with one port per line, most likely first, replacing the list of top:N, which is ranked by the hits in -db when set")
 	flag.StringVar(&TestURL, "url", "http://www.gstatic.com/generate_204", "")
 	flag.StringVar(&Output, "output", "proxies.yaml", "output file")
 	flag.BoolVar(&Pcap, "pcap", false, "use pcap")
+	flag.StringVar(&Backend, "scanner", "", "tcp scanner backend: system, pcap or pcap-offline, overrides -pcap")
 	flag.IntVar(&Rate, "rate", 3000, "rate, -1 for unlimited")
 	flag.BoolVar(&Report, "report", false, "generate proxy test report")
-	flag.Parse()
+	flag.StringVar(&DBPath, "db", "", "results database to update, see the db command")
+	flag.StringVar(&DiffOld, "diff", "", "compare the results with a previous result set (.yaml, .json or .db)")
+	flag.StringVar(&DiffFormat, "diff-format", "text", "diff output format: text, json or markdown")
+	flag.StringVar(&DiffOutput, "diff-output", "", "diff output file, stdout if empty")
+	flag.StringVar(&APIAddr, "api", "", "serve the control api on this address, eg: :8080, and keep running after the scan")
+	flag.StringVar(&APIToken, "api-token", "", "api token, a random one is logged if empty")
+	flag.StringVar(&Metrics, "metrics", "", "serve prometheus metrics on this address, eg: :9090")
+	flag.BoolVar(&TUI, "tui", false, "show a live dashboard instead of per address logs, status lines when not on a terminal")
+	flag.StringVar(&Probers, "probers", "", "protocol probers to run on open ports split by , default all: "+strings.Join(prober.Default.Names(), ","))
+	flag.StringVar(&UDPTargets, "udp-targets", "", "udp relay test targets tried in order split by , kind dns, ntp or echo, eg: dns:1.1.1.1:53,ntp:pool.ntp.org:123")
+	flag.IntVar(&UDPPackets, "udp-packets", socks5.UDPPackets, "datagrams sent to measure udp rtt and loss")
+	flag.StringVar(&NATEcho, "nat-echo", "", "two udp echo servers split by , to classify the nat of udp proxies")
+	flag.StringVar(&LeakZone, "dns-leak-zone", "", "zone delegated to this host, socks5 proxies are asked to connect to unique names of it to record their resolvers")
+	flag.StringVar(&LeakListen, "dns-leak-listen", ":53", "dns address of the -dns-leak-zone server")
+	flag.StringVar(&LeakTarget, "dns-leak-target", "", "public ip:port names of -dns-leak-zone resolve to, the check is served over http on its port")
+	flag.StringVar(&Integrity, "integrity", "", "resource file with expected certificates, body hashes and headers, -report flags proxies tampering with them")
+	flag.StringVar(&ChecksFile, "checks", "", "file of destinations -report tries through every proxy, shown as a pass/fail matrix")
+	flag.StringVar(&IPAPIs, "ip-apis", "", "ip check api definitions replacing the built-in ones, see proxy/ipapis.yaml")
+	flag.StringVar(&EchoServer, "echo-server", "", "self-hosted echo server with its token, eg: http://203.0.113.1:8080/TOKEN, replaces -url, -speed-url, -latency-urls, -upload-url, -echo-url, -ip-apis and -udp-targets, see the echo command")
+	flag.IntVar(&EchoNATPort, "echo-nat-port", 0, "second udp port of -echo-server, classifies the nat of udp proxies")
+	flag.StringVar(&EchoURL, "echo-url", "", "plain http header echo, -report classifies proxies as transparent, anonymous or elite with it")
+	flag.StringVar(&SpeedURL, "speed-url", proxy.SpeedTestURL, "download used by -report to measure speed")
+	flag.StringVar(&LatencyURLs, "latency-urls", strings.Join(proxy.LatencyURLs, ","), "urls split by , -report measures connect, handshake, first byte and total time of")
+	flag.IntVar(&Samples, "latency-samples", proxy.LatencySamples, "requests per latency url")
+	flag.Float64Var(&SpeedBudget, "speed-budget", 0, "Mbit/s shared by all -report speed tests, results held back by it are flagged, 0 for unlimited")
+	flag.Float64Var(&SpeedLink, "speed-link", 0, "Mbit/s of the local link, results of speed tests that together came near it are flagged, 0 for unknown")
+	flag.IntVar(&SpeedConc, "speed-concurrency", proxy.SpeedConcurrency, "proxies speed tested at once")
+	flag.Int64Var(&SpeedBytes, "speed-max-bytes", proxy.SpeedMaxBytes, "bytes after which a speed test stops if the estimate is not stable yet")
+	flag.DurationVar(&SpeedTime, "speed-max-time", proxy.SpeedMaxTime, "time after which a speed test stops if the estimate is not stable yet")
+	flag.StringVar(&UploadURL, "upload-url", "", "url -report posts to to measure upload speed, eg: https://speed.cloudflare.com/__up")
+	setupLog := logFlags(flag.CommandLine)
+	_ = flag.CommandLine.Parse(args)
+	setupLog()
 
 	// assert rate
 	if !(Rate == -1 || Rate > 0) {
 		log.Fatal("rate must be -1 or >0")
 	}
-	// parse prefix
-	var prefixs []netip.Prefix
-	for _, prefix := range strings.Split(Prefix, ",") {
-		prefixs = append(prefixs, netip.MustParsePrefix(prefix))
+	// parse targets
+	prefixs, err := target.ParseList(Prefix)
+	if err != nil {
+		log.Fatalf("parse prefix: %v", err)
 	}
-
-	// parse port
-	var ports []int
-	for _, port := range strings.Split(Port, ",") {
-		if strings.Contains(port, "-") {
-			strings.Split(port, "-")
-			start, err := strconv.Atoi(strings.Split(port, "-")[0])
-			if err != nil {
-				log.Fatal(err)
-			}
-			end, err := strconv.Atoi(strings.Split(port, "-")[1])
-			if err != nil {
-				log.Fatal(err)
-			}
-			for i := start; i <= end; i++ {
-				ports = append(ports, i)
-			}
-		} else {
-			i, err := strconv.Atoi(port)
-			if err != nil {
-				log.Fatal(err)
-			}
-			ports = append(ports, i)
+	var entries []target.Entry
+	if TargetsFile != "" {
+		entries, err = target.ReadFile(TargetsFile)
+		if err != nil {
+			log.Fatalf("read targets file: %v", err)
+		}
+	}
+	if ASN != "" || Country != "" {
+		if PrefixDB == "" {
+			log.Fatal("-asn and -country need -prefix-db")
 		}
+		db, err := target.LoadDB(PrefixDB)
+		if err != nil {
+			log.Fatalf("load prefix db: %v", err)
+		}
+		p, err := db.Expand(ASN, Country)
+		if err != nil {
+			log.Fatal(err)
+		}
+		prefixs = append(prefixs, p...)
 	}
+	if TopPorts != "" {
+		if ports.Top, err = ports.ReadFile(TopPorts); err != nil {
+			log.Fatalf("read top ports: %v", err)
+		}
+	} else if DBPath != "" {
+		if ports.Top, err = rankPorts(DBPath); err != nil {
+			log.Fatalf("rank top ports: %v", err)
+		}
+	}
+	groups, err := target.Plan(prefixs, Port, entries)
+	if err != nil {
+		log.Fatalf("parse port: %v", err)
+	}
+	if len(groups) == 0 {
+		log.Fatal("no target, use -prefix, -targets-file, -asn or -country")
+	}
+	log.Printf("%d groups, %d probes", len(groups), target.CountProbes(groups))
 
 	s := scan.Default()
 	s.TestUrl = TestURL
@@ -78,6 +196,126 @@ func Cli() {
 	if Pcap {
 		s.ScannerType = "pcap"
 	}
+	if Backend != "" {
+		s.ScannerType = Backend
+	}
+	if Probers != "" {
+		s.Probers, err = prober.Default.Select(strings.Split(Probers, ",")...)
+		if err != nil {
+			log.Fatalf("parse probers: %v", err)
+		}
+	}
+
+	if UDPTargets != "" {
+		socks5.UDPTargets, err = socks5.ParseUDPTargets(UDPTargets)
+		if err != nil {
+			log.Fatalf("parse udp targets: %v", err)
+		}
+	}
+	if UDPPackets > 0 {
+		socks5.UDPPackets = UDPPackets
+	}
+	if NATEcho != "" {
+		echo := strings.Split(NATEcho, ",")
+		if len(echo) != 2 {
+			log.Fatal("-nat-echo needs two echo servers")
+		}
+		socks5.NATEcho = [2]string(echo)
+	}
+
+	if Integrity != "" {
+		proxy.Resources, err = proxy.LoadResources(Integrity)
+		if err != nil {
+			log.Fatalf("load integrity resources: %v", err)
+		}
+	}
+	if ChecksFile != "" {
+		proxy.Checks, err = proxy.LoadChecks(ChecksFile)
+		if err != nil {
+			log.Fatalf("load checks: %v", err)
+		}
+	}
+	if IPAPIs != "" {
+		proxy.IPCheckAPIs, err = proxy.LoadIPCheckAPIs(IPAPIs)
+		if err != nil {
+			log.Fatalf("load ip apis: %v", err)
+		}
+	}
+	proxy.SpeedTestURL = SpeedURL
+	proxy.LatencyURLs = nil
+	if LatencyURLs != "" {
+		proxy.LatencyURLs = strings.Split(LatencyURLs, ",")
+	}
+	if Samples > 0 {
+		proxy.LatencySamples = Samples
+	}
+	proxy.SpeedBudget = proxy.MbpsToBytes(SpeedBudget)
+	proxy.SpeedLink = proxy.MbpsToBytes(SpeedLink)
+	proxy.SpeedConcurrency = SpeedConc
+	proxy.SpeedMaxBytes = SpeedBytes
+	proxy.SpeedMaxTime = SpeedTime
+	proxy.SpeedUploadURL = UploadURL
+	if EchoURL != "" {
+		if err := proxy.ValidateEchoURL(EchoURL); err != nil {
+			log.Fatal(err)
+		}
+		proxy.EchoURL = EchoURL
+	}
+	if EchoServer != "" {
+		if err := useEchoServer(EchoServer, EchoNATPort); err != nil {
+			log.Fatal(err)
+		}
+		s.TestUrl = socks5.TestURL
+	}
+	if LeakZone != "" {
+		startDNSLeak(LeakZone, LeakListen, LeakTarget)
+	}
+
+	// load the previous results before they are overwritten
+	var old []diff.Endpoint
+	if DiffOld != "" {
+		old, err = diff.LoadFile(DiffOld)
+		if err != nil {
+			log.Fatalf("load -diff: %v", err)
+		}
+	}
+
+	var (
+		db     *store.Store
+		tmpDir string
+	)
+	if DBPath == "" && APIAddr != "" {
+		// the api serves its results from a database, keep a throwaway one
+		if tmpDir, err = os.MkdirTemp("", "proxyscan"); err != nil {
+			log.Fatal(err)
+		}
+		defer os.RemoveAll(tmpDir)
+		DBPath = filepath.Join(tmpDir, "results.db")
+	}
+	if DBPath != "" {
+		db, err = store.Open(DBPath)
+		if err != nil {
+			log.Fatalf("open results db: %v", err)
+		}
+		defer db.Close()
+	}
+
+	if Metrics != "" {
+		metrics.Serve(Metrics)
+	}
+
+	var srv *api.Server
+	if APIAddr != "" {
+		if APIToken == "" {
+			APIToken = api.RandomToken()
+			log.Printf("api token: %s", APIToken)
+		}
+		srv = api.New(APIToken, db)
+		srv.Scanner = s.ScannerType
+		go func() {
+			log.Fatal(srv.ListenAndServe(APIAddr))
+		}()
+	}
 
 	// setup signal handling
 	sigChan := make(chan os.Signal, 1)
@@ -86,16 +324,60 @@ func Cli() {
 
 	// start scanning
 	go func() {
-		list := s.ScanSocks5(prefixs, ports)
+		var dash *dashboard.Dashboard
+		if TUI {
+			dash = dashboard.New(os.Stdout, groups, Rate)
+			dash.Attach(s)
+			dash.Start()
+		}
+
+		var found []store.Found
+		if srv != nil {
+			// the api scan streams, looks up geo and records the results itself
+			found = srv.StartScan("", s, groups, api.ScanRequest{Prefix: Prefix, Ports: Port, Rate: Rate, Scanner: s.ScannerType}).Wait()
+		} else {
+			start := time.Now()
+			list, err := s.Run(context.Background(), groups)
+			if err != nil {
+				log.Fatalf("scan: %v", err)
+			}
+			found = make([]store.Found, 0, len(list))
+			for _, res := range list {
+				f := store.Found{Result: res, Protocol: res.Protocol}
+				if res.Success {
+					f.Geo, _ = geoip.GetGeoVia(res.Protocol, res.AddrPort.String())
+				}
+				found = append(found, f)
+			}
+			if db != nil {
+				run, err := db.RecordScan("", start, groups, found)
+				if err != nil {
+					log.Fatalf("update results db: %v", err)
+				}
+				log.Printf("results db: scan #%d, %d new, %d closed", run.ID, run.New, run.Closed)
+			}
+		}
+
+		if dash != nil {
+			dash.Stop()
+		}
 
 		// generate output
 		output := make(map[string][]*convert.ClashSocks5Proxy)
-		output["proxies"] = make([]*convert.ClashSocks5Proxy, 0, len(list))
-		for _, addr := range list {
-			output["proxies"] = append(output["proxies"], convert.ToClash(addr))
+		output["proxies"] = make([]*convert.ClashSocks5Proxy, 0, len(found))
+		for _, f := range found {
+			if f.Result.Success && convert.ClashSupports(f.Protocol) {
+				output["proxies"] = append(output["proxies"], convert.ToClashGeo(f.Result, f.Geo))
+			}
 		}
 
-		log.Printf("total %d proxies", len(list))
+		if DiffOld != "" {
+			if err := writeDiff(DiffOutput, DiffFormat, diff.Compare(old, diff.FromFound(found))); err != nil {
+				log.Printf("write diff: %v", err)
+			}
+		}
+
+		log.Printf("total %d proxies", len(output["proxies"]))
 		data, err := yaml.Marshal(output)
 		if err != nil {
 			log.Fatal(err)
@@ -120,6 +402,12 @@ func Cli() {
 	case <-sigChan:
 		log.Println("received termination signal, stopping scan...")
 		log.Println("scan stopped")
+		if TUI {
+			fmt.Print("\x1b[?25h")
+		}
+		if tmpDir != "" {
+			os.RemoveAll(tmpDir)
+		}
 		os.Exit(0)
 	case <-doneChan:
 		log.Println("scan completed")
@@ -129,6 +417,12 @@ func Cli() {
 	if Report {
 		// Wait a moment to ensure file is written
 		time.Sleep(1 * time.Second)
-		GenerateReport()
+		GenerateReport(db)
+	}
+
+	if srv != nil {
+		log.Printf("api still serving on %s, interrupt to exit", APIAddr)
+		<-sigChan
+		srv.Close()
 	}
 }
@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/ports"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/dn-11/proxyScan/store"
+)
+
+// DB queries the results database, or marks endpoints as notified with
+// "db notify addr..."
+func DB(args []string) {
+	fs := flag.NewFlagSet("db", flag.ExitOnError)
+	var (
+		path      = fs.String("db", "proxyscan.db", "results database")
+		firstSeen = fs.String("first-seen", "", "only endpoints that appeared since, eg: 7d, 12h or 2024-05-01")
+		lastSeen  = fs.String("last-seen", "", "only endpoints seen open since, same format as -first-seen")
+		protocol  = fs.String("protocol", "", "only this protocol, eg: socks5")
+		country   = fs.String("country", "", "only this country")
+		asn       = fs.String("asn", "", "only this ASN, eg: AS4538")
+		udp       = fs.String("udp", "", "true or false to filter on udp support")
+		open      = fs.String("open", "", "true or false to filter on still open")
+		notified  = fs.Bool("still-open-after-notify", false, "only notified endpoints that were seen open afterwards")
+		format    = fs.String("format", "text", strings.Join(store.Formats, ", "))
+	)
+	_ = fs.Parse(args)
+
+	db, err := store.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	if fs.Arg(0) == "notify" {
+		var addrs []netip.AddrPort
+		for _, a := range fs.Args()[1:] {
+			addr, err := netip.ParseAddrPort(a)
+			if err != nil {
+				log.Fatal(err)
+			}
+			addrs = append(addrs, addr)
+		}
+		if err := db.MarkNotified(addrs, time.Now()); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("marked %d endpoints as notified", len(addrs))
+		return
+	}
+
+	f := store.Filter{
+		Protocol:             *protocol,
+		Country:              *country,
+		StillOpenAfterNotify: *notified,
+	}
+	if f.FirstSeenSince, err = store.ParseSince(*firstSeen, time.Now()); err != nil {
+		log.Fatalf("-first-seen: %v", err)
+	}
+	if f.LastSeenSince, err = store.ParseSince(*lastSeen, time.Now()); err != nil {
+		log.Fatalf("-last-seen: %v", err)
+	}
+	if *asn != "" {
+		if f.ASN, err = target.ParseASN(*asn); err != nil {
+			log.Fatalf("-asn: %v", err)
+		}
+	}
+	if f.UDP, err = parseOptionalBool(*udp); err != nil {
+		log.Fatalf("-udp: %v", err)
+	}
+	if f.Open, err = parseOptionalBool(*open); err != nil {
+		log.Fatalf("-open: %v", err)
+	}
+
+	records, err := db.Query(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := store.Write(os.Stdout, *format, records); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d endpoints", len(records))
+}
+
+func parseOptionalBool(s string) (*bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// rankPorts orders ports.Top by the hits of the results database at path,
+// a missing database keeps it
+func rankPorts(path string) ([]int, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return ports.Top, nil
+	}
+	db, err := store.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	hits, err := db.PortHits()
+	if err != nil {
+		return nil, err
+	}
+	return ports.Rank(hits), nil
+}
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dn-11/proxyScan/diff"
+)
+
+// Diff compares two result sets: proxyScan diff [-format text|json|markdown] old new
+func Diff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := fs.String("format", "text", "text, json or markdown")
+	output := fs.String("output", "", "write to file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: proxyScan diff [flags] old new")
+		fmt.Fprintln(fs.Output(), "old and new are .yaml clash configs, .json from `db -format json` or .db results databases")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	old, err := diff.LoadFile(fs.Arg(0))
+	if err != nil {
+		log.Fatalf("load old: %v", err)
+	}
+	new, err := diff.LoadFile(fs.Arg(1))
+	if err != nil {
+		log.Fatalf("load new: %v", err)
+	}
+	if err := writeDiff(*output, *format, diff.Compare(old, new)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func writeDiff(output, format string, r *diff.Result) error {
+	if output == "" {
+		return diff.Write(os.Stdout, format, r)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := diff.Write(f, format, r); err != nil {
+		return err
+	}
+	log.Printf("diff written to %s", output)
+	return nil
+}
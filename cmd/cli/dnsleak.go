@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"log"
+	"net/netip"
+	"strconv"
+
+	"github.com/dn-11/proxyScan/dnsleak"
+	"github.com/dn-11/proxyScan/scan/socks5"
+)
+
+// startDNSLeak serves zone on listen and the http check on the port of
+// target, and enables the remote dns test of socks5 proxies
+func startDNSLeak(zone, listen, target string) {
+	addr, err := netip.ParseAddrPort(target)
+	if err != nil {
+		log.Fatalf("parse dns leak target: %v", err)
+	}
+	srv := dnsleak.NewServer(zone, addr)
+	socks5.LeakServer = srv
+	go func() {
+		log.Fatalf("dns leak server: %v", srv.ListenAndServe(listen, ":"+strconv.Itoa(int(addr.Port()))))
+	}()
+	log.Printf("dns leak zone %s on %s, names point to %s", srv.Zone, listen, addr)
+}
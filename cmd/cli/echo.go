@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"flag"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/dn-11/proxyScan/api"
+	"github.com/dn-11/proxyScan/echo"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan/socks5"
+)
+
+var echoLog = logging.New("echo")
+
+// Echo runs a self-hosted echo server until it fails
+func Echo(args []string) {
+	fs := flag.NewFlagSet("echo", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "tcp and udp address of the echo server")
+	natListen := fs.String("nat-listen", "", "second udp echo address, eg: :8081, to classify the nat of udp proxies")
+	token := fs.String("token", "", "path prefix every http request needs, a random one is logged if empty")
+	public := fs.Bool("public", false, "serve http without a token")
+	maxDownload := fs.Int64("max-download", echo.MaxDownload, "largest download in bytes, must cover -speed-max-bytes of the scanner")
+	maxTransfers := fs.Int("max-transfers", echo.MaxTransfers, "downloads and uploads served at once")
+	bandwidth := fs.Float64("bandwidth", 0, "Mbit/s all downloads and uploads share, 0 is unlimited")
+	setupLog := logFlags(fs)
+	_ = fs.Parse(args)
+	setupLog()
+
+	echo.MaxDownload = *maxDownload
+	echo.MaxTransfers = *maxTransfers
+	echo.Bandwidth = proxy.MbpsToBytes(*bandwidth)
+	if !*public {
+		echo.Token = *token
+		if echo.Token == "" {
+			echo.Token = api.RandomToken()
+		}
+		_, port, _ := net.SplitHostPort(*listen)
+		echoLog.Info("echo token", "token", echo.Token, "echo_server", "http://<this host>:"+port+"/"+echo.Token)
+	}
+	if *natListen != "" {
+		pc, err := net.ListenPacket("udp", *natListen)
+		if err != nil {
+			echoLog.Error("failed to listen for nat echo", "err", err)
+			os.Exit(1)
+		}
+		go func() {
+			echoLog.Error("nat echo stopped", "err", echo.ServeUDP(pc))
+			os.Exit(1)
+		}()
+	}
+	echoLog.Info("echo server", "listen", *listen)
+	echoLog.Error("echo server stopped", "err", echo.ListenAndServe(*listen))
+	os.Exit(1)
+}
+
+// useEchoServer points the scanner and the tester at the echo server at
+// base instead of third-party services, natPort is the -nat-listen port of
+// the server or 0
+func useEchoServer(base string, natPort int) error {
+	ep, err := echo.ParseEndpoints(base)
+	if err != nil {
+		return err
+	}
+	api, err := proxy.NewIPCheckAPI("echo", ep.Trace, proxy.ParserKV, map[string]string{"ip": "ip"})
+	if err != nil {
+		return err
+	}
+	socks5.TestURL = ep.Check
+	socks5.UDPTargets = []socks5.UDPTarget{{Kind: socks5.UDPEcho, Addr: ep.UDP}}
+	if natPort > 0 {
+		host, _, _ := net.SplitHostPort(ep.UDP)
+		socks5.NATEcho = [2]string{ep.UDP, net.JoinHostPort(host, strconv.Itoa(natPort))}
+	}
+	proxy.LatencyURLs = []string{ep.Check}
+	proxy.SpeedTestURL = ep.Download(proxy.SpeedMaxBytes)
+	proxy.SpeedUploadURL = ep.Upload
+	proxy.EchoURL = ep.Headers
+	proxy.IPCheckAPIs = []proxy.IPCheckAPI{api}
+	return nil
+}
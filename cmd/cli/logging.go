@@ -0,0 +1,19 @@
+package cli
+
+import (
+	"flag"
+	"log"
+
+	"github.com/dn-11/proxyScan/logging"
+)
+
+// logFlags adds -log-level and -log-format to fs, call the result after parsing
+func logFlags(fs *flag.FlagSet) func() {
+	level := fs.String("log-level", "info", "debug, info, warn or error, per component overrides after a comma, eg: warn,socks5=debug")
+	format := fs.String("log-format", "text", "log format: text or json")
+	return func() {
+		if err := logging.Setup(logging.Options{Level: *level, Format: *format}); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package cli
 
 import (
-	"fmt"
-	"log"
+	"net"
 	"os"
+	"strconv"
 
+	"github.com/dn-11/proxyScan/logging"
 	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/store"
 	"gopkg.in/yaml.v3"
 )
 
+var reportLog = logging.New("report")
+
 type ProxyConfig struct {
 	Proxies []struct {
 		Name     string `yaml:"name"`
@@ -20,28 +24,18 @@ type ProxyConfig struct {
 	} `yaml:"proxies"`
 }
 
-func GenerateReport() {
+// GenerateReport tests the proxies of proxies.yaml and writes the report,
+// results are also stored in db when it is not nil
+func GenerateReport(db *store.Store) {
 	// Read proxy list from scan results
-	configFile := "proxies.yaml"
-	data, err := os.ReadFile(configFile)
+	proxies, err := readProxies("proxies.yaml")
 	if err != nil {
-		log.Fatalf("Failed to read config file: %v", err)
-	}
-
-	var config ProxyConfig
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		log.Fatalf("Failed to parse config file: %v", err)
-	}
-
-	// Build proxy address list
-	var proxies []string
-	for _, p := range config.Proxies {
-		proxyAddr := fmt.Sprintf("%s:%d", p.Server, p.Port)
-		proxies = append(proxies, proxyAddr)
+		reportLog.Error("failed to read config file", "err", err)
+		os.Exit(1)
 	}
 
 	if len(proxies) == 0 {
-		log.Println("No available proxies found")
+		reportLog.Info("no available proxies found")
 		return
 	}
 
@@ -49,14 +43,48 @@ func GenerateReport() {
 	tester := proxy.NewProxyTester(nil, proxies)
 
 	// Run tests
-	log.Println("Starting proxy tests...")
+	reportLog.Info("starting proxy tests", "proxies", len(proxies))
 	results := tester.Run()
 
+	if db != nil {
+		if err := db.RecordTests(results); err != nil {
+			reportLog.Warn("failed to store test results", "err", err)
+		}
+	}
+
 	// Generate report
 	report := proxy.NewReport(results)
 	if err := report.GenerateTXT("proxy_test_results.txt"); err != nil {
-		log.Fatalf("Failed to generate report: %v", err)
+		reportLog.Error("failed to generate report", "err", err)
+		os.Exit(1)
 	}
 
-	log.Println("Proxy testing completed, results saved to proxy_test_results.txt")
+	reportLog.Info("proxy testing completed", "output", "proxy_test_results.txt")
+}
+
+// readProxies returns the proxies of a scan output file as proxy.ProxyURL
+func readProxies(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ProxyConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	// Build proxy address list
+	var proxies []string
+	for _, p := range config.Proxies {
+		protocol := p.Type
+		if protocol == "" {
+			protocol = proxy.ProtocolSocks5
+		}
+		if !proxy.Supports(protocol) {
+			continue
+		}
+		proxies = append(proxies, proxy.ProxyURL(protocol, net.JoinHostPort(p.Server, strconv.Itoa(p.Port))))
+	}
+	return proxies, nil
 }
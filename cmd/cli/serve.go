@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dn-11/proxyScan/api"
+	"github.com/dn-11/proxyScan/daemon"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/store"
+)
+
+var serveLog = logging.New("serve")
+
+// Serve runs the scheduled jobs of a config file until interrupted
+func Serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	config := fs.String("config", "proxyscan.yaml", "daemon config file")
+	apiAddr := fs.String("api", "", "serve the control api on this address, overrides api.listen")
+	apiToken := fs.String("api-token", "", "api token, overrides api.token")
+	metricsAddr := fs.String("metrics", "", "serve prometheus metrics on this address, overrides metrics")
+	setupLog := logFlags(fs)
+	_ = fs.Parse(args)
+	setupLog()
+
+	cfg, err := daemon.LoadConfig(*config)
+	if err != nil {
+		serveLog.Error("failed to load config", "err", err)
+		os.Exit(1)
+	}
+	if *apiAddr != "" {
+		cfg.API.Listen = *apiAddr
+	}
+	if *apiToken != "" {
+		cfg.API.Token = *apiToken
+	}
+	if *metricsAddr != "" {
+		cfg.Metrics = *metricsAddr
+	}
+	if cfg.Metrics != "" {
+		metrics.Serve(cfg.Metrics)
+	}
+	d, err := daemon.New(cfg)
+	if err != nil {
+		serveLog.Error("failed to open results db", "err", err)
+		os.Exit(1)
+	}
+	cfg.UDP.Apply()
+	proxy.Resources = cfg.Resources
+	proxy.Checks = cfg.Checks
+	if cfg.IPCheckAPIs != nil {
+		proxy.IPCheckAPIs = cfg.IPCheckAPIs
+	}
+	proxy.EchoURL = cfg.EchoURL
+	cfg.Tester.Apply()
+	hits, err := d.DB().PortHits()
+	if err != nil {
+		serveLog.Error("failed to read port hits", "err", err)
+		os.Exit(1)
+	}
+	if err := cfg.ApplyPorts(hits); err != nil {
+		serveLog.Error("failed to plan jobs", "err", err)
+		os.Exit(1)
+	}
+	if cfg.EchoServer.URL != "" {
+		if err := useEchoServer(cfg.EchoServer.URL, cfg.EchoServer.NATPort); err != nil {
+			serveLog.Error("failed to use echo server", "err", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.DNSLeak.Zone != "" {
+		startDNSLeak(cfg.DNSLeak.Zone, cfg.DNSLeak.Listen, cfg.DNSLeak.Target)
+	}
+
+	var srv *api.Server
+	if cfg.API.Listen != "" {
+		if cfg.API.Token == "" {
+			cfg.API.Token = api.RandomToken()
+			serveLog.Info("api token", "token", cfg.API.Token)
+		}
+		srv = api.New(cfg.API.Token, d.DB())
+		srv.Scanner = cfg.Scanner
+		srv.Daemon = d
+		srv.Lock = d.Locker()
+		d.OnFound = func(job string, f store.Found) {
+			p := api.NewProxy(f)
+			p.Job = job
+			srv.Publish(p)
+		}
+		go func() {
+			serveLog.Error("api server stopped", "err", srv.ListenAndServe(cfg.API.Listen))
+			os.Exit(1)
+		}()
+	}
+
+	d.Start()
+	serveLog.Info("serving jobs", "jobs", len(cfg.Jobs), "db", cfg.DB)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	serveLog.Info("stopping, waiting for running jobs")
+	if srv != nil {
+		srv.Close()
+	}
+	d.Stop()
+}
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/store"
+	"github.com/dn-11/proxyScan/watch"
+)
+
+var watchLog = logging.New("watch")
+
+// Watch re-probes proxies of the results database or a scan output file
+// until interrupted, keeping their history in a status file
+func Watch(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	var (
+		dbPath      = fs.String("db", "", "watch the open socks5 endpoints of this results database, http proxies are not watched")
+		file        = fs.String("file", "", "watch the socks5 proxies of this scan output file, eg: proxies.yaml, http proxies are not watched")
+		interval    = fs.Duration("interval", 5*time.Minute, "time between rounds")
+		jitter      = fs.Duration("jitter", 30*time.Second, "random delay added to every interval")
+		rounds      = fs.Int("rounds", 0, "stop after this many rounds, 0 runs until interrupted")
+		concurrency = fs.Int("concurrency", 10, "proxies probed at once")
+		history     = fs.Int("history", 100, "latency samples and transitions kept per proxy")
+		statusPath  = fs.String("status", "watch.json", "status file, the history in it is continued and it is rewritten after every round")
+		eventsPath  = fs.String("events", "", "append events to this file as json lines")
+		downAfter   = fs.Int("down-after", 2, "failed checks in a row before an up proxy is down")
+		minUptime   = fs.Float64("min-uptime", 0, "raise uptime_low below this percentage, 0 disables")
+		minChecks   = fs.Int("min-checks", 10, "checks before -min-uptime applies")
+		maxLatency  = fs.Duration("max-latency", 0, "raise latency_high when the median latency exceeds this, 0 disables")
+		ipAPIs      = fs.String("ip-apis", "", "ip check api definitions finding the egress address, see proxy/ipapis.yaml")
+		echoServer  = fs.String("echo-server", "", "self-hosted echo server checked against instead of third-party services, replaces -ip-apis")
+	)
+	setupLog := logFlags(fs)
+	_ = fs.Parse(args)
+	setupLog()
+
+	if *ipAPIs != "" {
+		apis, err := proxy.LoadIPCheckAPIs(*ipAPIs)
+		if err != nil {
+			watchLog.Error("failed to load ip apis", "err", err)
+			os.Exit(1)
+		}
+		proxy.IPCheckAPIs = apis
+	}
+	if *echoServer != "" {
+		if err := useEchoServer(*echoServer, 0); err != nil {
+			watchLog.Error("failed to use echo server", "err", err)
+			os.Exit(1)
+		}
+	}
+	addrs, err := watchTargets(*dbPath, *file)
+	if err != nil {
+		watchLog.Error("failed to read proxies", "err", err)
+		os.Exit(1)
+	}
+	if len(addrs) == 0 {
+		watchLog.Error("no proxies to watch")
+		os.Exit(1)
+	}
+	prev, err := readStatus(*statusPath)
+	if err != nil {
+		watchLog.Error("failed to read status", "err", err)
+		os.Exit(1)
+	}
+
+	w := watch.New(addrs, prev)
+	w.Interval = *interval
+	w.Jitter = *jitter
+	w.Concurrency = *concurrency
+	w.History = *history
+	w.Thresholds = watch.Thresholds{
+		DownAfter:  *downAfter,
+		MinUptime:  *minUptime,
+		MinChecks:  *minChecks,
+		MaxLatency: float64(*maxLatency) / float64(time.Millisecond),
+	}
+	if *eventsPath != "" {
+		f, err := os.OpenFile(*eventsPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			watchLog.Error("failed to open events file", "err", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		enc := json.NewEncoder(f)
+		w.OnEvent = func(e watch.Event) {
+			if err := enc.Encode(e); err != nil {
+				watchLog.Warn("failed to write event", "err", err)
+			}
+		}
+	}
+	w.OnRound = func(statuses []watch.Status) {
+		if err := writeStatus(*statusPath, statuses); err != nil {
+			watchLog.Warn("failed to write status", "err", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	watchLog.Info("watching proxies", "proxies", len(addrs), "interval", *interval)
+	w.Run(ctx, *rounds)
+}
+
+// watchTargets are the socks5 proxies of the database at dbPath or of file,
+// watch.Probe only checks socks5
+func watchTargets(dbPath, file string) ([]netip.AddrPort, error) {
+	switch {
+	case dbPath != "" && file != "":
+		return nil, errors.New("-db and -file are exclusive")
+	case dbPath != "":
+		db, err := store.Open(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		open := true
+		records, err := db.Query(store.Filter{Protocol: "socks5", Open: &open})
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]netip.AddrPort, 0, len(records))
+		for _, r := range records {
+			addrs = append(addrs, r.AddrPort)
+		}
+		return addrs, nil
+	case file != "":
+		proxies, err := readProxies(file)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]netip.AddrPort, 0, len(proxies))
+		for _, p := range proxies {
+			host, ok := strings.CutPrefix(p, proxy.ProxyURL(proxy.ProtocolSocks5, ""))
+			if !ok {
+				continue
+			}
+			addr, err := netip.ParseAddrPort(host)
+			if err != nil {
+				return nil, err
+			}
+			addrs = append(addrs, addr)
+		}
+		return addrs, nil
+	}
+	return nil, errors.New("-db or -file is required")
+}
+
+func readStatus(path string) ([]watch.Status, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var statuses []watch.Status
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// writeStatus replaces the file at path, a crash leaves the previous one
+func writeStatus(path string, statuses []watch.Status) error {
+	data, err := json.MarshalIndent(statuses, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
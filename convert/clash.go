@@ -15,6 +15,15 @@ type ClashSocks5Proxy struct {
 	Server string `yaml:"server"`
 	Port   int    `yaml:"port"`
 	Udp    bool   `yaml:"udp"`
+	// UDPStats is not a clash option, clash ignores it
+	UDPStats *ClashUDPStats `yaml:"udp-stats,omitempty"`
+}
+
+// ClashUDPStats is the UDP relay test of a proxy, see prober.UDPStats
+type ClashUDPStats struct {
+	RTT  float64 `yaml:"rtt-ms"`
+	Loss float64 `yaml:"loss"`
+	NAT  string  `yaml:"nat,omitempty"`
 }
 
 var ErrInvalidSocks5Result = errors.New("invalid input")
@@ -35,22 +44,47 @@ var clashTmpl = template.Must(template.New("clash").Funcs(template.FuncMap{
 [Unknown]{{ .AddrPort }}
 {{- end }}`))
 
+// ClashSupports reports whether clash can use proxies of protocol
+func ClashSupports(protocol string) bool {
+	return protocol == "socks5" || protocol == "http"
+}
+
 func ToClash(res *socks5.Result) *ClashSocks5Proxy {
+	return toClash(clashTmpl, res)
+}
+
+// ToClashGeo is ToClash with an already known geo, nil for unknown
+func ToClashGeo(res *socks5.Result, geo *geoip.GeoIP) *ClashSocks5Proxy {
+	tmpl := template.Must(clashTmpl.Clone()).Funcs(template.FuncMap{
+		"geo": func(string) *geoip.GeoIP { return geo },
+	})
+	return toClash(tmpl, res)
+}
+
+func toClash(tmpl *template.Template, res *socks5.Result) *ClashSocks5Proxy {
 	var (
 		buf  bytes.Buffer
 		name string
 	)
-	if err := clashTmpl.Execute(&buf, res); err != nil {
+	if err := tmpl.Execute(&buf, res); err != nil {
 		name = fmt.Sprintf("[Unknown]%s", res.AddrPort.String())
 	} else {
 		name = buf.String()
 	}
 
-	return &ClashSocks5Proxy{
+	typ := res.Protocol
+	if typ == "" {
+		typ = "socks5"
+	}
+	c := &ClashSocks5Proxy{
 		Name:   name,
-		Type:   "socks5",
+		Type:   typ,
 		Server: res.AddrPort.Addr().String(),
 		Port:   int(res.AddrPort.Port()),
 		Udp:    res.UDP,
 	}
+	if res.UDP && res.UDPStats != nil {
+		c.UDPStats = &ClashUDPStats{RTT: res.UDPStats.RTTAvg, Loss: res.UDPStats.Loss, NAT: res.UDPStats.NAT}
+	}
+	return c
 }
@@ -2,22 +2,39 @@ package convert
 
 import (
 	"bytes"
+	"github.com/dn-11/proxyScan/scan/geoip"
+	"github.com/dn-11/proxyScan/scan/prober"
 	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
 	"net/netip"
 	"testing"
 )
 
+// useSimnet points geoip at n until the test ends
+func useSimnet(t *testing.T, n *simnet.Net) {
+	old := geoip.CloudFlareURL
+	t.Cleanup(func() { geoip.CloudFlareURL = old })
+	geoip.CloudFlareURL = n.Web.URL("/cf/geo")
+}
+
 func TestClashTmpl(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	p := n.NewProxy(simnet.Socks5)
 	var buf bytes.Buffer
 	err := clashTmpl.Execute(&buf, &socks5.Result{
-		AddrPort: netip.MustParseAddrPort("127.0.0.1:7890"),
+		AddrPort: p.AddrPort(),
 		Success:  true,
 		UDP:      true,
 	})
 	if err != nil {
 		t.Error(err)
 	}
-	t.Log(buf.String())
+	assert.Equal(t, "[CN-Nanjing]CERNET("+p.Addr()+")", buf.String())
 }
 
 func TestClashTmpl2(t *testing.T) {
@@ -30,5 +47,41 @@ func TestClashTmpl2(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	t.Log(buf.String())
+	assert.Equal(t, "[Unknown]127.0.0.1:1", buf.String())
+}
+
+func TestToClash(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	p := n.NewProxy(simnet.Socks5UDP)
+	c := ToClash(&socks5.Result{AddrPort: p.AddrPort(), Success: true, UDP: true})
+	assert.Equal(t, "socks5", c.Type)
+	assert.Equal(t, "127.0.0.1", c.Server)
+	assert.Equal(t, int(p.AddrPort().Port()), c.Port)
+	assert.True(t, c.Udp)
+}
+
+func TestToClashGeo(t *testing.T) {
+	res := &socks5.Result{AddrPort: netip.MustParseAddrPort("10.0.0.1:7890"), Success: true}
+	assert.Equal(t, "[CN]AS4538(10.0.0.1:7890)", ToClashGeo(res, &geoip.GeoIP{Country: "CN", ASOrg: "AS4538"}).Name)
+	assert.Equal(t, "[Unknown]10.0.0.1:7890", ToClashGeo(res, nil).Name)
+}
+
+func TestToClashUDPStats(t *testing.T) {
+	res := &socks5.Result{AddrPort: netip.MustParseAddrPort("10.0.0.1:7890"), Success: true, UDP: true,
+		UDPStats: &prober.UDPStats{RTTAvg: 12, Loss: 0.2, NAT: prober.NATEndpointIndependent}}
+	c := ToClashGeo(res, nil)
+	assert.Equal(t, &ClashUDPStats{RTT: 12, Loss: 0.2, NAT: prober.NATEndpointIndependent}, c.UDPStats)
+	data, err := yaml.Marshal(c)
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(data), "udp-stats:\n    rtt-ms: 12\n    loss: 0.2\n    nat: endpoint-independent\n")
+	}
+
+	res.UDPStats = nil
+	c = ToClashGeo(res, nil)
+	assert.Nil(t, c.UDPStats)
+	data, _ = yaml.Marshal(c)
+	assert.NotContains(t, string(data), "udp-stats")
 }
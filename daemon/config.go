@@ -0,0 +1,409 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/dn-11/proxyScan/echo"
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan/ports"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the daemon configuration file
+//
+//	db: proxyscan.db
+//	scanner: pcap
+//	api:
+//	  listen: 127.0.0.1:8080
+//	  token: secret
+//	metrics: 127.0.0.1:9090
+//	top_ports: top.txt
+//	udp:
+//	  targets:
+//	    - {kind: dns, addr: 1.1.1.1:53}
+//	  packets: 5
+//	  nat_echo: [203.0.113.1:3478, 203.0.113.1:3479]
+//	dns_leak:
+//	  zone: leak.example.org
+//	  target: 203.0.113.1:8053
+//	integrity: resources.yaml
+//	checks: checks.yaml
+//	ip_apis: ipapis.yaml
+//	echo_url: http://203.0.113.1:8080/headers
+//	echo_server:
+//	  url: http://203.0.113.1:8080/TOKEN
+//	  nat_port: 8081
+//	tester:
+//	  speed_url: https://speed.cloudflare.com/__down?bytes=10000000
+//	  latency_urls: [http://cp.cloudflare.com/generate_204]
+//	  latency_samples: 5
+//	  speed_budget: 100
+//	  speed_link: 1000
+//	  speed_concurrency: 2
+//	  speed_max_bytes: 10485760
+//	  speed_max_time: 10s
+//	  upload_url: https://speed.cloudflare.com/__up
+//	jobs:
+//	  - name: campus
+//	    prefix: 10.0.0.0/16,10.1.0.0/16
+//	    ports: clash,v2ray
+//	    rate: 3000
+//	    schedule: "0 3 * * 0"
+//	    verify_schedule: "@every 1h"
+//	    report: true
+type Config struct {
+	DB      string `yaml:"db"`
+	Scanner string `yaml:"scanner"`
+	API     API    `yaml:"api"`
+	// Metrics serves prometheus metrics on this address when set
+	Metrics string  `yaml:"metrics"`
+	UDP     UDP     `yaml:"udp"`
+	DNSLeak DNSLeak `yaml:"dns_leak"`
+	// Integrity is a resource file, see proxy.LoadResources, the reports
+	// check every proxy for tampering with them
+	Integrity string           `yaml:"integrity"`
+	Resources []proxy.Resource `yaml:"-"`
+	// ChecksFile lists destinations the reports try through every proxy,
+	// see proxy.LoadChecks
+	ChecksFile string        `yaml:"checks"`
+	Checks     []proxy.Check `yaml:"-"`
+	// IPAPIs replaces the ip check apis of the reports, see
+	// proxy.LoadIPCheckAPIs
+	IPAPIs      string             `yaml:"ip_apis"`
+	IPCheckAPIs []proxy.IPCheckAPI `yaml:"-"`
+	// EchoURL is the header echo classifying the anonymity of proxies
+	EchoURL string `yaml:"echo_url"`
+	// EchoServer replaces the third-party services with a self-hosted
+	// echo server, see echo.Handler
+	EchoServer EchoServer `yaml:"echo_server"`
+	Tester     Tester     `yaml:"tester"`
+	// TopPorts replaces the port list of top:N, see ports.ReadFile,
+	// otherwise it is ranked by the hits in the database, see ApplyPorts
+	TopPorts string `yaml:"top_ports"`
+	Top      []int  `yaml:"-"`
+	Jobs     []*Job `yaml:"jobs"`
+}
+
+// Tester configures the endpoints of the proxy reports, empty fields keep
+// the defaults of the proxy package
+type Tester struct {
+	SpeedURL       string   `yaml:"speed_url"`
+	LatencyURLs    []string `yaml:"latency_urls"`
+	LatencySamples int      `yaml:"latency_samples"`
+	// SpeedBudget is in Mbit/s, shared by all speed tests, SpeedLink is
+	// the local link in Mbit/s
+	SpeedBudget      float64       `yaml:"speed_budget"`
+	SpeedLink        float64       `yaml:"speed_link"`
+	SpeedConcurrency int           `yaml:"speed_concurrency"`
+	SpeedMaxBytes    int64         `yaml:"speed_max_bytes"`
+	SpeedMaxTime     time.Duration `yaml:"speed_max_time"`
+	UploadURL        string        `yaml:"upload_url"`
+}
+
+// Apply sets the endpoints of the proxy package
+func (t Tester) Apply() {
+	if t.SpeedURL != "" {
+		proxy.SpeedTestURL = t.SpeedURL
+	}
+	if len(t.LatencyURLs) > 0 {
+		proxy.LatencyURLs = t.LatencyURLs
+	}
+	if t.LatencySamples > 0 {
+		proxy.LatencySamples = t.LatencySamples
+	}
+	proxy.SpeedBudget = proxy.MbpsToBytes(t.SpeedBudget)
+	proxy.SpeedLink = proxy.MbpsToBytes(t.SpeedLink)
+	if t.SpeedConcurrency > 0 {
+		proxy.SpeedConcurrency = t.SpeedConcurrency
+	}
+	if t.SpeedMaxBytes > 0 {
+		proxy.SpeedMaxBytes = t.SpeedMaxBytes
+	}
+	if t.SpeedMaxTime > 0 {
+		proxy.SpeedMaxTime = t.SpeedMaxTime
+	}
+	proxy.SpeedUploadURL = t.UploadURL
+}
+
+// EchoServer is used when URL is set, NATPort is its second udp port
+type EchoServer struct {
+	URL     string `yaml:"url"`
+	NATPort int    `yaml:"nat_port"`
+}
+
+func (e *EchoServer) validate() error {
+	if e.URL == "" {
+		return nil
+	}
+	if _, err := echo.ParseEndpoints(e.URL); err != nil {
+		return err
+	}
+	if e.NATPort < 0 || e.NATPort > 65535 {
+		return fmt.Errorf("nat_port %d out of range", e.NATPort)
+	}
+	return nil
+}
+
+// DNSLeak serves the zone of the remote dns test when Zone is set, see
+// package dnsleak
+type DNSLeak struct {
+	Zone string `yaml:"zone"`
+	// Listen is the dns address, :53 when empty
+	Listen string `yaml:"listen"`
+	// Target is the public address the names of the zone resolve to, the
+	// http check is served on its port
+	Target string `yaml:"target"`
+}
+
+func (l *DNSLeak) validate() error {
+	if l.Zone == "" {
+		return nil
+	}
+	if l.Listen == "" {
+		l.Listen = ":53"
+	}
+	if _, err := netip.ParseAddrPort(l.Target); err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	return nil
+}
+
+// UDP configures the UDP relay test of socks5 proxies, empty fields keep
+// the defaults of the socks5 package
+type UDP struct {
+	Targets []socks5.UDPTarget `yaml:"targets"`
+	Packets int                `yaml:"packets"`
+	// NATEcho are two echo servers classifying the NAT of a proxy
+	NATEcho []string `yaml:"nat_echo"`
+}
+
+// Apply sets the UDP test options of the socks5 package
+func (u UDP) Apply() {
+	if len(u.Targets) > 0 {
+		socks5.UDPTargets = u.Targets
+	}
+	if u.Packets > 0 {
+		socks5.UDPPackets = u.Packets
+	}
+	if len(u.NATEcho) == 2 {
+		socks5.NATEcho = [2]string(u.NATEcho)
+	}
+}
+
+func (u UDP) validate() error {
+	for _, t := range u.Targets {
+		if _, err := socks5.ParseUDPTargets(t.String()); err != nil {
+			return err
+		}
+	}
+	if u.Packets < 0 {
+		return errors.New("packets must be >=0")
+	}
+	if len(u.NATEcho) != 0 && len(u.NATEcho) != 2 {
+		return errors.New("nat_echo needs two echo servers")
+	}
+	return nil
+}
+
+// API enables the HTTP control API when Listen is set
+type API struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+}
+
+// Job is one scheduled scan. Schedule drives full sweeps of the targets,
+// VerifySchedule re-checks only the endpoints already known to be open.
+// Both accept cron expressions and descriptors like @every 1h or @daily.
+type Job struct {
+	Name           string `yaml:"name"`
+	Prefix         string `yaml:"prefix"`
+	TargetsFile    string `yaml:"targets_file"`
+	Ports          string `yaml:"ports"`
+	Rate           int    `yaml:"rate"`
+	Schedule       string `yaml:"schedule"`
+	VerifySchedule string `yaml:"verify_schedule"`
+	// Report runs ProxyTester on confirmed proxies after each run
+	Report bool `yaml:"report"`
+
+	groups   []target.Group
+	schedule cron.Schedule
+	verify   cron.Schedule
+}
+
+// Groups returns the scan groups of the job
+func (j *Job) Groups() []target.Group {
+	return j.groups
+}
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// LoadConfig reads and validates the configuration at path
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate fills defaults, parses targets and schedules of every job
+func (c *Config) Validate() error {
+	if c.DB == "" {
+		c.DB = "proxyscan.db"
+	}
+	if c.Scanner == "" {
+		c.Scanner = "system"
+	}
+	if err := c.UDP.validate(); err != nil {
+		return fmt.Errorf("udp: %w", err)
+	}
+	if err := c.DNSLeak.validate(); err != nil {
+		return fmt.Errorf("dns_leak: %w", err)
+	}
+	if c.Integrity != "" {
+		var err error
+		if c.Resources, err = proxy.LoadResources(c.Integrity); err != nil {
+			return fmt.Errorf("integrity: %w", err)
+		}
+	}
+	if c.ChecksFile != "" {
+		var err error
+		if c.Checks, err = proxy.LoadChecks(c.ChecksFile); err != nil {
+			return fmt.Errorf("checks: %w", err)
+		}
+	}
+	if c.IPAPIs != "" {
+		var err error
+		if c.IPCheckAPIs, err = proxy.LoadIPCheckAPIs(c.IPAPIs); err != nil {
+			return fmt.Errorf("ip_apis: %w", err)
+		}
+	}
+	if err := c.EchoServer.validate(); err != nil {
+		return fmt.Errorf("echo_server: %w", err)
+	}
+	if c.EchoURL != "" {
+		if err := proxy.ValidateEchoURL(c.EchoURL); err != nil {
+			return err
+		}
+	}
+	if len(c.Jobs) == 0 {
+		return errors.New("no job")
+	}
+	if c.TopPorts != "" {
+		var err error
+		if c.Top, err = ports.ReadFile(c.TopPorts); err != nil {
+			return fmt.Errorf("top_ports: %w", err)
+		}
+	}
+	names := make(map[string]struct{}, len(c.Jobs))
+	for _, j := range c.Jobs {
+		if j.Name == "" {
+			return errors.New("job without name")
+		}
+		if _, ok := names[j.Name]; ok {
+			return fmt.Errorf("duplicate job %q", j.Name)
+		}
+		names[j.Name] = struct{}{}
+		if err := j.validate(); err != nil {
+			return fmt.Errorf("job %s: %w", j.Name, err)
+		}
+	}
+	return nil
+}
+
+// ApplyPorts sets ports.Top to the list of TopPorts, or else to the ports
+// ranked by hits, see store.PortHits, and plans the jobs again with it
+func (c *Config) ApplyPorts(hits map[int]int) error {
+	if c.Top != nil {
+		ports.Top = c.Top
+	} else {
+		ports.Top = ports.Rank(hits)
+	}
+	for _, j := range c.Jobs {
+		if err := j.plan(); err != nil {
+			return fmt.Errorf("job %s: %w", j.Name, err)
+		}
+	}
+	return nil
+}
+
+func (j *Job) validate() error {
+	if j.Ports == "" {
+		j.Ports = ports.Default
+	}
+	if j.Rate == 0 {
+		j.Rate = 3000
+	}
+	if !(j.Rate == -1 || j.Rate > 0) {
+		return errors.New("rate must be -1 or >0")
+	}
+	if err := j.plan(); err != nil {
+		return err
+	}
+
+	var err error
+	if j.Schedule == "" {
+		return errors.New("no schedule")
+	}
+	if j.schedule, err = cronParser.Parse(j.Schedule); err != nil {
+		return fmt.Errorf("schedule: %w", err)
+	}
+	if j.VerifySchedule != "" {
+		if j.verify, err = cronParser.Parse(j.VerifySchedule); err != nil {
+			return fmt.Errorf("verify schedule: %w", err)
+		}
+	}
+	return nil
+}
+
+// plan parses the targets of the job, top:N takes the current ports.Top
+func (j *Job) plan() error {
+	prefixes, err := target.ParseList(j.Prefix)
+	if err != nil {
+		return fmt.Errorf("prefix: %w", err)
+	}
+	var entries []target.Entry
+	if j.TargetsFile != "" {
+		if entries, err = target.ReadFile(j.TargetsFile); err != nil {
+			return fmt.Errorf("targets file: %w", err)
+		}
+	}
+	if j.groups, err = target.Plan(prefixes, j.Ports, entries); err != nil {
+		return err
+	}
+	if len(j.groups) == 0 {
+		return errors.New("no target")
+	}
+	return nil
+}
+
+// contains reports whether addr is scanned by the job
+func (j *Job) contains(addr netip.AddrPort) bool {
+	for _, g := range j.groups {
+		for _, p := range g.Prefixes {
+			if !p.Contains(addr.Addr()) {
+				continue
+			}
+			for _, pt := range g.Ports {
+				if pt == int(addr.Port()) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
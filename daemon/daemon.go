@@ -0,0 +1,288 @@
+// Package daemon runs scan jobs on cron-style schedules and keeps their
+// results in the results database.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan"
+	"github.com/dn-11/proxyScan/scan/geoip"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/dn-11/proxyScan/store"
+	"github.com/robfig/cron/v3"
+)
+
+var logger = logging.New("daemon")
+
+type Daemon struct {
+	// OnFound is called for every endpoint confirmed by a run of job
+	OnFound func(job string, f store.Found)
+
+	cfg  *Config
+	db   *store.Store
+	cron *cron.Cron
+	// ctx is cancelled by Stop, interrupting the running scan
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// scans share the network and the pcap handle, run one at a time
+	mu sync.Mutex
+}
+
+// New opens the results database of cfg, cfg must be validated
+func New(cfg *Config) (*Daemon, error) {
+	db, err := store.Open(cfg.DB)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Daemon{cfg: cfg, db: db, ctx: ctx, cancel: cancel}, nil
+}
+
+// DB is the results database shared by every job
+func (d *Daemon) DB() *store.Store {
+	return d.db
+}
+
+// Locker is held while a job runs, other scans sharing the network take it too
+func (d *Daemon) Locker() sync.Locker {
+	return &d.mu
+}
+
+// Jobs returns the configured jobs
+func (d *Daemon) Jobs() []*Job {
+	return d.cfg.Jobs
+}
+
+// Job returns the job called name, or nil
+func (d *Daemon) Job(name string) *Job {
+	for _, j := range d.cfg.Jobs {
+		if j.Name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// Start schedules every job. Runs that were missed while the daemon was
+// down are started right away.
+func (d *Daemon) Start() {
+	d.cron = cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	now := time.Now()
+	for _, j := range d.cfg.Jobs {
+		d.cron.Schedule(j.schedule, cron.FuncJob(func() { d.runSweep(j) }))
+		if j.verify != nil {
+			d.cron.Schedule(j.verify, cron.FuncJob(func() { d.runVerify(j) }))
+		}
+
+		st, err := d.db.JobState(j.Name)
+		if err != nil {
+			logger.Error("load job state", "job", j.Name, "err", err)
+			continue
+		}
+		switch {
+		case st.LastSweep.IsZero() || j.schedule.Next(st.LastSweep).Before(now):
+			logger.Info("sweep missed, starting now", "job", j.Name)
+			go d.runSweep(j)
+		case j.verify != nil && j.verify.Next(maxTime(st.LastVerify, st.LastSweep)).Before(now):
+			logger.Info("verify missed, starting now", "job", j.Name)
+			go d.runVerify(j)
+		}
+	}
+	d.cron.Start()
+}
+
+// Stop stops scheduling, interrupts the running job and waits for it to
+// record what it found, then closes the database
+func (d *Daemon) Stop() {
+	d.cancel()
+	if d.cron != nil {
+		<-d.cron.Stop().Done()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.db.Close(); err != nil {
+		logger.Error("close results db", "err", err)
+	}
+}
+
+func (d *Daemon) runSweep(j *Job) {
+	if _, err := d.Sweep(j); err != nil && !d.interrupted(err) {
+		logger.Error("sweep failed", "job", j.Name, "err", err)
+	}
+}
+
+func (d *Daemon) runVerify(j *Job) {
+	if _, err := d.Verify(j); err != nil && !d.interrupted(err) {
+		logger.Error("verify failed", "job", j.Name, "err", err)
+	}
+}
+
+// interrupted reports whether err comes from Stop cancelling a job
+func (d *Daemon) interrupted(err error) bool {
+	return d.ctx.Err() != nil && errors.Is(err, context.Canceled)
+}
+
+// Sweep scans every target of j and records the results. A sweep
+// interrupted by Stop records what it found and returns context.Canceled.
+func (d *Daemon) Sweep(j *Job) (*store.ScanRun, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	logger.Info("sweep started", "job", j.Name, "probes", target.CountProbes(j.groups))
+	start := time.Now()
+	found, err := d.scan(j, j.Name, j.groups, true)
+	stopped := d.interrupted(err)
+	if err != nil && !stopped {
+		return nil, err
+	}
+	covered := j.groups
+	if stopped {
+		// the targets were not all scanned, none is taken as closed
+		covered = nil
+	}
+	run, err := d.db.RecordScan(j.Name, start, covered, found)
+	if err != nil {
+		return nil, err
+	}
+	if stopped {
+		logger.Warn("sweep interrupted", "job", j.Name, "run", run.ID, "found", run.Found, "new", run.New)
+		return run, d.ctx.Err()
+	}
+	logger.Info("sweep done", "job", j.Name, "run", run.ID, "found", run.Found, "new", run.New, "closed", run.Closed)
+
+	err = d.updateState(j, func(st *store.JobState) { st.LastSweep = start })
+	d.report(j, found)
+	return run, err
+}
+
+// Verify re-checks the endpoints of j that are known to be open, like
+// Sweep an interrupted one records what it found
+func (d *Daemon) Verify(j *Job) (*store.ScanRun, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	open := true
+	records, err := d.db.Query(store.Filter{Open: &open})
+	if err != nil {
+		return nil, err
+	}
+	byPort := make(map[int][]netip.Prefix)
+	for _, r := range records {
+		if j.contains(r.AddrPort) {
+			port := int(r.AddrPort.Port())
+			byPort[port] = append(byPort[port], netip.PrefixFrom(r.AddrPort.Addr(), 32))
+		}
+	}
+	groups := make([]target.Group, 0, len(byPort))
+	for port, prefixes := range byPort {
+		groups = append(groups, target.Group{Prefixes: prefixes, Ports: []int{port}})
+	}
+
+	logger.Info("verify started", "job", j.Name, "endpoints", target.CountProbes(groups))
+	start := time.Now()
+	name := j.Name + ":verify"
+	var found []store.Found
+	stopped := false
+	if len(groups) > 0 {
+		found, err = d.scan(j, name, groups, false)
+		if stopped = d.interrupted(err); err != nil && !stopped {
+			return nil, err
+		}
+	}
+	covered := groups
+	if stopped {
+		covered = nil
+	}
+	run, err := d.db.RecordScan(name, start, covered, found)
+	if err != nil {
+		return nil, err
+	}
+	if stopped {
+		logger.Warn("verify interrupted", "job", j.Name, "run", run.ID, "open", run.Found)
+		return run, d.ctx.Err()
+	}
+	logger.Info("verify done", "job", j.Name, "run", run.ID, "open", run.Found, "closed", run.Closed)
+
+	err = d.updateState(j, func(st *store.JobState) { st.LastVerify = start })
+	d.report(j, found)
+	return run, err
+}
+
+// scan runs groups with the settings of j. Verification passes geo=false,
+// the database keeps the geo found by the sweep. An interrupted scan
+// returns what it found with the error.
+func (d *Daemon) scan(j *Job, name string, groups []target.Group, geo bool) ([]store.Found, error) {
+	var mu sync.Mutex
+	byAddr := make(map[netip.AddrPort]store.Found)
+
+	s := scan.Default()
+	s.ScannerType = d.cfg.Scanner
+	s.PortScanRate = j.Rate
+	s.OnFound = func(res *socks5.Result) {
+		f := store.Found{Result: res, Protocol: res.Protocol}
+		if geo && res.Success {
+			f.Geo, _ = geoip.GetGeoVia(res.Protocol, res.AddrPort.String())
+		}
+		mu.Lock()
+		byAddr[res.AddrPort] = f
+		mu.Unlock()
+		if d.OnFound != nil {
+			d.OnFound(name, f)
+		}
+	}
+	list, err := s.Run(d.ctx, groups)
+	found := make([]store.Found, 0, len(list))
+	for _, res := range list {
+		found = append(found, byAddr[res.AddrPort])
+	}
+	return found, err
+}
+
+func (d *Daemon) updateState(j *Job, f func(st *store.JobState)) error {
+	st, err := d.db.JobState(j.Name)
+	if err != nil {
+		return err
+	}
+	f(&st)
+	return d.db.SetJobState(j.Name, st)
+}
+
+func (d *Daemon) report(j *Job, found []store.Found) {
+	if !j.Report {
+		return
+	}
+	var proxies []string
+	for _, f := range found {
+		if f.Result.Success && proxy.Supports(f.Protocol) {
+			proxies = append(proxies, proxy.ProxyURL(f.Protocol, f.Result.AddrPort.String()))
+		}
+	}
+	if len(proxies) == 0 {
+		return
+	}
+	results := proxy.NewProxyTester(d.ctx, proxies).Run()
+	if err := d.db.RecordTests(results); err != nil {
+		logger.Error("store test results", "job", j.Name, "err", err)
+	}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
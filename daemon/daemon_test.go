@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/ports"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/dn-11/proxyScan/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "serve.yaml")
+	write := func(s string) {
+		assert.NoError(t, os.WriteFile(path, []byte(s), 0644))
+	}
+
+	write(`
+jobs:
+  - name: campus
+    prefix: 10.0.0.0/24
+    schedule: "0 3 * * 0"
+    verify_schedule: "@every 1h"
+`)
+	cfg, err := LoadConfig(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "proxyscan.db", cfg.DB)
+		assert.Equal(t, 3000, cfg.Jobs[0].Rate)
+		assert.Len(t, cfg.Jobs[0].Groups(), 1)
+	}
+
+	for _, bad := range []string{
+		"jobs: []",
+		"jobs: [{name: a, prefix: 10.0.0.0/24}]",
+		"jobs: [{name: a, prefix: 10.0.0.0/24, schedule: 'every day'}]",
+		"jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily', ports: '0'}]",
+		"jobs: [{name: a, schedule: '@daily'}]",
+		"jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}, {name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]",
+		"{udp: {targets: [{kind: stun, addr: '1.1.1.1:3478'}]}, jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+		"{udp: {nat_echo: ['1.1.1.1:3478']}, jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+		"{dns_leak: {zone: leak.example.org}, jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+		"{echo_url: 'https://example.org/headers', jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+		"{ip_apis: missing.yaml, jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+		"{echo_server: {url: 'https://203.0.113.1'}, jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+		"{checks: missing.yaml, jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+		"{top_ports: missing.txt, jobs: [{name: a, prefix: 10.0.0.0/24, schedule: '@daily'}]}",
+	} {
+		write(bad)
+		_, err := LoadConfig(path)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestApplyPorts(t *testing.T) {
+	old := ports.Top
+	t.Cleanup(func() { ports.Top = old })
+
+	cfg := &Config{Jobs: []*Job{{Name: "a", Prefix: "10.0.0.1", Ports: "top:2", Schedule: "@daily"}}}
+	if !assert.NoError(t, cfg.Validate()) {
+		return
+	}
+	assert.Equal(t, old, ports.Top)
+	assert.NoError(t, cfg.ApplyPorts(map[int]int{8443: 3, 1080: 1}))
+	assert.Equal(t, []int{8443, 1080}, cfg.Jobs[0].Groups()[0].Ports)
+
+	// a list of top_ports wins over the hits
+	path := filepath.Join(t.TempDir(), "top.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("7890\n2080\n"), 0644))
+	cfg.TopPorts = path
+	if !assert.NoError(t, cfg.Validate()) {
+		return
+	}
+	assert.NoError(t, cfg.ApplyPorts(map[int]int{8443: 3}))
+	assert.Equal(t, []int{7890, 2080}, cfg.Jobs[0].Groups()[0].Ports)
+}
+
+func TestSweepAndVerify(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	p1 := n.NewProxy(simnet.Socks5UDP)
+	p2 := n.NewProxy(simnet.Socks5)
+	n.RegisterScanner()
+
+	cfg := &Config{
+		DB:      filepath.Join(t.TempDir(), "results.db"),
+		Scanner: simnet.ScannerName,
+		Jobs: []*Job{{
+			Name:           "local",
+			Prefix:         "127.0.0.1",
+			Ports:          strings.Trim(fmt.Sprint(p1.AddrPort().Port(), ",", p2.AddrPort().Port()), " "),
+			Schedule:       "@daily",
+			VerifySchedule: "@hourly",
+		}},
+	}
+	if !assert.NoError(t, cfg.Validate()) {
+		return
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+
+	run, err := d.Sweep(d.Job("local"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, run.New)
+
+	p2.Close()
+	run, err = d.Verify(d.Job("local"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), run.Probes)
+	assert.Equal(t, 1, run.Closed)
+
+	rec, err := d.DB().Get(p2.AddrPort())
+	assert.NoError(t, err)
+	assert.False(t, rec.Open)
+
+	st, err := d.DB().JobState("local")
+	assert.NoError(t, err)
+	assert.False(t, st.LastSweep.IsZero())
+	assert.False(t, st.LastVerify.IsZero())
+
+	scans, err := d.DB().Scans()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"local", "local:verify"}, []string{scans[0].Job, scans[1].Job})
+}
+
+func TestStopInterruptsSweep(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	fast := n.NewProxy(simnet.Socks5)
+	slow := n.NewProxy(simnet.Behaviour{Socks5: true, Delay: 3 * time.Second})
+	n.RegisterScanner()
+
+	cfg := &Config{
+		DB:      filepath.Join(t.TempDir(), "results.db"),
+		Scanner: simnet.ScannerName,
+		Jobs: []*Job{{
+			Name:     "local",
+			Prefix:   "127.0.0.1",
+			Ports:    strings.Trim(fmt.Sprint(fast.AddrPort().Port(), ",", slow.AddrPort().Port()), " "),
+			Schedule: "@daily",
+		}},
+	}
+	if !assert.NoError(t, cfg.Validate()) {
+		return
+	}
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed := make(chan struct{})
+	d.OnFound = func(_ string, f store.Found) {
+		if f.Result.AddrPort == fast.AddrPort() {
+			close(confirmed)
+		}
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := d.Sweep(d.Job("local"))
+		errc <- err
+	}()
+	<-confirmed
+
+	start := time.Now()
+	d.Stop()
+	assert.Less(t, time.Since(start), 2*time.Second)
+	assert.ErrorIs(t, <-errc, context.Canceled)
+
+	db, err := store.Open(cfg.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rec, err := db.Get(fast.AddrPort())
+	if assert.NoError(t, err) {
+		assert.True(t, rec.Open)
+	}
+	// the sweep runs again on the next start
+	st, err := db.JobState("local")
+	assert.NoError(t, err)
+	assert.True(t, st.LastSweep.IsZero())
+}
@@ -0,0 +1,351 @@
+// Package dashboard shows the progress of a scan. On a terminal it redraws
+// a full screen view, otherwise it prints a compact status line periodically.
+// Per address log lines are hidden while it runs.
+package dashboard
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
+	"github.com/dn-11/proxyScan/scan"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	"golang.org/x/term"
+)
+
+var (
+	// Refresh is the redraw interval on a terminal
+	Refresh = 500 * time.Millisecond
+	// StatusInterval is the interval of status lines without a terminal
+	StatusInterval = 10 * time.Second
+	// Newest is the number of rows of the newest proxies table
+	Newest = 10
+	// TopPrefixes is the number of rows of the per prefix table
+	TopPrefixes = 8
+)
+
+type row struct {
+	addr netip.AddrPort
+	udp  bool
+	auth bool
+	at   time.Time
+}
+
+type prefixStat struct {
+	prefix  netip.Prefix
+	ips     uint64
+	open    int
+	proxies int
+}
+
+type Dashboard struct {
+	out  *os.File
+	tty  bool
+	rate int
+
+	start   time.Time
+	current atomic.Int64
+	all     atomic.Int64
+	open    atomic.Int64
+	proxies atomic.Int64
+
+	// rate sampling, only touched by the draw loop
+	lastProbes float64
+	lastAt     time.Time
+	pps        float64
+
+	mu        sync.Mutex
+	newest    []row
+	prefixes  []*prefixStat // sorted by address
+	logs      []string
+	logOut    io.Writer
+	logFilter logging.Filter
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a dashboard for a scan of groups at rate, drawn on out
+func New(out *os.File, groups []target.Group, rate int) *Dashboard {
+	d := &Dashboard{
+		out:  out,
+		tty:  term.IsTerminal(int(out.Fd())),
+		rate: rate,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, g := range groups {
+		for _, p := range g.Prefixes {
+			d.prefixes = append(d.prefixes, &prefixStat{prefix: p, ips: target.Count([]netip.Prefix{p})})
+		}
+	}
+	slices.SortFunc(d.prefixes, func(a, b *prefixStat) int {
+		return a.prefix.Addr().Compare(b.prefix.Addr())
+	})
+	return d
+}
+
+// Attach feeds the dashboard from the hooks of s, hooks already set are kept
+func (d *Dashboard) Attach(s *scan.Scanner) {
+	onProgress, onOpen, onFound := s.OnProgress, s.OnOpen, s.OnFound
+	s.OnProgress = func(current, all int) {
+		d.current.Store(int64(current))
+		d.all.Store(int64(all))
+		if onProgress != nil {
+			onProgress(current, all)
+		}
+	}
+	s.OnOpen = func(addr netip.AddrPort) {
+		d.Open(addr)
+		if onOpen != nil {
+			onOpen(addr)
+		}
+	}
+	s.OnFound = func(res *socks5.Result) {
+		d.Found(res)
+		if onFound != nil {
+			onFound(res)
+		}
+	}
+}
+
+// Open counts an open port
+func (d *Dashboard) Open(addr netip.AddrPort) {
+	d.open.Add(1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p := d.prefixOf(addr.Addr()); p != nil {
+		p.open++
+	}
+}
+
+// Found counts a confirmed proxy, ports with findings only are skipped
+func (d *Dashboard) Found(res *socks5.Result) {
+	if !res.Found() {
+		return
+	}
+	d.proxies.Add(1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p := d.prefixOf(res.AddrPort.Addr()); p != nil {
+		p.proxies++
+	}
+	d.newest = append(d.newest, row{addr: res.AddrPort, udp: res.UDP, auth: res.Auth, at: time.Now()})
+	if len(d.newest) > Newest {
+		d.newest = d.newest[len(d.newest)-Newest:]
+	}
+}
+
+func (d *Dashboard) prefixOf(addr netip.Addr) *prefixStat {
+	i, _ := slices.BinarySearchFunc(d.prefixes, addr, func(p *prefixStat, a netip.Addr) int {
+		return p.prefix.Addr().Compare(a)
+	})
+	// the candidate starts at or before addr
+	for _, j := range []int{i, i - 1} {
+		if j >= 0 && j < len(d.prefixes) && d.prefixes[j].prefix.Contains(addr) {
+			return d.prefixes[j]
+		}
+	}
+	return nil
+}
+
+// Start takes over the log output and draws until Stop
+func (d *Dashboard) Start() {
+	d.start = time.Now()
+	d.lastAt = d.start
+	d.lastProbes = metrics.Value(metrics.ProbesSent)
+	d.logOut = logging.SetOutput(logWriter{d})
+	d.logFilter = logging.SetFilter(quiet)
+
+	interval := StatusInterval
+	if d.tty {
+		interval = Refresh
+		fmt.Fprint(d.out, "\x1b[?25l") // hide cursor
+	}
+	go func() {
+		defer close(d.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-d.stop:
+				d.draw()
+				return
+			case <-t.C:
+				d.draw()
+			}
+		}
+	}()
+}
+
+// Stop draws a last time and gives the log output back
+func (d *Dashboard) Stop() {
+	close(d.stop)
+	<-d.done
+	if d.tty {
+		fmt.Fprint(d.out, "\x1b[?25h")
+	}
+	logging.SetOutput(d.logOut)
+	logging.SetFilter(d.logFilter)
+}
+
+func (d *Dashboard) draw() {
+	now := time.Now()
+	probes := metrics.Value(metrics.ProbesSent)
+	if dt := now.Sub(d.lastAt).Seconds(); dt > 0 {
+		d.pps = (probes - d.lastProbes) / dt
+	}
+	d.lastProbes, d.lastAt = probes, now
+
+	if d.tty {
+		fmt.Fprint(d.out, "\x1b[H\x1b[2J"+d.screen(now))
+	} else {
+		fmt.Fprintln(d.out, d.status(now))
+	}
+}
+
+func (d *Dashboard) progress(now time.Time) (ratio float64, eta string) {
+	current, all := d.current.Load(), d.all.Load()
+	if all == 0 {
+		return 0, "-"
+	}
+	ratio = float64(current) / float64(all)
+	if current == 0 {
+		return ratio, "-"
+	}
+	if current >= all {
+		return ratio, "done, verifying"
+	}
+	elapsed := now.Sub(d.start)
+	left := time.Duration(float64(elapsed) * float64(all-current) / float64(current))
+	return ratio, left.Round(time.Second).String()
+}
+
+func (d *Dashboard) targetRate() string {
+	if d.rate < 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(d.rate)
+}
+
+// status is the one line view used without a terminal
+func (d *Dashboard) status(now time.Time) string {
+	ratio, eta := d.progress(now)
+	return fmt.Sprintf("%s progress %.1f%% (%d/%d) eta %s rate %.0f/%s pps open %d proxies %d",
+		now.Format(time.DateTime), ratio*100, d.current.Load(), d.all.Load(), eta,
+		d.pps, d.targetRate(), d.open.Load(), d.proxies.Load())
+}
+
+func (d *Dashboard) screen(now time.Time) string {
+	width := 80
+	if w, _, err := term.GetSize(int(d.out.Fd())); err == nil && w > 40 {
+		width = w
+	}
+	var b strings.Builder
+	ratio, eta := d.progress(now)
+	bar := width - 30
+	fill := int(ratio * float64(bar))
+	fmt.Fprintf(&b, "proxyScan  elapsed %s\n\n", now.Sub(d.start).Round(time.Second))
+	fmt.Fprintf(&b, "[%s%s] %5.1f%%  eta %s\n", strings.Repeat("#", fill), strings.Repeat(".", bar-fill), ratio*100, eta)
+	fmt.Fprintf(&b, "ips %d/%d   rate %.0f/%s pps   open %d   proxies %d\n\n",
+		d.current.Load(), d.all.Load(), d.pps, d.targetRate(), d.open.Load(), d.proxies.Load())
+
+	d.mu.Lock()
+	fmt.Fprintf(&b, "%-22s %-5s %-5s %s\n", "NEWEST PROXIES", "UDP", "AUTH", "AT")
+	for i := len(d.newest) - 1; i >= 0; i-- {
+		r := d.newest[i]
+		fmt.Fprintf(&b, "%-22s %-5t %-5t %s\n", r.addr, r.udp, r.auth, r.at.Format(time.TimeOnly))
+	}
+
+	top := make([]*prefixStat, 0, len(d.prefixes))
+	for _, p := range d.prefixes {
+		if p.open > 0 {
+			top = append(top, p)
+		}
+	}
+	slices.SortStableFunc(top, func(a, b *prefixStat) int {
+		return cmp.Compare(b.hitRate(), a.hitRate())
+	})
+	if len(top) > TopPrefixes {
+		top = top[:TopPrefixes]
+	}
+	fmt.Fprintf(&b, "\n%-20s %10s %8s %8s %8s\n", "PREFIX", "IPS", "OPEN", "PROXIES", "HIT%")
+	for _, p := range top {
+		fmt.Fprintf(&b, "%-20s %10d %8d %8d %7.3f%%\n", p.prefix, p.ips, p.open, p.proxies, p.hitRate()*100)
+	}
+
+	if len(d.logs) > 0 {
+		b.WriteString("\n")
+		for _, l := range d.logs {
+			b.WriteString(truncate(l, width) + "\n")
+		}
+	}
+	d.mu.Unlock()
+	return b.String()
+}
+
+// hitRate is the share of ips of the prefix that turned out to be proxies
+func (p *prefixStat) hitRate() float64 {
+	if p.ips == 0 {
+		return 0
+	}
+	return float64(p.proxies) / float64(p.ips)
+}
+
+func truncate(s string, width int) string {
+	if len(s) > width {
+		return s[:width]
+	}
+	return s
+}
+
+// logWriter shows the log lines under the tables on a terminal
+type logWriter struct {
+	d *Dashboard
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		if !w.d.tty {
+			fmt.Fprintln(w.d.logOut, string(line))
+			continue
+		}
+		w.d.mu.Lock()
+		w.d.logs = append(w.d.logs, string(line))
+		if len(w.d.logs) > 5 {
+			w.d.logs = w.d.logs[len(w.d.logs)-5:]
+		}
+		w.d.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+// quiet drops records about a single address and progress records, the
+// dashboard shows both already. Warnings and errors always pass, eg an
+// unauthenticated panel the tables do not show.
+func quiet(r slog.Record) bool {
+	if r.Level >= slog.LevelWarn {
+		return true
+	}
+	if r.Message == "progress" {
+		return false
+	}
+	keep := true
+	r.Attrs(func(a slog.Attr) bool {
+		keep = a.Key != "addr"
+		return keep
+	})
+	return keep
+}
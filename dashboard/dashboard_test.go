@@ -0,0 +1,71 @@
+package dashboard
+
+import (
+	"bytes"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/scan"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDashboard(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	p := n.NewProxy(simnet.Socks5UDP)
+	n.RegisterScanner()
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	groups := []target.Group{{
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/30"), netip.MustParsePrefix("10.0.0.0/24")},
+		Ports:    []int{int(p.AddrPort().Port())},
+	}}
+	s := scan.Default()
+	s.ScannerType = simnet.ScannerName
+	d := New(out, groups, 100000)
+	assert.False(t, d.tty)
+	d.Attach(s)
+
+	var logs bytes.Buffer
+	prev := logging.SetOutput(&logs)
+	defer logging.SetOutput(prev)
+	d.Start()
+	slog.Info("hidden", "addr", "127.0.0.1:1")
+	slog.Info("shown")
+	slog.Warn("management panel found", "addr", "127.0.0.1:9090")
+	// the text of a message does not matter
+	slog.Info("listen addr=:8080")
+	slog.Info("hidden level=WARN", "addr", "127.0.0.1:2")
+	s.ScanTargets(groups)
+	d.Stop()
+	assert.Equal(t, &logs, logging.SetOutput(&logs))
+	assert.Contains(t, logs.String(), "msg=shown")
+	assert.NotContains(t, logs.String(), "hidden")
+	assert.Contains(t, logs.String(), "management panel found")
+	assert.Contains(t, logs.String(), "listen addr=:8080")
+	assert.Nil(t, logging.SetFilter(nil))
+
+	data, _ := os.ReadFile(out.Name())
+	assert.Contains(t, string(data), "progress 100.0% (260/260)")
+	assert.Contains(t, string(data), "open 1 proxies 1")
+
+	screen := d.screen(time.Now())
+	assert.Contains(t, screen, p.Addr())
+	assert.Contains(t, screen, "127.0.0.0/30")
+	assert.NotContains(t, screen, "10.0.0.0/24")
+	assert.Equal(t, 1, strings.Count(screen, "25.000%"))
+	assert.NotContains(t, screen, "hidden")
+}
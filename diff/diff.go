@@ -0,0 +1,147 @@
+// Package diff compares two result sets and classifies what changed.
+package diff
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// Endpoint is the part of a result that is compared between scans.
+// Empty fields are unknown and never reported as changed.
+type Endpoint struct {
+	AddrPort netip.AddrPort `json:"addr"`
+	Protocol string         `json:"protocol,omitempty"`
+	UDP      *bool          `json:"udp,omitempty"`
+	EgressIP string         `json:"egress_ip,omitempty"`
+	Country  string         `json:"country,omitempty"`
+	City     string         `json:"city,omitempty"`
+	Org      string         `json:"org,omitempty"`
+}
+
+// Geo returns the location as one comparable string
+func (e *Endpoint) Geo() string {
+	parts := make([]string, 0, 3)
+	for _, p := range []string{e.Country, e.City, e.Org} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+type Kind string
+
+const (
+	KindNew             Kind = "new"
+	KindDisappeared     Kind = "disappeared"
+	KindProtocolChanged Kind = "protocol_changed"
+	KindUDPChanged      Kind = "udp_changed"
+	KindEgressChanged   Kind = "egress_ip_changed"
+	KindGeoChanged      Kind = "geo_changed"
+)
+
+// Kinds is the report order
+var Kinds = []Kind{KindNew, KindDisappeared, KindProtocolChanged, KindUDPChanged, KindEgressChanged, KindGeoChanged}
+
+// Change is one difference of one endpoint
+type Change struct {
+	Kind     Kind           `json:"kind"`
+	AddrPort netip.AddrPort `json:"addr"`
+	Old      string         `json:"old,omitempty"`
+	New      string         `json:"new,omitempty"`
+}
+
+// Result is the outcome of Compare
+type Result struct {
+	OldCount  int      `json:"old_count"`
+	NewCount  int      `json:"new_count"`
+	Unchanged int      `json:"unchanged"`
+	Changes   []Change `json:"changes"`
+}
+
+// Count returns the number of changes of kind k
+func (r *Result) Count(k Kind) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Kind == k {
+			n++
+		}
+	}
+	return n
+}
+
+// Compare classifies the difference between old and new.
+// Changes are ordered by kind, then by address.
+func Compare(old, new []Endpoint) *Result {
+	oldMap := index(old)
+	newMap := index(new)
+	res := &Result{OldCount: len(oldMap), NewCount: len(newMap), Changes: make([]Change, 0)}
+
+	for addr, n := range newMap {
+		o, ok := oldMap[addr]
+		if !ok {
+			res.Changes = append(res.Changes, Change{Kind: KindNew, AddrPort: addr, New: describe(n)})
+			continue
+		}
+		before := len(res.Changes)
+		res.Changes = appendIfChanged(res.Changes, KindProtocolChanged, addr, o.Protocol, n.Protocol)
+		if o.UDP != nil && n.UDP != nil && *o.UDP != *n.UDP {
+			res.Changes = append(res.Changes, Change{Kind: KindUDPChanged, AddrPort: addr, Old: boolString(*o.UDP), New: boolString(*n.UDP)})
+		}
+		res.Changes = appendIfChanged(res.Changes, KindEgressChanged, addr, o.EgressIP, n.EgressIP)
+		res.Changes = appendIfChanged(res.Changes, KindGeoChanged, addr, o.Geo(), n.Geo())
+		if len(res.Changes) == before {
+			res.Unchanged++
+		}
+	}
+	for addr, o := range oldMap {
+		if _, ok := newMap[addr]; !ok {
+			res.Changes = append(res.Changes, Change{Kind: KindDisappeared, AddrPort: addr, Old: describe(o)})
+		}
+	}
+
+	slices.SortFunc(res.Changes, func(a, b Change) int {
+		if ka, kb := slices.Index(Kinds, a.Kind), slices.Index(Kinds, b.Kind); ka != kb {
+			return ka - kb
+		}
+		return a.AddrPort.Compare(b.AddrPort)
+	})
+	return res
+}
+
+func index(list []Endpoint) map[netip.AddrPort]*Endpoint {
+	m := make(map[netip.AddrPort]*Endpoint, len(list))
+	for i := range list {
+		m[list[i].AddrPort] = &list[i]
+	}
+	return m
+}
+
+func appendIfChanged(changes []Change, k Kind, addr netip.AddrPort, old, new string) []Change {
+	if old == "" || new == "" || old == new {
+		return changes
+	}
+	return append(changes, Change{Kind: k, AddrPort: addr, Old: old, New: new})
+}
+
+func describe(e *Endpoint) string {
+	parts := []string{e.Protocol}
+	if e.UDP != nil && *e.UDP {
+		parts = append(parts, "udp")
+	}
+	if g := e.Geo(); g != "" {
+		parts = append(parts, g)
+	}
+	if e.EgressIP != "" {
+		parts = append(parts, "egress "+e.EgressIP)
+	}
+	return strings.TrimSpace(strings.Join(parts, " "))
+}
+
+func boolString(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
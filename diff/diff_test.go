@@ -0,0 +1,133 @@
+package diff
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/store"
+	"github.com/stretchr/testify/assert"
+)
+
+const oldClash = `proxies:
+  - name: '[CN-Nanjing]CERNET(10.0.0.1:7890)'
+    type: socks5
+    server: 10.0.0.1
+    port: 7890
+    udp: true
+  - name: '[Unknown]10.0.0.2:1080'
+    type: socks5
+    server: 10.0.0.2
+    port: 1080
+    udp: false
+  - name: '[CN]CERNET(10.0.0.3:7890)'
+    type: socks5
+    server: 10.0.0.3
+    port: 7890
+    udp: false
+`
+
+const newJSON = `[
+  {"addr": "10.0.0.1:7890", "protocol": "socks5", "udp": false, "geo": {"Country": "CN", "City": "Nanjing", "ASOrg": "CERNET"}},
+  {"addr": "10.0.0.3:7890", "protocol": "http", "udp": false, "geo": {"Country": "JP", "ASOrg": "CERNET"},
+   "test": {"ip_info": {"same": {"ip": {"value": "203.0.113.9"}}}}},
+  {"addr": "10.0.0.4:7890", "protocol": "socks5", "udp": true}
+]`
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	oldPath, newPath := filepath.Join(dir, "old.yaml"), filepath.Join(dir, "new.json")
+	assert.NoError(t, os.WriteFile(oldPath, []byte(oldClash), 0644))
+	assert.NoError(t, os.WriteFile(newPath, []byte(newJSON), 0644))
+
+	old, err := LoadFile(oldPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "CN/Nanjing/CERNET", old[0].Geo())
+	assert.Equal(t, "", old[1].Geo())
+	new, err := LoadFile(newPath)
+	assert.NoError(t, err)
+
+	addr := netip.MustParseAddrPort
+	r := Compare(old, new)
+	assert.Equal(t, []Change{
+		{Kind: KindNew, AddrPort: addr("10.0.0.4:7890"), New: "socks5 udp"},
+		{Kind: KindDisappeared, AddrPort: addr("10.0.0.2:1080"), Old: "socks5"},
+		{Kind: KindProtocolChanged, AddrPort: addr("10.0.0.3:7890"), Old: "socks5", New: "http"},
+		{Kind: KindUDPChanged, AddrPort: addr("10.0.0.1:7890"), Old: "yes", New: "no"},
+		{Kind: KindGeoChanged, AddrPort: addr("10.0.0.3:7890"), Old: "CN/CERNET", New: "JP/CERNET"},
+	}, r.Changes)
+	assert.Equal(t, 0, r.Unchanged)
+
+	// egress is only compared when both sides know it
+	r = Compare(new, new)
+	assert.Empty(t, r.Changes)
+	assert.Equal(t, 3, r.Unchanged)
+
+	_, err = LoadFile(filepath.Join(dir, "x.txt"))
+	assert.Error(t, err)
+
+	// a mistyped database is not an empty baseline
+	_, err = LoadFile(filepath.Join(dir, "missing.db"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoFileExists(t, filepath.Join(dir, "missing.db"))
+}
+
+func TestFromFound(t *testing.T) {
+	addr := netip.MustParseAddrPort
+	found := []store.Found{
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.1:1080"), Success: true}, Protocol: "socks5"},
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.2:1080"), Auth: true}, Protocol: "socks5"},
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.3:443")}, Protocol: "trojan"},
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.4:1080"), Success: true}, Protocol: "socks4"},
+	}
+	res := FromFound(found)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, addr("10.0.0.1:1080"), res[0].AddrPort)
+	}
+}
+
+func TestCompareDB(t *testing.T) {
+	addr := netip.MustParseAddrPort
+	found := []store.Found{
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.1:1080"), Success: true}, Protocol: "socks5"},
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.2:1080"), Auth: true}, Protocol: "socks5"},
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.3:443"), Findings: []prober.Finding{{Kind: prober.KindInbound, Label: "trojan"}}}},
+		{Result: &socks5.Result{AddrPort: addr("10.0.0.4:1080"), Success: true}, Protocol: "socks4"},
+	}
+	path := filepath.Join(t.TempDir(), "results.db")
+	db, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.RecordScan("", time.Now(), nil, found)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	old, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, old, 1)
+	r := Compare(old, FromFound(found))
+	assert.Empty(t, r.Changes)
+	assert.Equal(t, 1, r.Unchanged)
+}
+
+func TestWrite(t *testing.T) {
+	r := Compare(
+		[]Endpoint{{AddrPort: netip.MustParseAddrPort("10.0.0.1:1"), EgressIP: "1.1.1.1"}},
+		[]Endpoint{{AddrPort: netip.MustParseAddrPort("10.0.0.1:1"), EgressIP: "1.0.0.1"}},
+	)
+	for _, format := range []string{"text", "json", "markdown"} {
+		var buf bytes.Buffer
+		assert.NoError(t, Write(&buf, format, r))
+		assert.Contains(t, buf.String(), "1.0.0.1", format)
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, WriteText(&buf, r))
+	assert.Equal(t, "old: 1, new: 1, unchanged: 0\n\n=== Egress IP changed (1) ===\n10.0.0.1:1: 1.1.1.1 -> 1.0.0.1\n", buf.String())
+	assert.Error(t, Write(&buf, "xml", r))
+}
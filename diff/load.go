@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/dn-11/proxyScan/convert"
+	"github.com/dn-11/proxyScan/store"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFile loads a result set, the format is chosen by extension:
+// .yaml/.yml for Clash configs, .json for `db -format json` output and
+// .db for the results database, of which only open endpoints are used.
+// Like the output file, only proxies usable without auth are compared.
+func LoadFile(path string) ([]Endpoint, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".db", ".bolt":
+		// store.Open creates a missing database, which would be an empty
+		// baseline
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		db, err := store.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer db.Close()
+		open := true
+		records, err := db.Query(store.Filter{Open: &open})
+		if err != nil {
+			return nil, err
+		}
+		return FromRecords(records), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FromClash(data)
+	case ".json":
+		var records []store.Record
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return FromRecords(records), nil
+	}
+	return nil, fmt.Errorf("%s: unknown result format, expect .yaml, .json or .db", path)
+}
+
+// clashName matches names generated by convert.ToClash
+var clashName = regexp.MustCompile(`^\[([^\]]*)\](.*)\(([^()]+)\)$`)
+
+// FromClash reads the proxies of a Clash config. Geo is recovered from the
+// names generated by convert.ToClash.
+func FromClash(data []byte) ([]Endpoint, error) {
+	var cfg struct {
+		Proxies []convert.ClashSocks5Proxy `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	res := make([]Endpoint, 0, len(cfg.Proxies))
+	for _, p := range cfg.Proxies {
+		ip, err := netip.ParseAddr(p.Server)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %w", p.Name, err)
+		}
+		udp := p.Udp
+		e := Endpoint{
+			AddrPort: netip.AddrPortFrom(ip, uint16(p.Port)),
+			Protocol: p.Type,
+			UDP:      &udp,
+		}
+		if m := clashName.FindStringSubmatch(p.Name); m != nil && m[1] != "Unknown" {
+			e.Country, e.City, _ = strings.Cut(m[1], "-")
+			e.Org = m[2]
+		}
+		res = append(res, e)
+	}
+	return res, nil
+}
+
+// listed reports whether an endpoint goes into the output file, the only
+// endpoints a diff compares, usable is false when it needs auth
+func listed(protocol string, usable bool) bool {
+	return usable && convert.ClashSupports(protocol)
+}
+
+// FromRecords converts results database records, keeping the proxies the
+// output file gets
+func FromRecords(records []store.Record) []Endpoint {
+	res := make([]Endpoint, 0, len(records))
+	for _, r := range records {
+		if !listed(r.Protocol, !r.Auth) {
+			continue
+		}
+		udp := r.UDP
+		e := Endpoint{AddrPort: r.AddrPort, Protocol: r.Protocol, UDP: &udp}
+		if r.Geo != nil {
+			e.Country, e.City, e.Org = r.Geo.Country, r.Geo.City, r.Geo.ASOrg
+		}
+		if r.Test != nil {
+			e.EgressIP = egressIP(r.Test.IPInfo.Same["ip"].Value)
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// FromFound converts the results of a scan that just finished, keeping
+// the proxies the output file gets
+func FromFound(found []store.Found) []Endpoint {
+	res := make([]Endpoint, 0, len(found))
+	for _, f := range found {
+		if !listed(f.Protocol, f.Result.Success) {
+			continue
+		}
+		udp := f.Result.UDP
+		e := Endpoint{AddrPort: f.Result.AddrPort, Protocol: f.Protocol, UDP: &udp}
+		if f.Geo != nil {
+			e.Country, e.City, e.Org = f.Geo.Country, f.Geo.City, f.Geo.ASOrg
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+func egressIP(s string) string {
+	if _, err := netip.ParseAddr(s); err != nil {
+		return ""
+	}
+	return s
+}
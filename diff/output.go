@@ -0,0 +1,93 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var kindTitle = map[Kind]string{
+	KindNew:             "New",
+	KindDisappeared:     "Disappeared",
+	KindProtocolChanged: "Protocol changed",
+	KindUDPChanged:      "UDP changed",
+	KindEgressChanged:   "Egress IP changed",
+	KindGeoChanged:      "Geo changed",
+}
+
+// Write writes r as text, json or markdown
+func Write(w io.Writer, format string, r *Result) error {
+	switch format {
+	case "text", "":
+		return WriteText(w, r)
+	case "json":
+		return WriteJSON(w, r)
+	case "markdown", "md":
+		return WriteMarkdown(w, r)
+	}
+	return fmt.Errorf("unknown diff format %q", format)
+}
+
+func WriteJSON(w io.Writer, r *Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func WriteText(w io.Writer, r *Result) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "old: %d, new: %d, unchanged: %d\n", r.OldCount, r.NewCount, r.Unchanged)
+	for _, k := range Kinds {
+		if n := r.Count(k); n > 0 {
+			fmt.Fprintf(&b, "\n=== %s (%d) ===\n", kindTitle[k], n)
+		}
+		for _, c := range r.Changes {
+			if c.Kind != k {
+				continue
+			}
+			switch {
+			case c.Old != "" && c.New != "" && k != KindNew && k != KindDisappeared:
+				fmt.Fprintf(&b, "%s: %s -> %s\n", c.AddrPort, c.Old, c.New)
+			case c.New != "":
+				fmt.Fprintf(&b, "%s: %s\n", c.AddrPort, c.New)
+			case c.Old != "":
+				fmt.Fprintf(&b, "%s: %s\n", c.AddrPort, c.Old)
+			default:
+				fmt.Fprintf(&b, "%s\n", c.AddrPort)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func WriteMarkdown(w io.Writer, r *Result) error {
+	var b strings.Builder
+	b.WriteString("## Scan diff\n\n")
+	b.WriteString("| | Count |\n|---|---:|\n")
+	fmt.Fprintf(&b, "| Before | %d |\n| After | %d |\n| Unchanged | %d |\n", r.OldCount, r.NewCount, r.Unchanged)
+	for _, k := range Kinds {
+		fmt.Fprintf(&b, "| %s | %d |\n", kindTitle[k], r.Count(k))
+	}
+	for _, k := range Kinds {
+		if r.Count(k) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n| Endpoint | Old | New |\n|---|---|---|\n", kindTitle[k])
+		for _, c := range r.Changes {
+			if c.Kind == k {
+				fmt.Fprintf(&b, "| `%s` | %s | %s |\n", c.AddrPort, mdCell(c.Old), mdCell(c.New))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func mdCell(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, "|", `\|`)
+}
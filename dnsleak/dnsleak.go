@@ -0,0 +1,140 @@
+// Package dnsleak is a self-hostable authoritative DNS stand-in revealing
+// the recursive resolvers of proxies.
+//
+// The zone must be delegated to the server. Every test uses a fresh random
+// subdomain, so no cache can answer it: the only queries for it come from
+// the resolver the proxy uses. Names of the zone resolve to Target, where
+// the HTTP handler answers with the label asked for, a proxy connecting
+// anywhere else got a tampered answer.
+package dnsleak
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Server answers for Zone and records who asks
+type Server struct {
+	// Zone is the delegated domain, eg leak.example.org.
+	Zone string
+	// Target is where the names of the zone point to, the HTTP handler
+	// must be reachable there
+	Target netip.AddrPort
+	TTL    uint32
+
+	mu      sync.Mutex
+	queries map[string][]netip.Addr
+}
+
+func NewServer(zone string, target netip.AddrPort) *Server {
+	return &Server{Zone: dns.Fqdn(strings.ToLower(zone)), Target: target, TTL: 1, queries: make(map[string][]netip.Addr)}
+}
+
+// Name returns a fresh subdomain of the zone, its queries are recorded
+// until Forget
+func (s *Server) Name() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	name := hex.EncodeToString(b[:]) + "." + s.Zone
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[name] = nil
+	return name
+}
+
+// Resolvers returns the addresses that queried name, in order of arrival
+func (s *Server) Resolvers(name string) []netip.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]netip.Addr(nil), s.queries[dns.Fqdn(strings.ToLower(name))]...)
+}
+
+// Forget stops recording queries for name
+func (s *Server) Forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.queries, dns.Fqdn(strings.ToLower(name)))
+}
+
+func (s *Server) record(name string, from net.Addr) {
+	ap, err := netip.ParseAddrPort(from.String())
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// resolvers randomize the case of names against spoofing
+	name = strings.ToLower(name)
+	list, ok := s.queries[name]
+	if !ok {
+		return
+	}
+	addr := ap.Addr().Unmap()
+	for _, a := range list {
+		if a == addr {
+			return
+		}
+	}
+	s.queries[name] = append(list, addr)
+}
+
+// ServeDNS answers A and AAAA questions for names of the zone with Target
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	for _, q := range r.Question {
+		if !dns.IsSubDomain(s.Zone, strings.ToLower(q.Name)) {
+			m.Rcode = dns.RcodeRefused
+			m.Authoritative = false
+			break
+		}
+		s.record(q.Name, w.RemoteAddr())
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: s.TTL}
+		addr := s.Target.Addr()
+		switch {
+		case q.Qtype == dns.TypeA && addr.Is4():
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		case q.Qtype == dns.TypeAAAA && addr.Is6():
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+	_ = w.WriteMsg(m)
+}
+
+// ServeHTTP answers with the first label of the host asked for
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, _, _ := strings.Cut(strings.ToLower(host), ".")
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(label))
+}
+
+// ListenAndServe serves DNS over UDP and TCP on dnsAddr and the HTTP
+// handler on httpAddr until one of them fails
+func (s *Server) ListenAndServe(dnsAddr, httpAddr string) error {
+	errc := make(chan error, 3)
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: dnsAddr, Net: network, Handler: s}
+		go func() { errc <- srv.ListenAndServe() }()
+	}
+	srv := &http.Server{Addr: httpAddr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() { errc <- srv.ListenAndServe() }()
+	err := <-errc
+	if err == nil {
+		err = errors.New("dnsleak server stopped")
+	}
+	return err
+}
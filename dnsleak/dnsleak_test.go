@@ -0,0 +1,61 @@
+package dnsleak
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer("Leak.Example.org", netip.MustParseAddrPort("192.0.2.1:80"))
+	addr := serve(t, s)
+
+	name := s.Name()
+	assert.True(t, strings.HasSuffix(name, ".leak.example.org."))
+	m := new(dns.Msg)
+	// resolvers may randomize the case
+	m.SetQuestion(strings.ToUpper(name), dns.TypeA)
+	r, err := dns.Exchange(m, addr)
+	if assert.NoError(t, err) && assert.Len(t, r.Answer, 1) {
+		assert.True(t, r.Authoritative)
+		assert.Equal(t, "192.0.2.1", r.Answer[0].(*dns.A).A.String())
+	}
+	_, err = dns.Exchange(m, addr)
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, s.Resolvers(name))
+
+	s.Forget(name)
+	assert.Empty(t, s.Resolvers(name))
+
+	m.SetQuestion("example.com.", dns.TypeA)
+	r, err = dns.Exchange(m, addr)
+	if assert.NoError(t, err) {
+		assert.Equal(t, dns.RcodeRefused, r.Rcode)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://abcd.leak.example.org:8080/", nil)
+	s.ServeHTTP(rec, req)
+	assert.Equal(t, "abcd", rec.Body.String())
+}
+
+// serve runs the dns handler of s on a loopback port until the test ends
+func serve(t *testing.T, s *Server) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{PacketConn: pc, Handler: s}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = srv.ActivateAndServe() }()
+	<-started
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return pc.LocalAddr().String()
+}
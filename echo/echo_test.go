@@ -0,0 +1,161 @@
+package echo
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- ServeUDP(pc) }()
+
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	// too short for the address, a reply would amplify
+	c.SetDeadline(time.Now().Add(time.Second))
+	_, err = c.Write([]byte("hello world"))
+	assert.NoError(t, err)
+	req := append([]byte("hello world"), make([]byte, UDPPadding)...)
+	_, err = c.Write(req)
+	assert.NoError(t, err)
+	buf := make([]byte, 1024)
+	n, err := c.Read(buf)
+	if assert.NoError(t, err) {
+		assert.LessOrEqual(t, n, len(req))
+		mapped, payload, err := ParseUDP(buf[:n])
+		assert.NoError(t, err)
+		assert.Equal(t, c.LocalAddr().String(), mapped.String())
+		assert.True(t, strings.HasPrefix(string(payload), "hello world"))
+	}
+
+	pc.Close()
+	assert.NoError(t, <-done)
+
+	_, _, err = ParseUDP([]byte("garbage"))
+	assert.Error(t, err)
+}
+
+func TestHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/headers", nil)
+	req.RemoteAddr = "192.0.2.1:40000"
+	req.Header.Set("Via", "1.1 squid")
+	Headers(rec, req)
+
+	var reply HeadersReply
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&reply))
+	assert.Equal(t, "192.0.2.1", reply.IP)
+	assert.Equal(t, "1.1 squid", reply.Headers.Get("Via"))
+}
+
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+	ep, err := ParseEndpoints(srv.URL + "/")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, srv.Listener.Addr().String(), ep.UDP)
+
+	get := func(u string) (*http.Response, []byte) {
+		resp, err := http.Get(u)
+		if !assert.NoError(t, err) {
+			return &http.Response{}, nil
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, _ := get(ep.Check)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, body := get(ep.Trace)
+	assert.Equal(t, "ip=127.0.0.1\n", string(body))
+	resp, body = get(ep.Download(1000))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, body, 1000)
+	resp, _ = get(srv.URL + PathDownload + "?bytes=" + strconv.FormatInt(MaxDownload+1, 10))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = http.Post(ep.Upload, "application/octet-stream", strings.NewReader("payload"))
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	ep, err = ParseEndpoints("http://203.0.113.1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "203.0.113.1:80", ep.UDP)
+		assert.Equal(t, "http://203.0.113.1/headers", ep.Headers)
+	}
+	for _, bad := range []string{"https://203.0.113.1", "203.0.113.1:8080", "http://"} {
+		_, err := ParseEndpoints(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestHandlerLimits(t *testing.T) {
+	oldToken, oldTransfers, oldMax := Token, MaxTransfers, MaxDownload
+	t.Cleanup(func() { Token, MaxTransfers, MaxDownload = oldToken, oldTransfers, oldMax })
+	Token, MaxTransfers, MaxDownload = "secret", 1, 100
+
+	// the handler holds the transfer until the body is read
+	pr, pw := io.Pipe()
+	srv := httptest.NewServer(Handler())
+	defer srv.Close()
+	defer pw.Close()
+
+	resp, err := http.Get(srv.URL + PathCheck)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+	ep, err := ParseEndpoints(srv.URL + "/secret")
+	if !assert.NoError(t, err) {
+		return
+	}
+	resp, err = http.Get(ep.Check)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+
+	for size, status := range map[int]int{100: http.StatusOK, 101: http.StatusRequestEntityTooLarge} {
+		resp, err = http.Post(ep.Upload, "application/octet-stream", strings.NewReader(strings.Repeat("x", size)))
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, status, resp.StatusCode, size)
+		}
+	}
+
+	go func() {
+		resp, err := http.Post(ep.Upload, "application/octet-stream", pr)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	_, _ = pw.Write([]byte("x"))
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get(ep.Download(10))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusServiceUnavailable
+	}, time.Second, 10*time.Millisecond)
+}
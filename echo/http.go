@@ -0,0 +1,21 @@
+package echo
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HeadersReply is the answer of Headers
+type HeadersReply struct {
+	// IP is the source address of the request
+	IP      string      `json:"ip"`
+	Headers http.Header `json:"headers"`
+}
+
+// Headers answers with the source address and the headers of the request
+// as HeadersReply, showing what a proxy adds on the way
+func Headers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(HeadersReply{IP: sourceIP(r), Headers: r.Header})
+}
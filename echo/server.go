@@ -0,0 +1,218 @@
+package echo
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// The server is public, these limit what strangers can do with it. They
+// are read by Handler.
+var (
+	// Token is required as the first path element of every request when
+	// set, eg /TOKEN/generate_204, the url given to the scanner carries it
+	Token string
+	// MaxDownload caps a download, the default is the default speed test
+	MaxDownload int64 = 10 << 20
+	// MaxTransfers are the downloads and uploads served at once, the
+	// others are answered with 503
+	MaxTransfers = 4
+	// Bandwidth caps all transfers together in bytes per second, 0 is
+	// unlimited
+	Bandwidth int64
+)
+
+// Paths of Handler
+const (
+	PathCheck    = "/generate_204"
+	PathHeaders  = "/headers"
+	PathTrace    = "/trace"
+	PathDownload = "/__down"
+	PathUpload   = "/__up"
+)
+
+// Handler serves the http side of an echo server: a 204 connectivity
+// check, Headers, Trace, downloads and uploads
+func Handler() http.Handler {
+	t := &transfers{sem: make(chan struct{}, max(MaxTransfers, 1))}
+	if Bandwidth > 0 {
+		t.limiter = rate.NewLimiter(rate.Limit(float64(Bandwidth)), transferChunk)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathCheck, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(PathHeaders, Headers)
+	mux.HandleFunc(PathTrace, Trace)
+	mux.HandleFunc(PathDownload, t.download)
+	mux.HandleFunc(PathUpload, t.upload)
+	if Token == "" {
+		return mux
+	}
+	token := []byte(Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if subtle.ConstantTimeCompare([]byte(first), token) != 1 {
+			http.NotFound(w, r)
+			return
+		}
+		r.URL.Path, r.URL.RawPath = "/"+rest, ""
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Trace answers with the source address as an ip=addr line, the format of
+// a cloudflare trace
+func Trace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, "ip=%s\n", sourceIP(r))
+}
+
+// transferChunk is the unit transfers are copied and rate limited in
+const transferChunk = 32 << 10
+
+// transfers hold MaxTransfers and share Bandwidth
+type transfers struct {
+	sem     chan struct{}
+	limiter *rate.Limiter
+}
+
+func (t *transfers) acquire(w http.ResponseWriter) bool {
+	select {
+	case t.sem <- struct{}{}:
+		return true
+	default:
+		http.Error(w, "too many transfers", http.StatusServiceUnavailable)
+		return false
+	}
+}
+
+// copy moves n bytes in chunks within the bandwidth
+func (t *transfers) copy(r *http.Request, dst io.Writer, src io.Reader, n int64) error {
+	for n > 0 {
+		chunk := min(n, transferChunk)
+		if t.limiter != nil {
+			if err := t.limiter.WaitN(r.Context(), int(chunk)); err != nil {
+				return err
+			}
+		}
+		written, err := io.CopyN(dst, src, chunk)
+		n -= written
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// download sends ?bytes= zeros, up to MaxDownload
+func (t *transfers) download(w http.ResponseWriter, r *http.Request) {
+	n, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+	if err != nil || n < 0 || n > MaxDownload {
+		http.Error(w, fmt.Sprintf("bytes must be between 0 and %d", MaxDownload), http.StatusBadRequest)
+		return
+	}
+	if !t.acquire(w) {
+		return
+	}
+	defer func() { <-t.sem }()
+	w.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = t.copy(r, w, zeros{}, n)
+}
+
+// upload discards up to MaxDownload bytes of the request body
+func (t *transfers) upload(w http.ResponseWriter, r *http.Request) {
+	if !t.acquire(w) {
+		return
+	}
+	defer func() { <-t.sem }()
+	err := t.copy(r, io.Discard, r.Body, MaxDownload+1)
+	if errors.Is(err, io.EOF) {
+		return
+	}
+	http.Error(w, fmt.Sprintf("upload larger than %d bytes", MaxDownload), http.StatusRequestEntityTooLarge)
+}
+
+// ListenAndServe serves Handler over tcp and ServeUDP over udp on addr
+func ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	errc := make(chan error, 2)
+	go func() { errc <- ServeUDP(pc) }()
+	go func() { errc <- http.ListenAndServe(addr, Handler()) }()
+	return <-errc
+}
+
+// Endpoints locate the services of an echo server
+type Endpoints struct {
+	Check, Headers, Trace, Upload string
+	// UDP is the host:port of ServeUDP
+	UDP string
+
+	base string
+}
+
+// ParseEndpoints derives the endpoints of the echo server at base, eg
+// http://203.0.113.1:8080. It must be plain http for Headers to see what
+// proxies add.
+func ParseEndpoints(base string) (Endpoints, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	if u.Scheme != "http" {
+		return Endpoints{}, fmt.Errorf("echo server %q: want plain http", base)
+	}
+	if u.Host == "" {
+		return Endpoints{}, errors.New("echo server without host")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	base = strings.TrimSuffix(u.String(), "/")
+	return Endpoints{
+		Check:   base + PathCheck,
+		Headers: base + PathHeaders,
+		Trace:   base + PathTrace,
+		Upload:  base + PathUpload,
+		UDP:     host,
+		base:    base,
+	}, nil
+}
+
+// Download is the url of a download of n bytes, the server refuses more
+// than its MaxDownload
+func (e Endpoints) Download(n int64) string {
+	return e.base + PathDownload + "?bytes=" + strconv.FormatInt(n, 10)
+}
+
+// sourceIP is the address a request came from
+func sourceIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,55 @@
+// Package echo is the self-hostable test endpoint the scanner measures
+// proxies against.
+package echo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// UDPPadding is what a datagram to ServeUDP needs after its payload to
+// get all of it back, the room for the longest address
+const UDPPadding = len("[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535 ")
+
+// ServeUDP answers every datagram on pc with the source address as seen by
+// the server, a space and the datagram itself, until pc is closed.
+// Comparing the addresses reported by two ports tells how a NAT maps.
+//
+// A reply is never longer than its datagram, so the server can not be used
+// to amplify traffic towards a spoofed source: the end of the datagram is
+// cut to make room for the address, see UDPPadding, and datagrams too short
+// for the address are dropped.
+func ServeUDP(pc net.PacketConn) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		prefix := from.String() + " "
+		if n < len(prefix) {
+			continue
+		}
+		reply := append([]byte(prefix), buf[:n-len(prefix)]...)
+		_, _ = pc.WriteTo(reply, from)
+	}
+}
+
+// ParseUDP splits a reply of ServeUDP
+func ParseUDP(reply []byte) (mapped netip.AddrPort, payload []byte, err error) {
+	addr, payload, ok := bytes.Cut(reply, []byte(" "))
+	if !ok {
+		return netip.AddrPort{}, nil, errors.New("not an echo reply")
+	}
+	mapped, err = netip.ParseAddrPort(string(addr))
+	if err != nil {
+		return netip.AddrPort{}, nil, fmt.Errorf("echo reply address: %w", err)
+	}
+	return mapped, payload, nil
+}
@@ -0,0 +1,175 @@
+// Package logging sets up log/slog for every component.
+//
+// Components get their logger from New and keep it in a package variable,
+// Setup may run later and still applies to them. The level accepts a default
+// and per component overrides, eg "warn,socks5=debug,geoip=error".
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Options struct {
+	Level string
+	// Format is text or json
+	Format string
+	Output io.Writer
+}
+
+var (
+	mu      sync.RWMutex
+	current = Options{Level: "info", Format: "text", Output: os.Stderr}
+	root    slog.Handler
+	levels  = map[string]slog.Level{}
+	level   = slog.LevelInfo
+	filter  Filter
+)
+
+// Filter tells whether a record is kept, see SetFilter
+type Filter func(r slog.Record) bool
+
+func init() {
+	if err := Setup(current); err != nil {
+		panic(err)
+	}
+}
+
+// Setup replaces the handler of every logger and makes it the slog and log default
+func Setup(o Options) error {
+	if o.Output == nil {
+		o.Output = os.Stderr
+	}
+	def, per, err := ParseLevel(o.Level)
+	if err != nil {
+		return err
+	}
+	h, err := newRoot(o.Format, o.Output)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	current, root, level, levels = o, h, def, per
+	mu.Unlock()
+	slog.SetDefault(slog.New(&handler{}))
+	return nil
+}
+
+// SetOutput redirects every logger to w and returns the previous writer
+func SetOutput(w io.Writer) io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	prev := current.Output
+	current.Output = w
+	root, _ = newRoot(current.Format, w)
+	return prev
+}
+
+// SetFilter drops the records f rejects from every logger and returns the
+// previous filter, nil keeps every record
+func SetFilter(f Filter) Filter {
+	mu.Lock()
+	defer mu.Unlock()
+	prev := filter
+	filter = f
+	return prev
+}
+
+func newRoot(format string, w io.Writer) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	switch format {
+	case "text", "":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", format)
+}
+
+// ParseLevel parses "info" or "warn,socks5=debug"
+func ParseLevel(s string) (slog.Level, map[string]slog.Level, error) {
+	def := slog.LevelInfo
+	per := make(map[string]slog.Level)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, name, ok := strings.Cut(part, "=")
+		if !ok {
+			name, component = component, ""
+		}
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(name)); err != nil {
+			return def, nil, fmt.Errorf("log level %q: %w", part, err)
+		}
+		if component == "" {
+			def = l
+		} else {
+			per[component] = l
+		}
+	}
+	return def, per, nil
+}
+
+// New returns the logger of component, its records carry a component attribute
+func New(component string) *slog.Logger {
+	return slog.New(&handler{component: component})
+}
+
+// handler forwards to the current root handler, so loggers made before
+// Setup follow it
+type handler struct {
+	component string
+	sampler   *sampler
+	// ops are the WithAttrs and WithGroup calls in order
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *handler) Enabled(_ context.Context, l slog.Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	if min, ok := levels[h.component]; ok {
+		return l >= min
+	}
+	return l >= level
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	mu.RLock()
+	out, keep := root, filter
+	mu.RUnlock()
+	if keep != nil && !keep(r) {
+		return nil
+	}
+	if h.sampler != nil && !h.sampler.allow(&r) {
+		return nil
+	}
+	if h.component != "" {
+		out = out.WithAttrs([]slog.Attr{slog.String("component", h.component)})
+	}
+	for _, op := range h.ops {
+		out = op(out)
+	}
+	return out.Handle(ctx, r)
+}
+
+func (h *handler) with(op func(slog.Handler) slog.Handler) *handler {
+	c := *h
+	c.ops = append(append([]func(slog.Handler) slog.Handler{}, h.ops...), op)
+	return &c
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithAttrs(attrs) })
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return h.with(func(out slog.Handler) slog.Handler { return out.WithGroup(name) })
+}
@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setup(t *testing.T, level, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	prev := current
+	if err := Setup(Options{Level: level, Format: format, Output: &buf}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = Setup(prev) })
+	return &buf
+}
+
+func TestParseLevel(t *testing.T) {
+	def, per, err := ParseLevel("warn, socks5=debug,geoip=error")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, def)
+	assert.Equal(t, map[string]slog.Level{"socks5": slog.LevelDebug, "geoip": slog.LevelError}, per)
+
+	def, _, err = ParseLevel("")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelInfo, def)
+
+	_, _, err = ParseLevel("socks5=loud")
+	assert.Error(t, err)
+}
+
+func TestComponentLevel(t *testing.T) {
+	a, b := New("a"), New("b")
+	buf := setup(t, "warn,a=debug", "text")
+
+	a.Debug("from a")
+	b.Info("from b")
+	b.Warn("warn b", "n", 1)
+	out := buf.String()
+	assert.Contains(t, out, "msg=\"from a\" component=a")
+	assert.NotContains(t, out, "from b")
+	assert.Contains(t, out, "msg=\"warn b\" component=b n=1")
+}
+
+func TestJSON(t *testing.T) {
+	l := New("api").With("scan", 3)
+	buf := setup(t, "info", "json")
+
+	l.Info("started", "addr", ":8080")
+	log.Printf("from the log package")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var rec map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "api", rec["component"])
+	assert.Equal(t, float64(3), rec["scan"])
+	assert.Equal(t, ":8080", rec["addr"])
+	assert.Contains(t, lines[1], `"msg":"from the log package"`)
+}
+
+func TestSampled(t *testing.T) {
+	buf := setup(t, "info", "text")
+	l := Sampled(New("x"), 2, time.Hour)
+	for range 5 {
+		l.Info("fail")
+	}
+	l.Info("other")
+	assert.Equal(t, 2, strings.Count(buf.String(), "msg=fail"))
+	assert.Contains(t, buf.String(), "msg=other")
+
+	s := &sampler{burst: 1, period: time.Minute, counts: map[string]*count{}}
+	now := time.Now()
+	r := slog.NewRecord(now, slog.LevelInfo, "fail", 0)
+	assert.True(t, s.allow(&r))
+	assert.False(t, s.allow(&r))
+	r = slog.NewRecord(now.Add(time.Minute), slog.LevelInfo, "fail", 0)
+	assert.True(t, s.allow(&r))
+	r.Attrs(func(a slog.Attr) bool {
+		assert.Equal(t, "dropped", a.Key)
+		assert.Equal(t, int64(1), a.Value.Int64())
+		return true
+	})
+	assert.Equal(t, 1, r.NumAttrs())
+}
+
+func TestFilter(t *testing.T) {
+	buf := setup(t, "info", "text")
+	prev := SetFilter(func(r slog.Record) bool { return r.Level >= slog.LevelWarn })
+	defer SetFilter(prev)
+	l := New("x")
+	l.Info("dropped")
+	l.Warn("kept")
+	log.Print("from the log package")
+	assert.NotContains(t, buf.String(), "dropped")
+	assert.NotContains(t, buf.String(), "log package")
+	assert.Contains(t, buf.String(), "msg=kept")
+}
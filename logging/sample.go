@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Sampled wraps l so each message passes at most burst times per period.
+// The first record after a period with drops carries a dropped attribute.
+// Use it for per address failures that can fire thousands of times a second.
+func Sampled(l *slog.Logger, burst int, period time.Duration) *slog.Logger {
+	h, ok := l.Handler().(*handler)
+	if !ok {
+		return l
+	}
+	c := *h
+	c.sampler = &sampler{burst: burst, period: period, counts: make(map[string]*count)}
+	return slog.New(&c)
+}
+
+type count struct {
+	start   time.Time
+	passed  int
+	dropped int
+}
+
+type sampler struct {
+	burst  int
+	period time.Duration
+
+	mu     sync.Mutex
+	counts map[string]*count
+}
+
+func (s *sampler) allow(r *slog.Record) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counts[r.Message]
+	if !ok || r.Time.Sub(c.start) >= s.period {
+		dropped := 0
+		if ok {
+			dropped = c.dropped
+		}
+		c = &count{start: r.Time}
+		s.counts[r.Message] = c
+		if dropped > 0 {
+			r.AddAttrs(slog.Int("dropped", dropped))
+		}
+	}
+	if c.passed >= s.burst {
+		c.dropped++
+		return false
+	}
+	c.passed++
+	return true
+}
@@ -2,4 +2,13 @@
 
 package main
 
-import _ "github.com/dn-11/proxyScan/scan/tcpscanner/pcap"
+import (
+	"flag"
+
+	"github.com/dn-11/proxyScan/scan/tcpscanner/pcap"
+)
+
+func init() {
+	flag.StringVar(&pcap.ReplayFile, "pcap-replay", "", "pcap/pcapng file to read responses from, used by -scanner pcap-offline")
+	flag.StringVar(&pcap.CaptureFile, "pcap-capture", "", "write probes to this pcap/pcapng file instead of the wire, used by -scanner pcap-offline")
+}
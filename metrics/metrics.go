@@ -0,0 +1,127 @@
+// Package metrics holds the Prometheus metrics of every scan stage.
+// They are always collected and only exposed when Serve is called.
+package metrics
+
+import (
+	"github.com/dn-11/proxyScan/logging"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var logger = logging.New("metrics")
+
+// Registry has every proxyScan metric plus the go and process collectors
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// tcp scan
+var (
+	ProbesSent = factory.NewCounter(prometheus.CounterOpts{
+		Name: "proxyscan_probes_sent_total",
+		Help: "TCP probes handed to the scanner backend.",
+	})
+	LimiterWait = factory.NewCounter(prometheus.CounterOpts{
+		Name: "proxyscan_limiter_wait_seconds_total",
+		Help: "Time spent waiting on the probe rate limiter.",
+	})
+	SynAcks = factory.NewCounter(prometheus.CounterOpts{
+		Name: "proxyscan_pcap_synacks_received_total",
+		Help: "SYN-ACKs captured by the pcap backend, duplicates included.",
+	})
+	PcapDropped = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "proxyscan_pcap_dropped_packets",
+		Help: "Packets dropped by the kernel or interface during the current pcap scan.",
+	})
+	Pending = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "proxyscan_pcap_pending_probes",
+		Help: "Probes waiting for a SYN-ACK, the size of the pcap TTLSet.",
+	})
+	OpenPorts = factory.NewCounter(prometheus.CounterOpts{
+		Name: "proxyscan_open_ports_total",
+		Help: "Open TCP ports found.",
+	})
+	Progress = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "proxyscan_generator_progress_ratio",
+		Help: "Share of target ips generated by the current scan.",
+	})
+)
+
+// socks5 verification
+var (
+	VerifyQueue = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "proxyscan_verify_queue_depth",
+		Help: "Open ports waiting for or in SOCKS5 verification.",
+	})
+	Verified = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxyscan_verify_results_total",
+		Help: "SOCKS5 verification results by outcome: success, auth_required, timeout, rejected or error.",
+	}, []string{"result"})
+	UDPCapable = factory.NewCounter(prometheus.CounterOpts{
+		Name: "proxyscan_udp_capable_total",
+		Help: "Confirmed SOCKS5 proxies relaying UDP.",
+	})
+)
+
+// geoip and report
+var (
+	GeoLookups = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxyscan_geoip_lookups_total",
+		Help: "GeoIP lookups by provider.",
+	}, []string{"provider"})
+	GeoErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxyscan_geoip_errors_total",
+		Help: "Failed GeoIP lookups by provider.",
+	}, []string{"provider"})
+	ReportTest = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "proxyscan_report_test_duration_seconds",
+		Help:    "Duration of one proxy test of the report by status.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"status"})
+)
+
+// Value reads the current value of a counter or gauge
+func Value(m prometheus.Metric) float64 {
+	var pb dto.Metric
+	if err := m.Write(&pb); err != nil {
+		return 0
+	}
+	switch {
+	case pb.Counter != nil:
+		return pb.Counter.GetValue()
+	case pb.Gauge != nil:
+		return pb.Gauge.GetValue()
+	}
+	return 0
+}
+
+// Handler serves the registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Serve exposes /metrics on addr in the background
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go func() {
+		logger.Info("metrics listening", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("metrics server stopped", "err", err)
+			os.Exit(1)
+		}
+	}()
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	Verified.WithLabelValues("timeout").Inc()
+	GeoLookups.WithLabelValues("ipsb").Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, name := range []string{
+		"proxyscan_probes_sent_total",
+		`proxyscan_verify_results_total{result="timeout"} 1`,
+		`proxyscan_geoip_lookups_total{provider="ipsb"} 1`,
+		"go_goroutines",
+	} {
+		assert.Contains(t, string(body), name)
+	}
+}
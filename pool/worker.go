@@ -2,9 +2,12 @@ package pool
 
 import (
 	"context"
-	"log"
+
+	"github.com/dn-11/proxyScan/logging"
 )
 
+var logger = logging.New("pool")
+
 type Worker struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -21,7 +24,7 @@ func NewWorker(f chan func()) *Worker {
 func (w *Worker) Run() {
 	defer func() {
 		if r := recover(); r != nil {
-			log.Println("Recovered ", r)
+			logger.Error("task panicked", "panic", r)
 		}
 	}()
 
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/dn-11/proxyScan/echo"
+)
+
+// Anonymity levels of an HTTP proxy
+const (
+	// Transparent proxies pass the address of the client on
+	Transparent = "transparent"
+	// Anonymous proxies hide the client but tell they are a proxy
+	Anonymous = "anonymous"
+	// Elite proxies look like a direct client
+	Elite = "elite"
+)
+
+// EchoURL is a plain http header echo, see echo.Headers. The anonymity of
+// every proxy is classified with it when set.
+var EchoURL string
+
+// ValidateEchoURL checks that u can see what a proxy adds, a https
+// request is tunneled untouched
+func ValidateEchoURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" {
+		return fmt.Errorf("echo url %q: want plain http", u)
+	}
+	return nil
+}
+
+// proxyHeaders reveal a proxy when they reach the destination, the client
+// address may leak in any other header too
+var proxyHeaders = []string{"Via", "X-Forwarded-For", "Forwarded", "X-Real-Ip", "Proxy-Connection"}
+
+// checkAnonymity fetches EchoURL through client and classifies what
+// reached it, the headers are the revealing ones found
+func (t *ProxyTester) checkAnonymity(ctx context.Context, client *http.Client) (string, []string, error) {
+	self, err := t.scannerIP()
+	if err != nil {
+		return "", nil, fmt.Errorf("scanner address: %w", err)
+	}
+	reply, err := fetchEcho(ctx, client)
+	if err != nil {
+		return "", nil, err
+	}
+	level, headers := classifyAnonymity(reply, self)
+	return level, headers, nil
+}
+
+// classifyAnonymity is transparent when self shows up in any header,
+// anonymous when there is one of proxyHeaders and elite otherwise
+func classifyAnonymity(reply *echo.HeadersReply, self string) (string, []string) {
+	var found []string
+	leaked, proxied := false, false
+	for name, values := range reply.Headers {
+		name = http.CanonicalHeaderKey(name)
+		revealing := slices.Contains(proxyHeaders, name)
+		proxied = proxied || revealing
+		if slices.ContainsFunc(values, func(v string) bool { return containsAddr(v, self) }) {
+			leaked, revealing = true, true
+		}
+		if revealing {
+			found = append(found, name)
+		}
+	}
+	slices.Sort(found)
+	switch {
+	case leaked:
+		return Transparent, found
+	case proxied:
+		return Anonymous, found
+	}
+	return Elite, nil
+}
+
+// containsAddr reports whether the header value v has the address ip, not
+// just a longer one containing it
+func containsAddr(v, ip string) bool {
+	fields := strings.FieldsFunc(v, func(r rune) bool {
+		return !(r == '.' || r == ':' || '0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F')
+	})
+	return slices.ContainsFunc(fields, func(f string) bool {
+		return f == ip || strings.HasPrefix(f, ip+":")
+	})
+}
+
+// scannerIP is the address the echo sees for a direct request, looked up
+// once per tester and again after a failure
+func (t *ProxyTester) scannerIP() (string, error) {
+	t.selfMu.Lock()
+	defer t.selfMu.Unlock()
+	if t.self != "" {
+		return t.self, nil
+	}
+	reply, err := fetchEcho(t.context(), t.client)
+	if err != nil {
+		return "", err
+	}
+	// without it every revealing header would look like a leak
+	if reply.IP == "" {
+		return "", errors.New("echo reply without ip, is it echo.Headers?")
+	}
+	t.self = reply.IP
+	return t.self, nil
+}
+
+func fetchEcho(ctx context.Context, client *http.Client) (*echo.HeadersReply, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, EchoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// a proxy passing its own hop-by-hop header on is not elite
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("echo status: %d", resp.StatusCode)
+	}
+	var reply echo.HeadersReply
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("decode echo: %w", err)
+	}
+	return &reply, nil
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+	"gopkg.in/yaml.v3"
+)
+
+// Checks are the destinations every proxy is tried against, see LoadChecks
+var Checks []Check
+
+const defaultCheckTimeout = 10 * time.Second
+
+// Check is a destination a proxy should reach, either a URL fetched
+// through it or a host:port it is asked to connect to
+type Check struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	Addr string `yaml:"addr"`
+	// Status is the expected status code of URL, any below 400 when 0
+	Status int `yaml:"status"`
+	// Body is a regular expression the body of URL must match
+	Body    string        `yaml:"body"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	body *regexp.Regexp
+}
+
+// CheckResult is the outcome of a Check through one proxy, Time is in
+// milliseconds
+type CheckResult struct {
+	Name   string  `json:"name"`
+	Pass   bool    `json:"pass"`
+	Time   float64 `json:"time_ms"`
+	Status int     `json:"status,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+func (r CheckResult) String() string {
+	if r.Pass {
+		return fmt.Sprintf("ok %.0fms", r.Time)
+	}
+	return fmt.Sprintf("FAIL %.0fms", r.Time)
+}
+
+// LoadChecks reads and validates a check file
+//
+//	checks:
+//	  - name: github
+//	    url: https://github.com/
+//	    status: 200
+//	  - name: telegram
+//	    addr: 149.154.167.51:443
+//	    timeout: 5s
+//	  - name: openai
+//	    url: https://chat.openai.com/cdn-cgi/trace
+//	    body: "loc=(US|JP)"
+func LoadChecks(path string) ([]Check, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Checks []Check `yaml:"checks"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	names := make(map[string]struct{}, len(file.Checks))
+	for i := range file.Checks {
+		c := &file.Checks[i]
+		if err := c.validate(); err != nil {
+			return nil, fmt.Errorf("%s: check %d: %w", path, i+1, err)
+		}
+		if _, ok := names[c.Name]; ok {
+			return nil, fmt.Errorf("%s: duplicate check %q", path, c.Name)
+		}
+		names[c.Name] = struct{}{}
+	}
+	return file.Checks, nil
+}
+
+func (c *Check) validate() error {
+	switch {
+	case c.URL != "" && c.Addr != "":
+		return errors.New("url and addr are exclusive")
+	case c.URL != "":
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("url %q: want http or https", c.URL)
+		}
+	case c.Addr != "":
+		if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+			return err
+		}
+		if c.Status != 0 || c.Body != "" {
+			return errors.New("status and body need an url")
+		}
+	default:
+		return errors.New("url or addr is required")
+	}
+	if c.Name == "" {
+		c.Name = c.URL + c.Addr
+	}
+	if c.Body != "" {
+		var err error
+		if c.body, err = regexp.Compile(c.Body); err != nil {
+			return fmt.Errorf("body: %w", err)
+		}
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultCheckTimeout
+	}
+	return nil
+}
+
+// runChecks runs Checks at once, fetching through client and connecting
+// through the proxy at proxyURL
+func runChecks(ctx context.Context, client *http.Client, proxyURL *url.URL) []CheckResult {
+	results := make([]CheckResult, len(Checks))
+	var wg sync.WaitGroup
+	for i, c := range Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			start := time.Now()
+			var err error
+			if c.URL != "" {
+				results[i].Status, err = c.fetch(ctx, client)
+			} else {
+				err = connect(ctx, proxyURL, c.Addr)
+			}
+			results[i].Name = c.Name
+			results[i].Time = float64(time.Since(start)) / float64(time.Millisecond)
+			results[i].Pass = err == nil
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// fetch gets URL and compares the response with the expectations
+func (c *Check) fetch(ctx context.Context, client *http.Client) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case c.Status != 0 && resp.StatusCode != c.Status:
+		return resp.StatusCode, fmt.Errorf("status %d, want %d", resp.StatusCode, c.Status)
+	case c.Status == 0 && resp.StatusCode >= 400:
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if c.body != nil && !c.body.Match(body) {
+		return resp.StatusCode, fmt.Errorf("body does not match %q", c.Body)
+	}
+	return resp.StatusCode, nil
+}
+
+// connect opens a tunnel to addr through the proxy at proxyURL, with
+// CONNECT for an http proxy
+func connect(ctx context.Context, proxyURL *url.URL, addr string) error {
+	var d net.Dialer
+	if proxyURL.Scheme == ProtocolSocks5 {
+		dialer, err := xproxy.FromURL(proxyURL, &d)
+		if err != nil {
+			return err
+		}
+		conn, err := dialer.(xproxy.ContextDialer).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	conn, err := d.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", addr, addr); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tampering kinds
+const (
+	CertReplaced   = "certificate replaced"
+	BodyModified   = "HTTP body modified"
+	HeaderInjected = "header injected"
+)
+
+var (
+	// Resources are fetched through every proxy and compared with what
+	// their origin is known to serve, see LoadResources
+	Resources []Resource
+	// RootCAs verify the certificates of resources without fingerprints,
+	// nil for the system roots
+	RootCAs *x509.CertPool
+)
+
+// Resource is a known resource, the fields left empty are not compared
+type Resource struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// CertSHA256 are the accepted hex sha256 fingerprints of the leaf
+	// certificate, the chain is verified against RootCAs when empty
+	CertSHA256 []string `yaml:"cert_sha256"`
+	BodySHA256 string   `yaml:"body_sha256"`
+	// Headers are all the header names the origin sends, any other one
+	// was injected on the way
+	Headers []string `yaml:"headers"`
+}
+
+// Tamper is a modification seen on a resource fetched through a proxy
+type Tamper struct {
+	Resource string `json:"resource"`
+	Kind     string `json:"kind"`
+	Detail   string `json:"detail"`
+}
+
+func (t Tamper) String() string {
+	return fmt.Sprintf("%s: %s (%s)", t.Resource, t.Kind, t.Detail)
+}
+
+// LoadResources reads and validates a resource file
+//
+//	resources:
+//	  - name: jquery
+//	    url: https://code.jquery.com/jquery-3.7.1.min.js
+//	    cert_sha256: [5f0b...]
+//	    body_sha256: fc9a...
+//	    headers: [Content-Type, Content-Length, Date, Etag]
+func LoadResources(path string) ([]Resource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Resources []Resource `yaml:"resources"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i := range file.Resources {
+		if err := file.Resources[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: resource %d: %w", path, i+1, err)
+		}
+	}
+	return file.Resources, nil
+}
+
+func (r *Resource) validate() error {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url %q: want http or https", r.URL)
+	}
+	if r.Name == "" {
+		r.Name = r.URL
+	}
+	if u.Scheme == "http" && len(r.CertSHA256) > 0 {
+		return errors.New("cert_sha256 needs an https url")
+	}
+	for i, fp := range r.CertSHA256 {
+		if r.CertSHA256[i], err = parseSHA256(fp); err != nil {
+			return fmt.Errorf("cert_sha256: %w", err)
+		}
+	}
+	if r.BodySHA256 != "" {
+		if r.BodySHA256, err = parseSHA256(r.BodySHA256); err != nil {
+			return fmt.Errorf("body_sha256: %w", err)
+		}
+	}
+	for i, h := range r.Headers {
+		r.Headers[i] = http.CanonicalHeaderKey(h)
+	}
+	return nil
+}
+
+// parseSHA256 normalizes a hex digest, colons as printed by openssl are
+// accepted
+func parseSHA256(s string) (string, error) {
+	s = strings.ToLower(strings.ReplaceAll(s, ":", ""))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("%q is not a sha256 digest", s)
+	}
+	return s, nil
+}
+
+// hopHeaders may be added or removed by any proxy
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate", "Transfer-Encoding", "Te", "Trailer", "Upgrade"}
+
+// checkIntegrity fetches Resources with client, whose transport must skip
+// certificate verification so a replaced certificate can be inspected
+func checkIntegrity(client *http.Client) []Tamper {
+	var found []Tamper
+	for _, r := range Resources {
+		found = append(found, r.check(client)...)
+	}
+	return found
+}
+
+func (r Resource) check(client *http.Client) []Tamper {
+	resp, err := client.Get(r.URL)
+	if err != nil {
+		// a proxy failing the resource is reported by the other tests
+		return nil
+	}
+	defer resp.Body.Close()
+
+	var found []Tamper
+	add := func(kind, detail string) {
+		found = append(found, Tamper{Resource: r.Name, Kind: kind, Detail: detail})
+	}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		if detail := r.checkCert(resp.TLS, resp.Request.URL.Hostname()); detail != "" {
+			add(CertReplaced, detail)
+		}
+	}
+	if r.BodySHA256 != "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.LimitReader(resp.Body, 64<<20)); err == nil {
+			if sum := hex.EncodeToString(h.Sum(nil)); sum != r.BodySHA256 {
+				add(BodyModified, "sha256 "+sum)
+			}
+		}
+	}
+	if len(r.Headers) > 0 {
+		var names []string
+		for name := range resp.Header {
+			if !slices.Contains(r.Headers, name) && !slices.Contains(hopHeaders, name) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			add(HeaderInjected, name+": "+resp.Header.Get(name))
+		}
+	}
+	return found
+}
+
+// checkCert describes why the leaf certificate is not the expected one,
+// empty if it is
+func (r Resource) checkCert(state *tls.ConnectionState, host string) string {
+	certs := state.PeerCertificates
+	leaf := certs[0]
+	if len(r.CertSHA256) > 0 {
+		sum := sha256.Sum256(leaf.Raw)
+		fp := hex.EncodeToString(sum[:])
+		if slices.Contains(r.CertSHA256, fp) {
+			return ""
+		}
+		return fmt.Sprintf("sha256 %s issued by %s", fp, leaf.Issuer.CommonName)
+	}
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{Roots: RootCAs, Intermediates: inter, DNSName: host})
+	if err != nil {
+		return fmt.Sprintf("issued by %s: %v", leaf.Issuer.CommonName, err)
+	}
+	return ""
+}
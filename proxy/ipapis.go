@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Parsers of IPCheckAPI
+const (
+	ParserJSON  = "json"
+	ParserRegex = "regex"
+	ParserKV    = "kv"
+)
+
+// ipFields are the fields an IPCheckAPI may fill
+var ipFields = []string{"ip", "country", "region", "city", "org", "asn"}
+
+const defaultIPCheckTimeout = 10 * time.Second
+
+//go:embed ipapis.yaml
+var defaultIPCheckAPIs []byte
+
+// IPCheckAPIs are queried through every proxy for its egress address, the
+// defaults are ipapis.yaml and LoadIPCheckAPIs reads a replacement
+var IPCheckAPIs = mustParseIPCheckAPIs(defaultIPCheckAPIs)
+
+// IPCheckAPI is an ip lookup service
+type IPCheckAPI struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Enabled is true when left out
+	Enabled *bool             `yaml:"enabled"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Headers map[string]string `yaml:"headers"`
+	// Parser is ParserJSON, ParserRegex or ParserKV, Fields map the ip
+	// fields to what the parser looks for
+	Parser string            `yaml:"parser"`
+	Fields map[string]string `yaml:"fields"`
+	// Separator splits the lines of ParserKV, "=" when empty
+	Separator string `yaml:"separator"`
+
+	regexps map[string]*regexp.Regexp
+}
+
+// LoadIPCheckAPIs reads and validates an api file, see ipapis.yaml
+func LoadIPCheckAPIs(path string) ([]IPCheckAPI, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	apis, err := parseIPCheckAPIs(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return apis, nil
+}
+
+// NewIPCheckAPI validates an api defined in code
+func NewIPCheckAPI(name, u, parser string, fields map[string]string) (IPCheckAPI, error) {
+	a := IPCheckAPI{Name: name, URL: u, Parser: parser, Fields: fields}
+	return a, a.validate()
+}
+
+func parseIPCheckAPIs(data []byte) ([]IPCheckAPI, error) {
+	var file struct {
+		APIs []IPCheckAPI `yaml:"apis"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	names := make(map[string]struct{}, len(file.APIs))
+	for i := range file.APIs {
+		a := &file.APIs[i]
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("api %d: %w", i+1, err)
+		}
+		if _, ok := names[a.Name]; ok {
+			return nil, fmt.Errorf("duplicate api %q", a.Name)
+		}
+		names[a.Name] = struct{}{}
+	}
+	return file.APIs, nil
+}
+
+func mustParseIPCheckAPIs(data []byte) []IPCheckAPI {
+	apis, err := parseIPCheckAPIs(data)
+	if err != nil {
+		panic("ipapis.yaml: " + err.Error())
+	}
+	return apis
+}
+
+func (a *IPCheckAPI) validate() error {
+	if a.Name == "" {
+		return errors.New("name is required")
+	}
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.Name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s: url %q: want http or https", a.Name, a.URL)
+	}
+	if a.Timeout < 0 {
+		return fmt.Errorf("%s: negative timeout", a.Name)
+	}
+	if a.Timeout == 0 {
+		a.Timeout = defaultIPCheckTimeout
+	}
+	if len(a.Fields) == 0 {
+		return fmt.Errorf("%s: no fields", a.Name)
+	}
+	for field, expr := range a.Fields {
+		if !slices.Contains(ipFields, field) {
+			return fmt.Errorf("%s: unknown field %q, want one of %s", a.Name, field, strings.Join(ipFields, ", "))
+		}
+		if expr == "" {
+			return fmt.Errorf("%s: empty field %q", a.Name, field)
+		}
+	}
+	switch a.Parser {
+	case ParserJSON:
+	case ParserKV:
+		if a.Separator == "" {
+			a.Separator = "="
+		}
+	case ParserRegex:
+		a.regexps = make(map[string]*regexp.Regexp, len(a.Fields))
+		for field, expr := range a.Fields {
+			re, err := regexp.Compile("(?m)" + expr)
+			if err != nil {
+				return fmt.Errorf("%s: field %s: %w", a.Name, field, err)
+			}
+			if re.NumSubexp() != 1 {
+				return fmt.Errorf("%s: field %s: want one group, have %d", a.Name, field, re.NumSubexp())
+			}
+			a.regexps[field] = re
+		}
+	default:
+		return fmt.Errorf("%s: unknown parser %q, want %s, %s or %s", a.Name, a.Parser, ParserJSON, ParserRegex, ParserKV)
+	}
+	return nil
+}
+
+// enabled reports whether a is queried
+func (a IPCheckAPI) enabled() bool {
+	return a.Enabled == nil || *a.Enabled
+}
+
+// parse extracts the fields found in body, missing ones are left out
+func (a *IPCheckAPI) parse(body []byte) (map[string]string, error) {
+	values := make(map[string]string, len(a.Fields))
+	switch a.Parser {
+	case ParserJSON:
+		var data any
+		if err := json.Unmarshal(body, &data); err != nil {
+			return nil, err
+		}
+		for field, path := range a.Fields {
+			if v := jsonPath(data, path); v != "" {
+				values[field] = v
+			}
+		}
+	case ParserRegex:
+		for field, re := range a.regexps {
+			if m := re.FindSubmatch(body); m != nil {
+				if v := strings.TrimSpace(string(m[1])); v != "" {
+					values[field] = v
+				}
+			}
+		}
+	case ParserKV:
+		kv := make(map[string]string)
+		sc := bufio.NewScanner(bytes.NewReader(body))
+		for sc.Scan() {
+			if k, v, ok := strings.Cut(sc.Text(), a.Separator); ok {
+				kv[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+		}
+		for field, key := range a.Fields {
+			if v := kv[key]; v != "" {
+				values[field] = v
+			}
+		}
+	}
+	return values, nil
+}
+
+// jsonPath follows a dotted path of object keys and array indexes, a
+// leading "$." is accepted. Strings, numbers and booleans are returned as
+// text, anything else as "".
+func jsonPath(data any, path string) string {
+	path = strings.TrimPrefix(path, "$.")
+	value := data
+	for _, part := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[part]
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return ""
+			}
+			value = v[i]
+		default:
+			return ""
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"time"
+)
+
+var (
+	// LatencyURLs are requested LatencySamples times through every proxy,
+	// each on a fresh connection
+	LatencyURLs    = []string{"http://cp.cloudflare.com/generate_204"}
+	LatencySamples = 5
+	// LatencyTimeout bounds every sample, a proxy accepting connections
+	// without answering fails it instead of hanging the tester
+	LatencyTimeout = 10 * time.Second
+)
+
+// Timing is the latency breakdown of one url, times are in milliseconds.
+// Connect is the tcp connect to the proxy, Handshake the proxy CONNECT
+// and TLS until the request can be sent, FirstByte is measured from the
+// start of the request and Total until the body was read.
+type Timing struct {
+	URL       string `json:"url"`
+	Samples   int    `json:"samples"`
+	Failed    int    `json:"failed"`
+	Connect   Stats  `json:"connect_ms"`
+	Handshake Stats  `json:"handshake_ms"`
+	FirstByte Stats  `json:"first_byte_ms"`
+	Total     Stats  `json:"total_ms"`
+}
+
+// Stats summarize samples, Jitter is the mean difference between
+// consecutive samples
+type Stats struct {
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Jitter float64 `json:"jitter"`
+}
+
+// sample is one request of a Timing
+type sample struct {
+	connect, handshake, firstByte, total time.Duration
+}
+
+// measureLatency samples every LatencyURLs with transport, which must not
+// keep connections alive
+func measureLatency(ctx context.Context, transport *http.Transport) []Timing {
+	timings := make([]Timing, 0, len(LatencyURLs))
+	for _, u := range LatencyURLs {
+		t := Timing{URL: u}
+		var samples []sample
+		for range max(LatencySamples, 1) {
+			t.Samples++
+			s, err := measure(ctx, transport, u)
+			if err != nil {
+				t.Failed++
+				continue
+			}
+			samples = append(samples, s)
+		}
+		t.Connect = summarize(samples, func(s sample) time.Duration { return s.connect })
+		t.Handshake = summarize(samples, func(s sample) time.Duration { return s.handshake })
+		t.FirstByte = summarize(samples, func(s sample) time.Duration { return s.firstByte })
+		t.Total = summarize(samples, func(s sample) time.Duration { return s.total })
+		timings = append(timings, t)
+	}
+	return timings
+}
+
+func measure(ctx context.Context, transport *http.Transport, u string) (sample, error) {
+	ctx, cancel := context.WithTimeout(ctx, LatencyTimeout)
+	defer cancel()
+	var (
+		s                                sample
+		start, connected, gotConn, first time.Time
+	)
+	trace := &httptrace.ClientTrace{
+		ConnectDone: func(network, addr string, err error) {
+			if err == nil {
+				connected = time.Now()
+			}
+		},
+		GotConn:              func(httptrace.GotConnInfo) { gotConn = time.Now() },
+		GotFirstResponseByte: func() { first = time.Now() },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, u, nil)
+	if err != nil {
+		return s, err
+	}
+	start = time.Now()
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return s, err
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return s, err
+	}
+	if resp.StatusCode >= 400 {
+		return s, fmt.Errorf("HTTP status: %d", resp.StatusCode)
+	}
+	end := time.Now()
+	if connected.IsZero() {
+		connected = start
+	}
+	s.connect = connected.Sub(start)
+	s.handshake = gotConn.Sub(connected)
+	s.firstByte = first.Sub(start)
+	s.total = end.Sub(start)
+	return s, nil
+}
+
+// latencyTransport is a copy of transport without keep-alive, so every
+// sample pays for its connection
+func latencyTransport(transport *http.Transport) *http.Transport {
+	t := transport.Clone()
+	t.DisableKeepAlives = true
+	return t
+}
+
+func summarize(samples []sample, field func(sample) time.Duration) Stats {
+	if len(samples) == 0 {
+		return Stats{}
+	}
+	ms := make([]float64, len(samples))
+	var jitter float64
+	for i, s := range samples {
+		ms[i] = float64(field(s)) / float64(time.Millisecond)
+		if i > 0 {
+			jitter += math.Abs(ms[i] - ms[i-1])
+		}
+	}
+	if len(ms) > 1 {
+		jitter /= float64(len(ms) - 1)
+	}
+	sorted := slices.Clone(ms)
+	slices.Sort(sorted)
+	return Stats{
+		Min:    sorted[0],
+		Median: percentile(sorted, 50),
+		P95:    percentile(sorted, 95),
+		Jitter: jitter,
+	}
+}
+
+// percentile of sorted with the nearest rank method
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank-1, 0)]
+}
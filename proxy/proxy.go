@@ -2,7 +2,7 @@ package proxy
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,13 +10,20 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
+	"golang.org/x/time/rate"
 )
 
-const (
-	testURL = "https://speed.cloudflare.com/__down?bytes=10000000" // 10MB test file
+var (
+	SpeedTestURL = "https://speed.cloudflare.com/__down?bytes=10000000" // 10MB test file
 )
 
+var logger = logging.New("proxy")
+
 type IPInfo struct {
 	IP      string `json:"ip"`
 	Country string `json:"country"`
@@ -39,266 +46,71 @@ type IPInfoResult struct {
 }
 
 type ProxyResult struct {
-	Proxy           string       `json:"proxy"`
-	Status          string       `json:"status"`
-	IPInfo          IPInfoResult `json:"ip_info"`
-	Latency         string       `json:"latency"`
-	DownloadSpeed   string       `json:"download_speed"`
-	DownloadSpeedMB float64      `json:"download_speed_mb"`
-	TotalBytes      string       `json:"total_bytes"`
-	DownloadTime    string       `json:"download_time"`
-	Error           string       `json:"error"`
-}
-
-type IPCheckAPI struct {
-	Name      string
-	URL       string
-	Fields    map[string]string
-	ParseFunc func(string) (IPInfoResult, error)
+	Proxy string `json:"proxy"`
+	// Protocol is the one the proxy was tested with, ProtocolHTTP or
+	// ProtocolSocks5
+	Protocol string       `json:"protocol,omitempty"`
+	Status   string       `json:"status"`
+	IPInfo   IPInfoResult `json:"ip_info"`
+	// Latency is the time to the headers of the speed test.
+	// Deprecated: use Timings.
+	Latency         string  `json:"latency"`
+	DownloadSpeed   string  `json:"download_speed"`
+	DownloadSpeedMB float64 `json:"download_speed_mb"`
+	TotalBytes      string  `json:"total_bytes"`
+	DownloadTime    string  `json:"download_time"`
+	Error           string  `json:"error"`
+	// Tampering lists the modifications seen on Resources
+	Tampering []Tamper `json:"tampering,omitempty"`
+	// Timings break the latency of LatencyURLs down
+	Timings []Timing `json:"timings,omitempty"`
+	// Anonymity is the level seen by EchoURL, AnonymityHeaders the
+	// headers revealing the proxy
+	Anonymity        string   `json:"anonymity,omitempty"`
+	AnonymityHeaders []string `json:"anonymity_headers,omitempty"`
+	// Speed is the progressive speed test behind DownloadSpeed
+	Speed *Speed `json:"speed,omitempty"`
+	// Checks are the outcomes of Checks, in their order
+	Checks []CheckResult `json:"checks,omitempty"`
 }
 
-var ipCheckAPIs = []IPCheckAPI{
-	{
-		Name: "speedtestcn",
-		URL:  "https://api-v3.speedtest.cn/ip",
-		Fields: map[string]string{
-			"ip":      "data.ip",
-			"country": "data.country",
-			"region":  "data.province",
-			"city":    "data.city",
-			"org":     "data.isp",
-			"asn":     "data.operator",
-		},
-	},
-	{
-		Name: "ipip",
-		URL:  "https://myip.ipip.net/",
-		ParseFunc: func(text string) (IPInfoResult, error) {
-			parts := strings.Split(text, "：")
-			if len(parts) < 3 {
-				return IPInfoResult{}, fmt.Errorf("invalid response format")
-			}
-			ip := strings.TrimSpace(parts[1])
-			location := strings.TrimSpace(parts[2])
-			locationParts := strings.Split(location, " ")
-
-			result := IPInfoResult{
-				Same:       make(map[string]FieldValue),
-				Different:  make(map[string][]FieldValue),
-				AllSources: []string{"ipip"},
-			}
-
-			result.Same["ip"] = FieldValue{
-				Value:   ip,
-				Sources: []string{"ipip"},
-			}
-
-			if len(locationParts) > 0 {
-				result.Same["country"] = FieldValue{
-					Value:   locationParts[0],
-					Sources: []string{"ipip"},
-				}
-			}
-
-			if len(locationParts) > 1 {
-				result.Same["region"] = FieldValue{
-					Value:   locationParts[1],
-					Sources: []string{"ipip"},
-				}
-			}
-
-			if len(locationParts) > 2 {
-				result.Same["city"] = FieldValue{
-					Value:   locationParts[2],
-					Sources: []string{"ipip"},
-				}
-			}
-
-			if len(locationParts) > 3 {
-				result.Same["org"] = FieldValue{
-					Value:   locationParts[len(locationParts)-1],
-					Sources: []string{"ipip"},
-				}
-			}
-
-			return result, nil
-		},
-	},
-	{
-		Name: "ip.sb",
-		URL:  "https://api.ip.sb/geoip",
-		Fields: map[string]string{
-			"ip":      "ip",
-			"country": "country",
-			"region":  "region",
-			"city":    "city",
-			"org":     "organization",
-			"asn":     "asn_organization",
-		},
-	},
-	{
-		Name: "ipinfo",
-		URL:  "https://ipinfo.io/json",
-		Fields: map[string]string{
-			"ip":      "ip",
-			"country": "country",
-			"region":  "region",
-			"city":    "city",
-			"org":     "org",
-			"asn":     "asn",
-		},
-	},
-	{
-		Name: "ipapi",
-		// Public token from ip.skk.moe
-		URL: "https://ipinfo.io/json?token=ba0234c01f79d3",
-		Fields: map[string]string{
-			"ip":      "ip",
-			"country": "country_name",
-			"region":  "region",
-			"city":    "city",
-			"org":     "org",
-			"asn":     "asn",
-		},
-	},
-	{
-		Name: "ip-api",
-		// Public token from ip.skk.moe
-		URL: "https://pro.ip-api.com/json/?fields=16985625&key=EEKS6bLi6D91G1p",
-		Fields: map[string]string{
-			"ip":      "query",
-			"country": "country",
-			"region":  "regionName",
-			"city":    "city",
-			"org":     "org",
-			"asn":     "as",
-		},
-	},
-	{
-		Name: "cf(skkmoe)",
-		URL:  "https://ip.skk.moe/cdn-cgi/trace",
-		ParseFunc: func(text string) (IPInfoResult, error) {
-			result := IPInfoResult{
-				Same:       make(map[string]FieldValue),
-				Different:  make(map[string][]FieldValue),
-				AllSources: []string{"cf(skkmoe)"},
-			}
-
-			// Parse IP
-			if ipIndex := strings.Index(text, "ip="); ipIndex != -1 {
-				ipEnd := strings.Index(text[ipIndex:], "\n")
-				if ipEnd != -1 {
-					result.Same["ip"] = FieldValue{
-						Value:   text[ipIndex+3 : ipIndex+ipEnd],
-						Sources: []string{"cf(skkmoe)"},
-					}
-				}
-			}
-
-			// Parse country
-			if locIndex := strings.Index(text, "loc="); locIndex != -1 {
-				locEnd := strings.Index(text[locIndex:], "\n")
-				if locEnd != -1 {
-					result.Same["country"] = FieldValue{
-						Value:   text[locIndex+4 : locIndex+locEnd],
-						Sources: []string{"cf(skkmoe)"},
-					}
-				}
-			}
-
-			return result, nil
-		},
-	},
-	{
-		Name: "cf(chatgpt)",
-		URL:  "https://chatgpt.com/cdn-cgi/trace",
-		ParseFunc: func(text string) (IPInfoResult, error) {
-			result := IPInfoResult{
-				Same:       make(map[string]FieldValue),
-				Different:  make(map[string][]FieldValue),
-				AllSources: []string{"cf(chatgpt)"},
-			}
-
-			// Parse IP
-			if ipIndex := strings.Index(text, "ip="); ipIndex != -1 {
-				ipEnd := strings.Index(text[ipIndex:], "\n")
-				if ipEnd != -1 {
-					result.Same["ip"] = FieldValue{
-						Value:   text[ipIndex+3 : ipIndex+ipEnd],
-						Sources: []string{"cf(chatgpt)"},
-					}
-				}
-			}
+type ProxyTester struct {
+	// SkipSpeed leaves the speed test out, eg for frequent re-checks
+	SkipSpeed bool
 
-			// Parse country
-			if locIndex := strings.Index(text, "loc="); locIndex != -1 {
-				locEnd := strings.Index(text[locIndex:], "\n")
-				if locEnd != -1 {
-					result.Same["country"] = FieldValue{
-						Value:   text[locIndex+4 : locIndex+locEnd],
-						Sources: []string{"cf(chatgpt)"},
-					}
-				}
-			}
+	proxies []string
+	client  *http.Client
+	ctx     context.Context
 
-			return result, nil
-		},
-	},
-	{
-		Name: "cf(cp)",
-		URL:  "https://cp.cloudflare.com/cdn-cgi/trace",
-		ParseFunc: func(text string) (IPInfoResult, error) {
-			result := IPInfoResult{
-				Same:       make(map[string]FieldValue),
-				Different:  make(map[string][]FieldValue),
-				AllSources: []string{"cf(cp)"},
-			}
+	// the address of the scanner as seen by EchoURL
+	selfMu sync.Mutex
+	self   string
 
-			// Parse IP
-			if ipIndex := strings.Index(text, "ip="); ipIndex != -1 {
-				ipEnd := strings.Index(text[ipIndex:], "\n")
-				if ipEnd != -1 {
-					result.Same["ip"] = FieldValue{
-						Value:   text[ipIndex+3 : ipIndex+ipEnd],
-						Sources: []string{"cf(cp)"},
-					}
-				}
-			}
+	// limiter shares SpeedBudget, speedSem holds SpeedConcurrency and
+	// speedBytes counts the bytes of all speed tests
+	limiter    *rate.Limiter
+	speedSem   chan struct{}
+	speedBytes atomic.Int64
+}
 
-			// Parse country
-			if locIndex := strings.Index(text, "loc="); locIndex != -1 {
-				locEnd := strings.Index(text[locIndex:], "\n")
-				if locEnd != -1 {
-					result.Same["country"] = FieldValue{
-						Value:   text[locIndex+4 : locIndex+locEnd],
-						Sources: []string{"cf(cp)"},
-					}
-				}
-			}
+// Protocols the tester speaks
+const (
+	ProtocolHTTP   = "http"
+	ProtocolSocks5 = "socks5"
+)
 
-			return result, nil
-		},
-	},
-	{
-		Name: "ipwhois",
-		URL:  "https://ipwho.is/",
-		Fields: map[string]string{
-			"ip":      "ip",
-			"country": "country",
-			"region":  "region",
-			"city":    "city",
-			"org":     "connection.org",
-			"asn":     "connection.asn",
-		},
-	},
+// Supports reports whether the tester speaks protocol
+func Supports(protocol string) bool {
+	return protocol == ProtocolHTTP || protocol == ProtocolSocks5
 }
 
-type ProxyTester struct {
-	proxies []string
-	client  *http.Client
-	ctx     context.Context
+// ProxyURL is how NewProxyTester and TestProxy take a proxy of protocol
+// at addr, a bare addr is an http proxy
+func ProxyURL(protocol, addr string) string {
+	return protocol + "://" + addr
 }
 
+// NewProxyTester tests proxies, see ProxyURL
 func NewProxyTester(ctx context.Context, proxies []string) *ProxyTester {
 	// Remove duplicate proxy list
 	uniqueProxies := make(map[string]struct{})
@@ -317,19 +129,36 @@ func NewProxyTester(ctx context.Context, proxies []string) *ProxyTester {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		ctx: ctx,
+		ctx:      ctx,
+		limiter:  newLimiter(),
+		speedSem: make(chan struct{}, max(SpeedConcurrency, 1)),
 	}
 }
 
+func (t *ProxyTester) context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
+}
+
+// TestProxy tests a proxy given as ProxyURL or a bare http proxy address
 func (t *ProxyTester) TestProxy(proxy string) ProxyResult {
-	proxyURL, err := url.Parse(fmt.Sprintf("http://%s", proxy))
+	if !strings.Contains(proxy, "://") {
+		proxy = ProxyURL(ProtocolHTTP, proxy)
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err == nil && !Supports(proxyURL.Scheme) {
+		err = fmt.Errorf("unsupported protocol %q", proxyURL.Scheme)
+	}
 	if err != nil {
 		return ProxyResult{
-			Proxy:  proxy,
+			Proxy:  strings.TrimPrefix(proxy, ProtocolHTTP+"://"),
 			Status: "Unavailable",
 			Error:  fmt.Sprintf("Invalid proxy URL: %v", err),
 		}
 	}
+	proxy = proxyURL.Host
 
 	transport := &http.Transport{
 		Proxy: http.ProxyURL(proxyURL),
@@ -340,56 +169,21 @@ func (t *ProxyTester) TestProxy(proxy string) ProxyResult {
 	}
 
 	result := ProxyResult{
-		Proxy:  proxy,
-		Status: "Available",
-	}
-
-	// Test latency
-	startTime := time.Now()
-	resp, err := client.Get(testURL)
-	if err != nil {
-		result.Status = "Unavailable"
-		result.Error = fmt.Sprintf("Connection failed: %v", err)
-		return result
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		result.Status = "Unavailable"
-		result.Error = fmt.Sprintf("HTTP status: %d", resp.StatusCode)
-		return result
+		Proxy:    proxy,
+		Protocol: proxyURL.Scheme,
+		Status:   "Available",
 	}
 
-	latency := time.Since(startTime).Milliseconds()
-	result.Latency = fmt.Sprintf("%dms", latency)
+	ctx := t.context()
+	result.Timings = measureLatency(ctx, latencyTransport(transport))
 
-	// Test download speed
-	startTime = time.Now()
-	totalBytes := int64(0)
-	buf := make([]byte, 1024)
-	for {
-		n, err := resp.Body.Read(buf)
-		if err != nil && err != io.EOF {
+	if !t.SkipSpeed {
+		if err := t.testSpeed(ctx, client, &result); err != nil {
 			result.Status = "Unavailable"
-			result.Error = fmt.Sprintf("Download failed: %v", err)
+			result.Error = err.Error()
 			return result
 		}
-		if n == 0 {
-			break
-		}
-		totalBytes += int64(n)
 	}
-	downloadTime := time.Since(startTime).Seconds()
-	if downloadTime == 0 {
-		result.Status = "Unavailable"
-		result.Error = "Download time is zero"
-		return result
-	}
-	downloadSpeed := float64(totalBytes) / downloadTime
-	result.DownloadSpeed = formatSpeed(downloadSpeed)
-	result.DownloadSpeedMB = downloadSpeed / (1024 * 1024)
-	result.TotalBytes = fmt.Sprintf("%.2fMB", float64(totalBytes)/(1024*1024))
-	result.DownloadTime = fmt.Sprintf("%.2fs", downloadTime)
 
 	// Get IP information
 	ipInfo, err := t.getIPInfo(client)
@@ -410,6 +204,25 @@ func (t *ProxyTester) TestProxy(proxy string) ProxyResult {
 		result.IPInfo = ipInfo
 	}
 
+	// a socks5 proxy cannot add headers, anonymity is an http matter
+	if EchoURL != "" && result.Protocol == ProtocolHTTP {
+		result.Anonymity, result.AnonymityHeaders, err = t.checkAnonymity(ctx, client)
+		if err != nil {
+			logger.Debug("anonymity check failed", "proxy", proxy, "err", err)
+		}
+	}
+
+	if len(Resources) > 0 {
+		insecure := transport.Clone()
+		insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		result.Tampering = checkIntegrity(&http.Client{Transport: insecure, Timeout: 30 * time.Second})
+		defer insecure.CloseIdleConnections()
+	}
+
+	if len(Checks) > 0 {
+		result.Checks = runChecks(ctx, client, proxyURL)
+	}
+
 	return result
 }
 
@@ -423,13 +236,18 @@ func (t *ProxyTester) getIPInfo(client *http.Client) (IPInfoResult, error) {
 		"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36",
 	}
 
-	for _, api := range ipCheckAPIs {
+	for _, api := range IPCheckAPIs {
+		if !api.enabled() {
+			continue
+		}
 		wg.Add(1)
 		go func(api IPCheckAPI) {
 			defer wg.Done()
 
 			// Create request
-			req, err := http.NewRequest("GET", api.URL, nil)
+			ctx, cancel := context.WithTimeout(t.context(), api.Timeout)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, "GET", api.URL, nil)
 			if err != nil {
 				return
 			}
@@ -438,6 +256,9 @@ func (t *ProxyTester) getIPInfo(client *http.Client) (IPInfoResult, error) {
 			for k, v := range headers {
 				req.Header.Set(k, v)
 			}
+			for k, v := range api.Headers {
+				req.Header.Set(k, v)
+			}
 
 			// Send request
 			resp, err := client.Do(req)
@@ -447,45 +268,34 @@ func (t *ProxyTester) getIPInfo(client *http.Client) (IPInfoResult, error) {
 			defer resp.Body.Close()
 
 			// Read response body
-			body, err := io.ReadAll(resp.Body)
+			body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
 			if err != nil {
 				return
 			}
 
-			var info IPInfoResult
-			if api.ParseFunc != nil {
-				info, err = api.ParseFunc(string(body))
-				if err != nil {
-					return
-				}
-			} else {
-				var data map[string]interface{}
-				if err := json.Unmarshal(body, &data); err != nil {
-					return
-				}
-
-				info = IPInfoResult{
-					Same:       make(map[string]FieldValue),
-					Different:  make(map[string][]FieldValue),
-					AllSources: []string{api.Name},
-				}
-
-				for field, path := range api.Fields {
-					value := getFieldValue(data, path)
-					if value != "" {
-						info.Same[field] = FieldValue{
-							Value:   value,
-							Sources: []string{api.Name},
-						}
-					}
-				}
+			values, err := api.parse(body)
+			if err != nil {
+				logger.Debug("ip check api failed", "api", api.Name, "err", err)
+				return
 			}
 
 			// Check if valid information was obtained
-			if len(info.Same) == 0 {
+			if len(values) == 0 {
 				return
 			}
 
+			info := IPInfoResult{
+				Same:       make(map[string]FieldValue),
+				Different:  make(map[string][]FieldValue),
+				AllSources: []string{api.Name},
+			}
+			for field, value := range values {
+				info.Same[field] = FieldValue{
+					Value:   value,
+					Sources: []string{api.Name},
+				}
+			}
+
 			mu.Lock()
 			results = append(results, info)
 			mu.Unlock()
@@ -555,25 +365,6 @@ func (t *ProxyTester) getIPInfo(client *http.Client) (IPInfoResult, error) {
 	return finalResult, nil
 }
 
-func getFieldValue(data map[string]interface{}, fieldPath string) string {
-	if fieldPath == "" {
-		return ""
-	}
-	parts := strings.Split(fieldPath, ".")
-	var value interface{} = data
-	for _, part := range parts {
-		if m, ok := value.(map[string]interface{}); ok {
-			value = m[part]
-		} else {
-			return ""
-		}
-	}
-	if str, ok := value.(string); ok {
-		return str
-	}
-	return ""
-}
-
 func (t *ProxyTester) Run() []ProxyResult {
 	var wg sync.WaitGroup
 	results := make([]ProxyResult, len(t.proxies))
@@ -585,7 +376,9 @@ func (t *ProxyTester) Run() []ProxyResult {
 		go func(i int, proxy string) {
 			defer wg.Done()
 			defer func() { <-sem }()
+			start := time.Now()
 			results[i] = t.TestProxy(proxy)
+			metrics.ReportTest.WithLabelValues(results[i].Status).Observe(time.Since(start).Seconds())
 		}(i, proxy)
 	}
 
@@ -0,0 +1,583 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/dn-11/proxyScan/echo"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func useSimnet(t *testing.T, n *simnet.Net) {
+	oldURL, oldAPIs, oldLatency := SpeedTestURL, IPCheckAPIs, LatencyURLs
+	t.Cleanup(func() { SpeedTestURL, IPCheckAPIs, LatencyURLs = oldURL, oldAPIs, oldLatency })
+
+	SpeedTestURL = n.Web.URL("/__down?bytes=100000")
+	LatencyURLs = []string{n.Web.URL("/generate_204")}
+	var apis []IPCheckAPI
+	for _, api := range IPCheckAPIs {
+		switch api.Name {
+		case "ipinfo":
+			api.URL = n.Web.URL("/ipinfo/json")
+		case "cf(cp)":
+			api.URL = n.Web.URL("/cdn-cgi/trace")
+		default:
+			continue
+		}
+		apis = append(apis, api)
+	}
+	IPCheckAPIs = apis
+}
+
+func TestProxyTester(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+	n.Web.SetGeo(simnet.Geo{IP: "203.0.113.7", Country: "CN", City: "Nanjing", Org: "CERNET", ASN: 4538})
+
+	good := n.NewProxy(simnet.Mixed)
+	broken := n.NewProxy(simnet.Broken)
+	results := NewProxyTester(nil, []string{good.Addr(), broken.Addr(), good.Addr()}).Run()
+	if !assert.Len(t, results, 2) {
+		return
+	}
+
+	ok, bad := results[0], results[1]
+	assert.Equal(t, good.Addr(), ok.Proxy)
+	assert.Equal(t, "Available", ok.Status)
+	assert.Equal(t, "0.10MB", ok.TotalBytes)
+	assert.Equal(t, "203.0.113.7", ok.IPInfo.Same["ip"].Value)
+	assert.Equal(t, []string{"cf(cp)", "ipinfo"}, ok.IPInfo.AllSources)
+	assert.Equal(t, "CN", ok.IPInfo.Same["country"].Value)
+
+	assert.Equal(t, broken.Addr(), bad.Proxy)
+	assert.Equal(t, "Unavailable", bad.Status)
+	assert.NotEmpty(t, bad.Error)
+
+	// a pure socks5 proxy is tested over socks5
+	socks := n.NewProxy(simnet.Socks5)
+	res := NewProxyTester(nil, nil).TestProxy(ProxyURL(ProtocolSocks5, socks.Addr()))
+	assert.Equal(t, socks.Addr(), res.Proxy)
+	assert.Equal(t, ProtocolSocks5, res.Protocol)
+	assert.Equal(t, "Available", res.Status)
+	assert.Equal(t, "203.0.113.7", res.IPInfo.Same["ip"].Value)
+	if assert.Len(t, res.Timings, 1) {
+		assert.Zero(t, res.Timings[0].Failed)
+	}
+	res = NewProxyTester(nil, nil).TestProxy(ProxyURL(ProtocolHTTP, socks.Addr()))
+	assert.Equal(t, "Unavailable", res.Status)
+	res = NewProxyTester(nil, nil).TestProxy(ProxyURL("socks4", socks.Addr()))
+	assert.Equal(t, "Unavailable", res.Status)
+	assert.Contains(t, res.Error, "unsupported protocol")
+}
+
+func TestIntegrity(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	sum := sha256.Sum256([]byte(simnet.StaticBody))
+	headers := []string{"Content-Type", "Content-Length", "Date", "Etag"}
+	oldResources, oldRoots := Resources, RootCAs
+	defer func() { Resources, RootCAs = oldResources, oldRoots }()
+	Resources = []Resource{
+		{Name: "static", URL: n.Web.URL("/static"), BodySHA256: hex.EncodeToString(sum[:]), Headers: headers},
+		{Name: "static-tls", URL: n.Web.TLSURL("/static"), BodySHA256: hex.EncodeToString(sum[:]), Headers: headers},
+	}
+	RootCAs = n.CA.Pool()
+
+	clean := n.NewProxy(simnet.HTTPProxy)
+	mitm := n.NewProxy(simnet.Behaviour{HTTP: true, MITM: true})
+	inject := n.NewProxy(simnet.Behaviour{HTTP: true, Inject: true})
+	tester := NewProxyTester(nil, nil)
+
+	res := tester.TestProxy(clean.Addr())
+	assert.Equal(t, "Available", res.Status)
+	assert.Empty(t, res.Tampering)
+
+	res = tester.TestProxy(mitm.Addr())
+	if assert.Len(t, res.Tampering, 1) {
+		assert.Equal(t, "static-tls", res.Tampering[0].Resource)
+		assert.Equal(t, CertReplaced, res.Tampering[0].Kind)
+		assert.Contains(t, res.Tampering[0].Detail, "simnet interception")
+	}
+
+	// the forged certificate is also caught by a pinned fingerprint
+	Resources = Resources[1:]
+	Resources[0].CertSHA256 = []string{strings.Repeat("ab", 32)}
+	res = tester.TestProxy(clean.Addr())
+	if assert.Len(t, res.Tampering, 1) {
+		assert.Equal(t, CertReplaced, res.Tampering[0].Kind)
+	}
+	Resources[0].CertSHA256 = nil
+
+	res = tester.TestProxy(inject.Addr())
+	// the tls resource is tunneled untouched
+	assert.Empty(t, res.Tampering)
+	Resources[0].URL = n.Web.URL("/static")
+	res = tester.TestProxy(inject.Addr())
+	if assert.Len(t, res.Tampering, 2) {
+		assert.Equal(t, BodyModified, res.Tampering[0].Kind)
+		assert.Equal(t, HeaderInjected, res.Tampering[1].Kind)
+		assert.Equal(t, "X-Simnet-Ad: 1", res.Tampering[1].Detail)
+	}
+}
+
+func TestLoadResources(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resources.yaml")
+	write := func(s string) {
+		assert.NoError(t, os.WriteFile(path, []byte(s), 0644))
+	}
+	fp := strings.Repeat("AB:", 31) + "AB"
+
+	write(`
+resources:
+  - url: https://example.org/a.js
+    cert_sha256: ["` + fp + `"]
+    headers: [content-type]
+`)
+	list, err := LoadResources(path)
+	if assert.NoError(t, err) && assert.Len(t, list, 1) {
+		assert.Equal(t, "https://example.org/a.js", list[0].Name)
+		assert.Equal(t, []string{strings.Repeat("ab", 32)}, list[0].CertSHA256)
+		assert.Equal(t, []string{"Content-Type"}, list[0].Headers)
+	}
+
+	for _, bad := range []string{
+		"resources: [{url: ftp://example.org/a}]",
+		"resources: [{url: http://example.org/a, cert_sha256: [" + strings.Repeat("ab", 32) + "]}]",
+		"resources: [{url: https://example.org/a, body_sha256: abc}]",
+	} {
+		write(bad)
+		_, err := LoadResources(path)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestAnonymity(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	old := EchoURL
+	defer func() { EchoURL = old }()
+	EchoURL = n.Web.URL("/headers")
+
+	tester := NewProxyTester(nil, nil)
+	tester.client = n.HTTPClient()
+	for _, c := range []struct {
+		headers []string
+		level   string
+		found   []string
+	}{
+		{nil, Elite, nil},
+		{[]string{"Via"}, Anonymous, []string{"Via"}},
+		{[]string{"Proxy-Connection"}, Anonymous, []string{"Proxy-Connection"}},
+		{[]string{"Via", "X-Forwarded-For"}, Transparent, []string{"Via", "X-Forwarded-For"}},
+		{[]string{"Forwarded"}, Transparent, []string{"Forwarded"}},
+		// the address leaks in a header a proxy is not known by
+		{[]string{"X-Client-Ip"}, Transparent, []string{"X-Client-Ip"}},
+		{[]string{"X-Client-Ip", "Via"}, Transparent, []string{"Via", "X-Client-Ip"}},
+	} {
+		p := n.NewProxy(simnet.Behaviour{HTTP: true, ForwardHeaders: c.headers})
+		res := tester.TestProxy(p.Addr())
+		assert.Equal(t, c.level, res.Anonymity, c.headers)
+		assert.Equal(t, c.found, res.AnonymityHeaders, c.headers)
+	}
+}
+
+func TestClassifyAnonymity(t *testing.T) {
+	reply := &echo.HeadersReply{Headers: map[string][]string{"True-Client-Ip": {"192.0.2.1"}}}
+	level, found := classifyAnonymity(reply, "192.0.2.1")
+	assert.Equal(t, Transparent, level)
+	assert.Equal(t, []string{"True-Client-Ip"}, found)
+
+	// a longer address is not a leak
+	reply.Headers = map[string][]string{"X-Originating-Ip": {"192.0.2.10"}, "Forwarded": {"for=\"192.0.2.1:4711\""}}
+	level, found = classifyAnonymity(reply, "192.0.2.1")
+	assert.Equal(t, Transparent, level)
+	assert.Equal(t, []string{"Forwarded"}, found)
+	level, _ = classifyAnonymity(reply, "192.0.2.2")
+	assert.Equal(t, Anonymous, level)
+	reply.Headers = map[string][]string{"X-Originating-Ip": {"192.0.2.10"}}
+	level, found = classifyAnonymity(reply, "192.0.2.1")
+	assert.Equal(t, Elite, level)
+	assert.Empty(t, found)
+}
+
+func TestScannerIP(t *testing.T) {
+	replies := []string{"", `{"headers": {}}`, `{"ip": "192.0.2.1", "headers": {}}`, `{"ip": "192.0.2.2"}`}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := replies[0]
+		replies = replies[1:]
+		if reply == "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		io.WriteString(w, reply)
+	}))
+	defer srv.Close()
+	old := EchoURL
+	defer func() { EchoURL = old }()
+	EchoURL = srv.URL
+
+	tester := NewProxyTester(nil, nil)
+	// a failure is retried, a reply without ip fails
+	_, err := tester.scannerIP()
+	assert.Error(t, err)
+	_, err = tester.scannerIP()
+	assert.ErrorContains(t, err, "without ip")
+	for range 2 {
+		ip, err := tester.scannerIP()
+		assert.NoError(t, err)
+		assert.Equal(t, "192.0.2.1", ip)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	oldURLs, oldSamples := LatencyURLs, LatencySamples
+	defer func() { LatencyURLs, LatencySamples = oldURLs, oldSamples }()
+	LatencyURLs = []string{n.Web.URL("/generate_204"), n.Web.URL("/missing")}
+	LatencySamples = 3
+
+	p := n.NewProxy(simnet.Behaviour{HTTP: true, Delay: 20 * time.Millisecond})
+	res := NewProxyTester(nil, nil).TestProxy(p.Addr())
+	if !assert.Len(t, res.Timings, 2) {
+		return
+	}
+	ok := res.Timings[0]
+	assert.Equal(t, 3, ok.Samples)
+	assert.Zero(t, ok.Failed)
+	// the proxy delays its reply, not the connection
+	assert.Less(t, ok.Connect.Median, 20.0)
+	assert.GreaterOrEqual(t, ok.FirstByte.Min, 20.0)
+	assert.GreaterOrEqual(t, ok.Total.Median, ok.FirstByte.Median)
+	assert.LessOrEqual(t, ok.Total.Median, ok.Total.P95)
+
+	assert.Equal(t, 3, res.Timings[1].Failed)
+	assert.Zero(t, res.Timings[1].Total)
+}
+
+func TestLatencyTimeout(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	oldSamples, oldTimeout, oldAPIs := LatencySamples, LatencyTimeout, IPCheckAPIs
+	defer func() { LatencySamples, LatencyTimeout, IPCheckAPIs = oldSamples, oldTimeout, oldAPIs }()
+	LatencySamples = 2
+	LatencyTimeout = 100 * time.Millisecond
+	IPCheckAPIs = nil
+
+	// accepts the connection and never answers
+	p := n.NewProxy(simnet.Shadowsocks)
+	tester := NewProxyTester(nil, nil)
+	tester.SkipSpeed = true
+	start := time.Now()
+	res := tester.TestProxy(p.Addr())
+	assert.Less(t, time.Since(start), 2*time.Second)
+	if assert.Len(t, res.Timings, 1) {
+		assert.Equal(t, 2, res.Timings[0].Failed)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var samples []sample
+	for _, ms := range []int{10, 30, 20, 40, 100} {
+		samples = append(samples, sample{total: time.Duration(ms) * time.Millisecond})
+	}
+	s := summarize(samples, func(s sample) time.Duration { return s.total })
+	assert.Equal(t, Stats{Min: 10, Median: 30, P95: 100, Jitter: 27.5}, s)
+	assert.Equal(t, Stats{}, summarize(nil, func(s sample) time.Duration { return s.total }))
+}
+
+func TestSpeed(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+	p := n.NewProxy(simnet.HTTPProxy)
+
+	oldBudget, oldBytes, oldTime, oldUpload := SpeedBudget, SpeedMaxBytes, SpeedMaxTime, SpeedUploadURL
+	defer func() {
+		SpeedBudget, SpeedMaxBytes, SpeedMaxTime, SpeedUploadURL = oldBudget, oldBytes, oldTime, oldUpload
+	}()
+
+	// the byte cap ends a download longer than it, and the upload
+	SpeedTestURL = n.Web.URL("/__down?bytes=1000000")
+	SpeedMaxBytes = 200000
+	SpeedUploadURL = n.Web.URL("/__up")
+	res := NewProxyTester(nil, nil).TestProxy(p.Addr())
+	if assert.Equal(t, "Available", res.Status, res.Error) && assert.NotNil(t, res.Speed) {
+		assert.GreaterOrEqual(t, res.Speed.DownloadBytes, int64(200000))
+		assert.Less(t, res.Speed.DownloadBytes, int64(200000+speedChunk))
+		assert.GreaterOrEqual(t, res.Speed.UploadBytes, int64(200000))
+		assert.Greater(t, res.Speed.UploadBps, 0.0)
+		assert.False(t, res.Speed.LocalLimited)
+	}
+
+	tester := NewProxyTester(nil, nil)
+	tester.SkipSpeed = true
+	res = tester.TestProxy(p.Addr())
+	assert.Equal(t, "Available", res.Status, res.Error)
+	assert.Nil(t, res.Speed)
+
+	// a budget below the link holds the download back
+	SpeedUploadURL = ""
+	SpeedMaxBytes = 10 << 20
+	SpeedMaxTime = time.Second
+	SpeedBudget = 1 << 20
+	res = NewProxyTester(nil, nil).TestProxy(p.Addr())
+	if assert.Equal(t, "Available", res.Status, res.Error) && assert.NotNil(t, res.Speed) {
+		assert.True(t, res.Speed.LocalLimited)
+		assert.Equal(t, LimitBudget, res.Speed.LimitedBy)
+		assert.Less(t, res.Speed.DownloadBps, 1.5*(1<<20))
+	}
+}
+
+func TestMeter(t *testing.T) {
+	start := time.Now()
+	at := func(windows int) time.Time { return start.Add(time.Duration(windows) * speedWindow) }
+
+	// a constant rate is stable after speedStableWindows estimates
+	m := &meter{start: start, next: at(1)}
+	stopped := 0
+	for i := 1; i <= 10; i++ {
+		if m.add(100000, at(i)) {
+			stopped = i
+			break
+		}
+	}
+	assert.Equal(t, speedStableWindows, stopped)
+	assert.True(t, m.stable)
+
+	// a growing rate runs into SpeedMaxBytes
+	m = &meter{start: start, next: at(1)}
+	for i := 1; !m.add(10000*i*i, at(i)); i++ {
+	}
+	assert.GreaterOrEqual(t, m.bytes, SpeedMaxBytes)
+	assert.False(t, m.stable)
+
+	// transfers sharing a saturated link are flagged, only with SpeedLink
+	oldLink := SpeedLink
+	defer func() { SpeedLink = oldLink }()
+	var all atomic.Int64
+	m = &meter{all: &all, start: start, next: at(1)}
+	other := &meter{all: &all, start: start, next: at(1)}
+	m.add(50000, at(1))
+	other.add(60000, at(1))
+	assert.Empty(t, m.limited(at(4)))
+	SpeedLink = 100000
+	assert.Equal(t, LimitLink, m.limited(at(4)))
+	SpeedLink = 200000
+	assert.Empty(t, m.limited(at(4)))
+}
+
+func TestChecks(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+	p := n.NewProxy(simnet.HTTPProxy)
+
+	path := filepath.Join(t.TempDir(), "checks.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+checks:
+  - name: 204
+    url: `+n.Web.URL("/generate_204")+`
+    status: 204
+  - name: static
+    url: `+n.Web.URL("/static")+`
+    body: "^simnet static"
+  - name: wrong-body
+    url: `+n.Web.URL("/static")+`
+    body: "^other"
+  - name: connect
+    addr: `+simnet.WebHost+`:80
+  - name: unreachable
+    addr: nowhere.example:443
+    timeout: 2s
+`), 0644))
+	oldChecks := Checks
+	defer func() { Checks = oldChecks }()
+	var err error
+	Checks, err = LoadChecks(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	res := NewProxyTester(nil, nil).TestProxy(p.Addr())
+	if !assert.Len(t, res.Checks, 5) {
+		return
+	}
+	pass := make(map[string]bool)
+	for _, c := range res.Checks {
+		pass[c.Name] = c.Pass
+	}
+	assert.Equal(t, map[string]bool{"204": true, "static": true, "wrong-body": false, "connect": true, "unreachable": false}, pass)
+	assert.Equal(t, 204, res.Checks[0].Status)
+	assert.Greater(t, res.Checks[0].Time, 0.0)
+
+	report := filepath.Join(t.TempDir(), "report.txt")
+	if assert.NoError(t, NewReport([]ProxyResult{res}).GenerateTXT(report)) {
+		data, _ := os.ReadFile(report)
+		assert.Contains(t, string(data), "=== Checks ===")
+		assert.Regexp(t, p.Addr()+` +ok \d+ms +ok \d+ms +FAIL \d+ms +ok \d+ms +FAIL \d+ms`, string(data))
+	}
+	// socks5 proxies connect with socks5 instead of CONNECT
+	res = NewProxyTester(nil, nil).TestProxy(ProxyURL(ProtocolSocks5, n.NewProxy(simnet.Socks5).Addr()))
+	clear(pass)
+	for _, c := range res.Checks {
+		pass[c.Name] = c.Pass
+	}
+	assert.Equal(t, map[string]bool{"204": true, "static": true, "wrong-body": false, "connect": true, "unreachable": false}, pass)
+}
+
+func TestLoadChecks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checks.yaml")
+	write := func(s string) {
+		assert.NoError(t, os.WriteFile(path, []byte(s), 0644))
+	}
+
+	write("checks: [{url: 'https://example.org/'}, {name: tg, addr: '149.154.167.51:443', timeout: 5s}]")
+	list, err := LoadChecks(path)
+	if assert.NoError(t, err) && assert.Len(t, list, 2) {
+		assert.Equal(t, "https://example.org/", list[0].Name)
+		assert.Equal(t, defaultCheckTimeout, list[0].Timeout)
+		assert.Equal(t, 5*time.Second, list[1].Timeout)
+	}
+
+	for _, bad := range []string{
+		"checks: [{name: a}]",
+		"checks: [{url: 'ftp://example.org/'}]",
+		"checks: [{url: 'https://example.org/', addr: 'example.org:443'}]",
+		"checks: [{addr: example.org}]",
+		"checks: [{addr: 'example.org:443', status: 200}]",
+		"checks: [{url: 'https://example.org/', body: '('}]",
+		"checks: [{name: a, url: 'https://example.org/'}, {name: a, addr: 'example.org:443'}]",
+	} {
+		write(bad)
+		_, err := LoadChecks(path)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestIPCheckAPIParse(t *testing.T) {
+	apis := make(map[string]IPCheckAPI)
+	for _, api := range IPCheckAPIs {
+		apis[api.Name] = api
+	}
+	for _, tc := range []struct {
+		api, body string
+		want      map[string]string
+	}{
+		{"ipip", "当前 IP：203.0.113.7  来自于：中国 江苏 南京  教育网\n", map[string]string{
+			"ip": "203.0.113.7", "country": "中国", "region": "江苏", "city": "南京", "org": "教育网",
+		}},
+		{"cf(cp)", "fl=1\nh=cp.cloudflare.com\nip=203.0.113.7\nloc=CN\n", map[string]string{
+			"ip": "203.0.113.7", "country": "CN",
+		}},
+		{"ipwhois", `{"ip":"203.0.113.7","country":"China","connection":{"asn":4538,"org":"CERNET"}}`, map[string]string{
+			"ip": "203.0.113.7", "country": "China", "org": "CERNET", "asn": "4538",
+		}},
+		{"speedtestcn", `{"data":{"ip":"203.0.113.7","province":"Jiangsu"}}`, map[string]string{
+			"ip": "203.0.113.7", "region": "Jiangsu",
+		}},
+	} {
+		api, ok := apis[tc.api]
+		if !assert.True(t, ok, tc.api) {
+			continue
+		}
+		got, err := api.parse([]byte(tc.body))
+		assert.NoError(t, err, tc.api)
+		assert.Equal(t, tc.want, got, tc.api)
+	}
+
+	assert.Equal(t, "b", jsonPath(map[string]any{"a": []any{"x", map[string]any{"k": "b"}}}, "$.a.1.k"))
+	assert.False(t, apis["ip-api"].enabled())
+}
+
+func TestLoadIPCheckAPIs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipapis.yaml")
+	write := func(s string) {
+		assert.NoError(t, os.WriteFile(path, []byte(s), 0644))
+	}
+
+	write(`
+apis:
+  - name: trace
+    url: https://example.org/cdn-cgi/trace
+    parser: kv
+    timeout: 3s
+    headers: {Authorization: Bearer x}
+    fields: {ip: ip}
+`)
+	apis, err := LoadIPCheckAPIs(path)
+	if assert.NoError(t, err) && assert.Len(t, apis, 1) {
+		assert.Equal(t, "=", apis[0].Separator)
+		assert.Equal(t, 3*time.Second, apis[0].Timeout)
+		assert.True(t, apis[0].enabled())
+	}
+
+	for _, bad := range []string{
+		"apis: [{url: 'https://example.org/', parser: json, fields: {ip: ip}}]",
+		"apis: [{name: a, url: 'ftp://example.org/', parser: json, fields: {ip: ip}}]",
+		"apis: [{name: a, url: 'https://example.org/', parser: xml, fields: {ip: ip}}]",
+		"apis: [{name: a, url: 'https://example.org/', parser: json}]",
+		"apis: [{name: a, url: 'https://example.org/', parser: json, fields: {addr: ip}}]",
+		"apis: [{name: a, url: 'https://example.org/', parser: regex, fields: {ip: 'ip=\\S+'}}]",
+		"apis: [{name: a, url: 'https://example.org/', parser: regex, fields: {ip: '('}}]",
+		"apis: [{name: a, url: 'https://example.org/', parser: json, timeout: -1s, fields: {ip: ip}}]",
+		"apis: [{name: a, url: 'https://example.org/', parser: json, fields: {ip: ip}}, {name: a, url: 'https://example.org/', parser: kv, fields: {ip: ip}}]",
+	} {
+		write(bad)
+		_, err := LoadIPCheckAPIs(path)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestEchoServer(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+	srv := httptest.NewServer(echo.Handler())
+	defer srv.Close()
+	ep, err := echo.ParseEndpoints(srv.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	oldEcho, oldUpload := EchoURL, SpeedUploadURL
+	defer func() { EchoURL, SpeedUploadURL = oldEcho, oldUpload }()
+	api, err := NewIPCheckAPI("echo", ep.Trace, ParserKV, map[string]string{"ip": "ip"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	IPCheckAPIs = []IPCheckAPI{api}
+	LatencyURLs = []string{ep.Check}
+	SpeedTestURL = ep.Download(100000)
+	SpeedUploadURL = ep.Upload
+	EchoURL = ep.Headers
+
+	res := NewProxyTester(nil, nil).TestProxy(n.NewProxy(simnet.HTTPProxy).Addr())
+	assert.Equal(t, "Available", res.Status, res.Error)
+	assert.Equal(t, "127.0.0.1", res.IPInfo.Same["ip"].Value)
+	assert.Equal(t, Elite, res.Anonymity)
+	if assert.Len(t, res.Timings, 1) {
+		assert.Zero(t, res.Timings[0].Failed)
+	}
+	if assert.NotNil(t, res.Speed) {
+		assert.Equal(t, int64(100000), res.Speed.DownloadBytes)
+		assert.Greater(t, res.Speed.UploadBytes, int64(0))
+	}
+}
@@ -2,8 +2,10 @@ package proxy
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 )
 
@@ -15,7 +17,7 @@ type Report struct {
 func NewReport(results []ProxyResult) *Report {
 	return &Report{
 		Results: results,
-		TestURL: "https://speed.cloudflare.com/__down?bytes=10000000", // 10MB test file
+		TestURL: SpeedTestURL,
 	}
 }
 
@@ -32,10 +34,13 @@ func (r *Report) GenerateTXT(filename string) error {
 	fmt.Fprintf(file, "Proxy Count: %d\n\n", len(r.Results))
 
 	// Write test results
-	fmt.Fprintln(file, "=== Test Results ===\n")
+	fmt.Fprint(file, "=== Test Results ===\n\n")
 	availableCount := 0
 	for _, result := range r.Results {
 		fmt.Fprintf(file, "%s:\n", result.Proxy)
+		if result.Protocol != "" {
+			fmt.Fprintf(file, "  Protocol: %s\n", result.Protocol)
+		}
 		fmt.Fprintf(file, "  Status: %s\n", result.Status)
 		if result.Error != "" {
 			fmt.Fprintf(file, "  Error: %s\n", result.Error)
@@ -43,6 +48,16 @@ func (r *Report) GenerateTXT(filename string) error {
 		if result.Latency != "" {
 			fmt.Fprintf(file, "  Latency: %s\n", result.Latency)
 		}
+		for _, t := range result.Timings {
+			if t.Failed == t.Samples {
+				fmt.Fprintf(file, "  Latency %s: all %d samples failed\n", t.URL, t.Samples)
+				continue
+			}
+			fmt.Fprintf(file, "  Latency %s (%d/%d samples, median/p95 ms):\n", t.URL, t.Samples-t.Failed, t.Samples)
+			fmt.Fprintf(file, "    connect %.0f/%.0f, handshake %.0f/%.0f, first byte %.0f/%.0f, total %.0f/%.0f, jitter %.1f\n",
+				t.Connect.Median, t.Connect.P95, t.Handshake.Median, t.Handshake.P95,
+				t.FirstByte.Median, t.FirstByte.P95, t.Total.Median, t.Total.P95, t.Total.Jitter)
+		}
 		if result.DownloadSpeed != "" {
 			fmt.Fprintf(file, "  Download Speed: %s\n", result.DownloadSpeed)
 		}
@@ -52,6 +67,43 @@ func (r *Report) GenerateTXT(filename string) error {
 		if result.DownloadTime != "" {
 			fmt.Fprintf(file, "  Download Time: %s\n", result.DownloadTime)
 		}
+		if s := result.Speed; s != nil {
+			if s.UploadBytes > 0 {
+				fmt.Fprintf(file, "  Upload Speed: %s\n", formatSpeed(s.UploadBps))
+			}
+			if !s.Stable {
+				fmt.Fprintln(file, "  Speed: capped before stable")
+			}
+			switch {
+			case s.LimitedBy == LimitLink:
+				fmt.Fprintln(file, "  Speed: limited by the local link")
+			case s.LocalLimited:
+				fmt.Fprintln(file, "  Speed: limited by the local bandwidth budget")
+			}
+		}
+		if result.Anonymity != "" {
+			fmt.Fprintf(file, "  Anonymity: %s", result.Anonymity)
+			if len(result.AnonymityHeaders) > 0 {
+				fmt.Fprintf(file, " (%s)", strings.Join(result.AnonymityHeaders, ", "))
+			}
+			fmt.Fprintln(file)
+		}
+		if len(result.Checks) > 0 {
+			fmt.Fprintln(file, "  === Checks ===")
+			for _, c := range result.Checks {
+				if c.Error != "" {
+					fmt.Fprintf(file, "  %s: %s %.0fms (%s)\n", c.Name, c, c.Time, c.Error)
+					continue
+				}
+				fmt.Fprintf(file, "  %s: %s\n", c.Name, c)
+			}
+		}
+		if len(result.Tampering) > 0 {
+			fmt.Fprintln(file, "  === Tampering ===")
+			for _, t := range result.Tampering {
+				fmt.Fprintf(file, "  %s\n", t)
+			}
+		}
 
 		// Write IP information
 		if len(result.IPInfo.Same) > 0 {
@@ -79,6 +131,8 @@ func (r *Report) GenerateTXT(filename string) error {
 		}
 	}
 
+	r.writeChecks(file)
+
 	// Write statistics
 	fmt.Fprintln(file, "\n=== Test Statistics ===")
 	fmt.Fprintf(file, "Total Proxies: %d\n", len(r.Results))
@@ -91,3 +145,29 @@ func (r *Report) GenerateTXT(filename string) error {
 
 	return nil
 }
+
+// writeChecks writes the results of Checks as a proxy by check matrix
+func (r *Report) writeChecks(w io.Writer) {
+	if len(Checks) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\n=== Checks ===")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "PROXY")
+	for _, c := range Checks {
+		fmt.Fprintf(tw, "\t%s", c.Name)
+	}
+	fmt.Fprintln(tw)
+	for _, result := range r.Results {
+		fmt.Fprint(tw, result.Proxy)
+		for i := range Checks {
+			cell := "-"
+			if i < len(result.Checks) {
+				cell = result.Checks[i].String()
+			}
+			fmt.Fprintf(tw, "\t%s", cell)
+		}
+		fmt.Fprintln(tw)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,298 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	// SpeedBudget caps the bandwidth of all speed tests together in bytes
+	// per second, 0 is unlimited
+	SpeedBudget int64
+	// SpeedLink is the bandwidth of the local link in bytes per second, 0
+	// when unknown. Speed tests during which all of them together came
+	// near it are flagged LocalLimited.
+	SpeedLink int64
+	// SpeedConcurrency is the number of proxies speed tested at once
+	SpeedConcurrency = 2
+	// SpeedMaxBytes and SpeedMaxTime end a transfer that does not get
+	// stable
+	SpeedMaxBytes int64 = 10 << 20
+	SpeedMaxTime        = 10 * time.Second
+	// SpeedTolerance is how close the last estimates must be to their mean
+	// to stop a transfer early
+	SpeedTolerance = 0.05
+	// SpeedUploadURL takes the POST of the upload test, skipped when empty
+	SpeedUploadURL string
+)
+
+const (
+	// speedWindow is the interval of the throughput estimates
+	speedWindow = 250 * time.Millisecond
+	// speedStableWindows is the number of estimates compared
+	speedStableWindows = 4
+	speedChunk         = 32 << 10
+	// speedNearLink is the share of SpeedLink taken as saturated
+	speedNearLink = 0.9
+)
+
+// Speed is the progressive speed test, rates are in bytes per second
+type Speed struct {
+	DownloadBps     float64 `json:"download_bps"`
+	DownloadBytes   int64   `json:"download_bytes"`
+	DownloadSeconds float64 `json:"download_seconds"`
+	UploadBps       float64 `json:"upload_bps,omitempty"`
+	UploadBytes     int64   `json:"upload_bytes,omitempty"`
+	UploadSeconds   float64 `json:"upload_seconds,omitempty"`
+	// Stable is set when the download stopped on a stable estimate
+	// instead of a cap
+	Stable bool `json:"stable"`
+	// LocalLimited is set when SpeedBudget held a transfer back or all
+	// transfers together came near SpeedLink, the speed is a lower bound.
+	// Without either it is never set.
+	LocalLimited bool `json:"local_limited"`
+	// LimitedBy is what limited it, LimitBudget or LimitLink
+	LimitedBy string `json:"limited_by,omitempty"`
+}
+
+// Local limits of a speed test, see Speed.LimitedBy
+const (
+	LimitBudget = "budget"
+	LimitLink   = "link"
+)
+
+// MbpsToBytes converts Mbit/s to the bytes per second of SpeedBudget
+func MbpsToBytes(mbps float64) int64 {
+	return int64(mbps * 1e6 / 8)
+}
+
+// newLimiter shares SpeedBudget among the speed tests, nil when unlimited
+func newLimiter() *rate.Limiter {
+	if SpeedBudget <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(SpeedBudget), max(int(SpeedBudget/10), speedChunk))
+}
+
+// meter measures a transfer and tells when it has measured enough
+type meter struct {
+	limiter *rate.Limiter
+	// all counts the bytes of every transfer of the tester, all0 when this
+	// one started
+	all    *atomic.Int64
+	all0   int64
+	start  time.Time
+	next   time.Time
+	bytes  int64
+	wait   time.Duration
+	rates  []float64
+	stable bool
+}
+
+func newMeter(limiter *rate.Limiter, all *atomic.Int64) *meter {
+	now := time.Now()
+	return &meter{limiter: limiter, all: all, all0: all.Load(), start: now, next: now.Add(speedWindow)}
+}
+
+// take waits for the budget of n bytes
+func (m *meter) take(ctx context.Context, n int) error {
+	if m.limiter == nil {
+		return nil
+	}
+	start := time.Now()
+	err := m.limiter.WaitN(ctx, n)
+	m.wait += time.Since(start)
+	return err
+}
+
+// add counts n bytes transferred at now and reports whether to stop
+func (m *meter) add(n int, now time.Time) bool {
+	m.bytes += int64(n)
+	if m.all != nil {
+		m.all.Add(int64(n))
+	}
+	elapsed := now.Sub(m.start)
+	if m.bytes >= SpeedMaxBytes || elapsed >= SpeedMaxTime {
+		return true
+	}
+	if now.Before(m.next) {
+		return false
+	}
+	m.next = now.Add(speedWindow)
+	m.rates = append(m.rates, m.rate(now))
+	if len(m.rates) < speedStableWindows {
+		return false
+	}
+	last := m.rates[len(m.rates)-speedStableWindows:]
+	var mean float64
+	for _, r := range last {
+		mean += r
+	}
+	mean /= float64(len(last))
+	for _, r := range last {
+		if math.Abs(r-mean) > SpeedTolerance*mean {
+			return false
+		}
+	}
+	m.stable = true
+	return true
+}
+
+func (m *meter) rate(now time.Time) float64 {
+	if s := now.Sub(m.start).Seconds(); s > 0 {
+		return float64(m.bytes) / s
+	}
+	return 0
+}
+
+// limited returns LimitBudget when the budget took a noticeable share of
+// the time, LimitLink when the transfers together were near the link and
+// "" otherwise
+func (m *meter) limited(now time.Time) string {
+	elapsed := now.Sub(m.start)
+	if m.limiter != nil && m.wait > elapsed/10 {
+		return LimitBudget
+	}
+	if SpeedLink <= 0 || m.all == nil || elapsed <= 0 {
+		return ""
+	}
+	all := float64(m.all.Load()-m.all0) / elapsed.Seconds()
+	if all >= speedNearLink*float64(SpeedLink) {
+		return LimitLink
+	}
+	return ""
+}
+
+// download reads body until the meter stops it
+func (t *ProxyTester) download(ctx context.Context, body io.Reader, speed *Speed) error {
+	m := newMeter(t.limiter, &t.speedBytes)
+	buf := make([]byte, speedChunk)
+	for {
+		if err := m.take(ctx, len(buf)); err != nil {
+			return err
+		}
+		n, err := body.Read(buf)
+		now := time.Now()
+		done := m.add(n, now)
+		if err == io.EOF || done {
+			speed.DownloadBytes = m.bytes
+			speed.DownloadSeconds = now.Sub(m.start).Seconds()
+			speed.DownloadBps = m.rate(now)
+			speed.Stable = m.stable
+			speed.LimitedBy = m.limited(now)
+			speed.LocalLimited = speed.LimitedBy != ""
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// upload posts to SpeedUploadURL until the meter stops it
+func (t *ProxyTester) upload(ctx context.Context, client *http.Client, speed *Speed) error {
+	r := &uploadReader{ctx: ctx, m: newMeter(t.limiter, &t.speedBytes)}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, SpeedUploadURL, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP status: %d", resp.StatusCode)
+	}
+	now := time.Now()
+	speed.UploadBytes = r.m.bytes
+	speed.UploadSeconds = now.Sub(r.m.start).Seconds()
+	speed.UploadBps = r.m.rate(now)
+	if speed.LimitedBy == "" {
+		speed.LimitedBy = r.m.limited(now)
+		speed.LocalLimited = speed.LimitedBy != ""
+	}
+	return nil
+}
+
+// uploadReader is a body of zeros ending when its meter stops
+type uploadReader struct {
+	ctx  context.Context
+	m    *meter
+	done bool
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	// never more than SpeedMaxBytes, the most an echo server accepts
+	p = p[:min(int64(len(p)), speedChunk, SpeedMaxBytes-r.m.bytes)]
+	if err := r.m.take(r.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	clear(p)
+	r.done = r.m.add(len(p), time.Now())
+	return len(p), nil
+}
+
+// acquireSpeed waits for a speed test slot, the returned func frees it
+func (t *ProxyTester) acquireSpeed() func() {
+	if t.speedSem == nil {
+		return func() {}
+	}
+	t.speedSem <- struct{}{}
+	return func() { <-t.speedSem }
+}
+
+// testSpeed downloads SpeedTestURL and uploads to SpeedUploadURL through
+// client, filling the speed fields of result
+func (t *ProxyTester) testSpeed(ctx context.Context, client *http.Client, result *ProxyResult) error {
+	release := t.acquireSpeed()
+	defer release()
+
+	// Test latency
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, SpeedTestURL, nil)
+	if err != nil {
+		return err
+	}
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Connection failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status: %d", resp.StatusCode)
+	}
+	result.Latency = fmt.Sprintf("%dms", time.Since(startTime).Milliseconds())
+
+	// Test download speed, progressively
+	speed := &Speed{}
+	if err := t.download(ctx, resp.Body, speed); err != nil {
+		return fmt.Errorf("Download failed: %v", err)
+	}
+	resp.Body.Close()
+	if speed.DownloadSeconds == 0 {
+		return fmt.Errorf("Download time is zero")
+	}
+	if SpeedUploadURL != "" {
+		if err := t.upload(ctx, client, speed); err != nil {
+			logger.Debug("upload test failed", "proxy", result.Proxy, "err", err)
+		}
+	}
+	result.Speed = speed
+	result.DownloadSpeed = formatSpeed(speed.DownloadBps)
+	result.DownloadSpeedMB = speed.DownloadBps / (1024 * 1024)
+	result.TotalBytes = fmt.Sprintf("%.2fMB", float64(speed.DownloadBytes)/(1024*1024))
+	result.DownloadTime = fmt.Sprintf("%.2fs", speed.DownloadSeconds)
+	return nil
+}
@@ -0,0 +1,67 @@
+package scan
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/dn-11/proxyScan/scan/socks5"
+)
+
+type EventKind int
+
+const (
+	// EventOpen is an open port about to be verified
+	EventOpen EventKind = iota
+	// EventFound is a confirmed proxy, one requiring authentication or a
+	// port with findings only
+	EventFound
+	// EventProgress is the count of generated ips, sent every progressEvery ips
+	EventProgress
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventOpen:
+		return "open"
+	case EventFound:
+		return "found"
+	case EventProgress:
+		return "progress"
+	}
+	return "unknown"
+}
+
+// Event is sent on Scanner.Events, the fields not matching Kind are zero
+type Event struct {
+	Kind    EventKind
+	Addr    netip.AddrPort
+	Result  *socks5.Result
+	Current int
+	All     int
+}
+
+// emit calls the hook of e and sends it on Events, giving up the send
+// once ctx is done
+func (s *Scanner) emit(ctx context.Context, e Event) {
+	switch e.Kind {
+	case EventOpen:
+		if s.OnOpen != nil {
+			s.OnOpen(e.Addr)
+		}
+	case EventFound:
+		if s.OnFound != nil {
+			s.OnFound(e.Result)
+		}
+	case EventProgress:
+		if s.OnProgress != nil {
+			s.OnProgress(e.Current, e.All)
+		}
+	}
+	if s.Events == nil {
+		return
+	}
+	select {
+	case s.Events <- e:
+	case <-ctx.Done():
+	}
+}
@@ -3,7 +3,6 @@ package geoip
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 )
@@ -18,24 +17,25 @@ type cloudFlareResp struct {
 	Latitude       string `json:"latitude"`
 	Longitude      string `json:"longitude"`
 	AsOrganization string `json:"asOrganization"`
+	Asn            int    `json:"asn"`
 }
 
 func CloudFlare(c *http.Client) *GeoIP {
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://cloudflare-ip.html.zone/geo?_t=%d", time.Now().UnixMilli()), nil)
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s?_t=%d", CloudFlareURL, time.Now().UnixMilli()), nil)
 	if err != nil {
-		log.Printf("cloudflare geo request: %v", err)
+		logger.Warn("geo request failed", "provider", "cloudflare", "err", err)
 		return nil
 	}
 	req.Header.Set("User-Agent", userAgent)
 	resp, err := c.Do(req)
 	if err != nil {
-		log.Printf("cloudflare do request: %v", err)
+		logger.Warn("geo lookup failed", "provider", "cloudflare", "err", err)
 		return nil
 	}
 
 	var cfResp cloudFlareResp
 	if err := json.NewDecoder(resp.Body).Decode(&cfResp); err != nil {
-		log.Printf("cloudflare decode response: %v", err)
+		logger.Warn("geo response invalid", "provider", "cloudflare", "err", err)
 		return nil
 	}
 
@@ -43,5 +43,6 @@ func CloudFlare(c *http.Client) *GeoIP {
 		City:    cfResp.City,
 		Country: cfResp.Country,
 		ASOrg:   cfResp.AsOrganization,
+		ASN:     uint32(cfResp.Asn),
 	}
 }
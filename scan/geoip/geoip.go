@@ -2,8 +2,11 @@ package geoip
 
 import (
 	"fmt"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
 	"golang.org/x/net/proxy"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -11,38 +14,65 @@ type GeoIP struct {
 	City    string
 	Country string
 	ASOrg   string
+	ASN     uint32
 }
 
 const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/127.0.0.0 Safari/537.36 Edg/127.0.0.0"
 
 var (
 	TestTimeout = time.Second * 5
+
+	CloudFlareURL = "https://cloudflare-ip.html.zone/geo"
+	IPsbURL       = "https://api-ipv4.ip.sb/geoip"
+	IPWhoURL      = "https://ipwho.is/"
 )
 
-var tryOrder = []func(*http.Client) *GeoIP{
-	CloudFlare, IPsb, ipWho,
+// logger samples, a dead provider fails for every proxy
+var logger = logging.Sampled(logging.New("geoip"), 5, time.Minute)
+
+type provider struct {
+	name   string
+	lookup func(*http.Client) *GeoIP
+}
+
+var tryOrder = []provider{
+	{"cloudflare", CloudFlare}, {"ipsb", IPsb}, {"ipwho", ipWho},
 }
 
 func GetGeo(addrPort string) (*GeoIP, error) {
-	dialer, err := proxy.SOCKS5("tcp", addrPort, nil, proxy.Direct)
-	if err != nil {
-		return nil, err
-	}
-	dialerCtx, ok := dialer.(proxy.ContextDialer)
-	if !ok {
-		return nil, err
+	return GetGeoVia("socks5", addrPort)
+}
+
+// GetGeoVia is GetGeo through a proxy of protocol, socks5 or http
+func GetGeoVia(protocol, addrPort string) (*GeoIP, error) {
+	var transport *http.Transport
+	switch protocol {
+	case "socks5":
+		dialer, err := proxy.SOCKS5("tcp", addrPort, nil, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		dialerCtx, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, err
+		}
+		transport = &http.Transport{DialContext: dialerCtx.DialContext}
+	case "http":
+		transport = &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: addrPort})}
+	default:
+		return nil, fmt.Errorf("geoip through %s proxies is not supported", protocol)
 	}
 	c := &http.Client{
-		Transport: &http.Transport{
-			DialContext: dialerCtx.DialContext,
-		},
-		Timeout: TestTimeout,
+		Transport: transport,
+		Timeout:   TestTimeout,
 	}
 
-	for _, f := range tryOrder {
-		if geo := f(c); geo != nil {
+	for _, p := range tryOrder {
+		metrics.GeoLookups.WithLabelValues(p.name).Inc()
+		if geo := p.lookup(c); geo != nil {
 			return geo, nil
 		}
+		metrics.GeoErrors.WithLabelValues(p.name).Inc()
 	}
 
 	return nil, fmt.Errorf("no geoip found")
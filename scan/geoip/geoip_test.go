@@ -1,26 +1,44 @@
 package geoip
 
 import (
-	"net/http"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
+func useSimnet(t *testing.T, n *simnet.Net) {
+	oldCF, oldSB, oldWho := CloudFlareURL, IPsbURL, IPWhoURL
+	t.Cleanup(func() { CloudFlareURL, IPsbURL, IPWhoURL = oldCF, oldSB, oldWho })
+	CloudFlareURL = n.Web.URL("/cf/geo")
+	IPsbURL = n.Web.URL("/ipsb/geoip")
+	IPWhoURL = n.Web.URL("/ipwho/")
+}
+
 func TestGetGeo(t *testing.T) {
-	geo, err := GetGeo("127.0.0.1:7890")
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	geo, err := GetGeo(n.NewProxy(simnet.Socks5).Addr())
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	t.Log(geo)
+	assert.Equal(t, &GeoIP{City: "Nanjing", Country: "CN", ASOrg: "CERNET", ASN: 4538}, geo)
+
+	_, err = GetGeo(n.NewProxy(simnet.Broken).Addr())
+	assert.Error(t, err)
 }
 
 func TestEndpoint(t *testing.T) {
-	for i, f := range tryOrder {
-		res := f(http.DefaultClient)
-		if res != nil {
-			t.Log(res)
-		} else {
-			t.Logf("No geoip found in %d", i)
+	n := simnet.New()
+	defer n.Close()
+	useSimnet(t, n)
+
+	for _, p := range tryOrder {
+		res := p.lookup(n.HTTPClient())
+		if assert.NotNil(t, res, p.name) {
+			assert.Equal(t, "CN", res.Country)
 		}
 	}
 }
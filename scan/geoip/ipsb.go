@@ -2,7 +2,6 @@ package geoip
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 )
 
@@ -26,22 +25,22 @@ type ipsbResp struct {
 }
 
 func IPsb(c *http.Client) *GeoIP {
-	req, err := http.NewRequest(http.MethodGet, "https://api-ipv4.ip.sb/geoip", nil)
+	req, err := http.NewRequest(http.MethodGet, IPsbURL, nil)
 	if err != nil {
-		log.Printf("ip.sb geo request: %v", err)
+		logger.Warn("geo request failed", "provider", "ipsb", "err", err)
 		return nil
 	}
 
 	req.Header.Set("User-Agent", userAgent)
 	resp, err := c.Do(req)
 	if err != nil {
-		log.Printf("ip.sb do request: %v", err)
+		logger.Warn("geo lookup failed", "provider", "ipsb", "err", err)
 		return nil
 	}
 
 	var ipResp ipsbResp
 	if err := json.NewDecoder(resp.Body).Decode(&ipResp); err != nil {
-		log.Printf("ip.sb decode response: %v", err)
+		logger.Warn("geo response invalid", "provider", "ipsb", "err", err)
 		return nil
 	}
 
@@ -49,5 +48,6 @@ func IPsb(c *http.Client) *GeoIP {
 		City:    ipResp.City,
 		Country: ipResp.Country,
 		ASOrg:   ipResp.AsnOrganization,
+		ASN:     uint32(ipResp.Asn),
 	}
 }
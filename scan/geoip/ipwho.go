@@ -2,7 +2,6 @@ package geoip
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"time"
 )
@@ -47,22 +46,22 @@ type ipWhoResp struct {
 }
 
 func ipWho(c *http.Client) *GeoIP {
-	req, err := http.NewRequest(http.MethodGet, "https://ipwho.is/", nil)
+	req, err := http.NewRequest(http.MethodGet, IPWhoURL, nil)
 	if err != nil {
-		log.Printf("ipwho geo request: %v", err)
+		logger.Warn("geo request failed", "provider", "ipwho", "err", err)
 		return nil
 	}
 
 	req.Header.Set("User-Agent", userAgent)
 	resp, err := c.Do(req)
 	if err != nil {
-		log.Printf("ipwho do request: %v", err)
+		logger.Warn("geo lookup failed", "provider", "ipwho", "err", err)
 		return nil
 	}
 
 	var ipResp ipWhoResp
 	if err := json.NewDecoder(resp.Body).Decode(&ipResp); err != nil {
-		log.Printf("ipwho decode response: %v", err)
+		logger.Warn("geo response invalid", "provider", "ipwho", "err", err)
 		return nil
 	}
 
@@ -70,5 +69,6 @@ func ipWho(c *http.Client) *GeoIP {
 		City:    ipResp.City,
 		Country: ipResp.Country,
 		ASOrg:   ipResp.Connection.Org,
+		ASN:     uint32(ipResp.Connection.Asn),
 	}
 }
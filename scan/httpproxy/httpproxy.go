@@ -0,0 +1,62 @@
+// Package httpproxy detects open HTTP proxies
+package httpproxy
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"net/url"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+)
+
+// ExpectStatus is the status the test url answers with. Web servers answer
+// an absolute url themselves, usually with 200 or 404, so any other status
+// is not taken as a proxy.
+var ExpectStatus = http.StatusNoContent
+
+func init() {
+	prober.Register(Prober{})
+}
+
+// Prober sends a proxy request for the test url to the port
+type Prober struct{}
+
+func (Prober) Info() prober.Info {
+	return prober.Info{Name: "http", Protocols: []string{"http"}, Order: 10, Cost: 1}
+}
+
+func (Prober) Probe(ctx context.Context, opts prober.Options, res *prober.Result) prober.Verdict {
+	switch status, err := Check(ctx, res.AddrPort, opts.TestURL); {
+	case err != nil:
+	case status == ExpectStatus:
+		res.Confirm("http", true)
+	case status == http.StatusProxyAuthRequired:
+		res.Confirm("http", false)
+	}
+	return prober.Continue
+}
+
+// Check requests testURL through addr as an HTTP proxy and returns the status
+func Check(ctx context.Context, addr netip.AddrPort, testURL string) (int, error) {
+	c := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: addr.String()}),
+		},
+		Timeout: prober.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	defer c.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, testURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
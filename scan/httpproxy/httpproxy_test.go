@@ -0,0 +1,37 @@
+package httpproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbe(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	opts := prober.Options{TestURL: "http://www.gstatic.com/generate_204"}
+	run := func(addr netip.AddrPort) *prober.Result {
+		return prober.Set{Prober{}}.Run(context.Background(), opts, addr)
+	}
+
+	res := run(n.NewProxy(simnet.HTTPProxy).AddrPort())
+	assert.Equal(t, "http", res.Protocol)
+	assert.True(t, res.Success)
+
+	res = run(n.NewProxy(simnet.Behaviour{HTTP: true, Username: "u", Password: "p"}).AddrPort())
+	assert.Equal(t, "http", res.Protocol)
+	assert.True(t, res.Auth)
+
+	assert.Nil(t, run(n.NewProxy(simnet.Socks5).AddrPort()))
+
+	// a web server answering the proxy request itself
+	web := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer web.Close()
+	assert.Nil(t, run(netip.MustParseAddrPort(web.Listener.Addr().String())))
+}
@@ -0,0 +1,198 @@
+// Package inbound guesses encrypted proxy inbounds, Shadowsocks, VMess and
+// Trojan, on ports no other prober recognized. They can not be used without
+// keys, the findings only flag a proxy deployment.
+//
+// The guesses rest on how these servers treat a client that does not know
+// the key: they never speak first, Shadowsocks keeps reading random bytes
+// without an answer, VMess drains them and hangs up after a while, and
+// Trojan completes a TLS handshake and hands anything else to a web fallback.
+// As every https server answers garbage with an error too, a TLS port
+// answering with http is taken for an ordinary service, only one that stays
+// silent or hangs up inside TLS is taken for Trojan.
+package inbound
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/scan/prober"
+)
+
+var logger = logging.New("inbound")
+
+var (
+	// BannerWait is how long a server speaking first gets to send a banner
+	BannerWait = 500 * time.Millisecond
+	// HoldWait is how long an answer to random bytes is waited for, a
+	// connection still open afterwards is taken as held open
+	HoldWait = 3 * time.Second
+	// QuickClose is the limit of an immediate close
+	QuickClose = 100 * time.Millisecond
+)
+
+func init() {
+	prober.Register(Prober{})
+}
+
+// Prober classifies ports the protocol probers left unrecognized
+type Prober struct{}
+
+func (Prober) Info() prober.Info {
+	return prober.Info{Name: "inbound", Protocols: []string{"shadowsocks", "vmess", "trojan"}, Order: 100, Cost: 5}
+}
+
+func (Prober) Probe(ctx context.Context, _ prober.Options, res *prober.Result) prober.Verdict {
+	if res.Found() || len(res.Findings) > 0 {
+		return prober.Continue
+	}
+	res.Findings = append(res.Findings, Classify(ctx, res.AddrPort)...)
+	return prober.Continue
+}
+
+// Classify returns the findings about addr, none when it looks like an
+// ordinary service or could not be reached
+func Classify(ctx context.Context, addr netip.AddrPort) []prober.Finding {
+	if speaksFirst(ctx, addr) {
+		return nil
+	}
+	if f, ok := classifyTLS(ctx, addr); ok {
+		return f
+	}
+	return classifyRandom(ctx, addr)
+}
+
+func dial(ctx context.Context, addr netip.AddrPort) (net.Conn, error) {
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, prober.Timeout)
+	defer cancel()
+	return d.DialContext(ctx, "tcp", addr.String())
+}
+
+// speaksFirst reports whether the server sends a banner, like ssh or smtp.
+// An unreachable port counts as speaking first, there is nothing to guess.
+func speaksFirst(ctx context.Context, addr netip.AddrPort) bool {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return true
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(BannerWait))
+	var b [1]byte
+	n, _ := conn.Read(b[:])
+	return n > 0
+}
+
+// classifyTLS tries a handshake, ok is false when the port does not speak TLS
+func classifyTLS(ctx context.Context, addr netip.AddrPort) (findings []prober.Finding, ok bool) {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return nil, false
+	}
+	defer conn.Close()
+	// a server silent to the client hello is left to classifyRandom
+	conn.SetDeadline(time.Now().Add(HoldWait))
+	tc := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, ServerName: "www.example.com"})
+	if err := tc.HandshakeContext(ctx); err != nil {
+		return nil, false
+	}
+
+	reasons := []string{"no banner", "tls handshake completed"}
+	confidence := 0.3
+	state := tc.ConnectionState()
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		if cert.Issuer.String() == cert.Subject.String() {
+			reasons = append(reasons, "self-signed certificate")
+			confidence += 0.15
+		}
+		if cert.VerifyHostname("www.example.com") != nil {
+			reasons = append(reasons, "certificate does not match the requested name")
+			confidence += 0.05
+		}
+	}
+
+	// a trojan request with a wrong password goes to the fallback
+	req := make([]byte, 56)
+	for i := range req {
+		req[i] = "0123456789abcdef"[i%16]
+	}
+	req = append(req, "\r\n\x01\x01\x7f\x00\x00\x01\x00\x50\r\n"...)
+	if _, err := tc.Write(req); err != nil {
+		return nil, true
+	}
+	tc.SetReadDeadline(time.Now().Add(HoldWait))
+	resp, err := http.ReadResponse(bufio.NewReader(tc), nil)
+	switch {
+	case err == nil:
+		resp.Body.Close()
+		// what any https server does with garbage, a trojan fallback
+		// included, nothing to report
+		logger.Debug("plain tls service", "addr", addr, "status", resp.Status)
+		return nil, true
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		reasons = append(reasons, "silent to an invalid request inside tls")
+		confidence += 0.1
+		return []prober.Finding{
+			{Kind: prober.KindInbound, Label: "trojan", Confidence: confidence, Reasons: reasons},
+			{Kind: prober.KindInbound, Label: "vmess", Confidence: confidence - 0.1, Reasons: append(slices.Clip(reasons), "vmess over tls drains unknown clients")},
+		}, true
+	default:
+		reasons = append(reasons, "connection closed without an answer inside tls")
+	}
+	return []prober.Finding{{Kind: prober.KindInbound, Label: "trojan", Confidence: confidence, Reasons: reasons}}, true
+}
+
+// classifyRandom sends random bytes and watches how the server reacts
+func classifyRandom(ctx context.Context, addr netip.AddrPort) []prober.Finding {
+	conn, err := dial(ctx, addr)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	// the line ends make line based servers, eg http, answer with an error
+	junk := make([]byte, 64, 68)
+	_, _ = rand.Read(junk)
+	junk = append(junk, "\r\n\r\n"...)
+	if _, err := conn.Write(junk); err != nil {
+		return nil
+	}
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(HoldWait))
+	var b [1]byte
+	n, err := conn.Read(b[:])
+	elapsed := time.Since(start)
+	reasons := []string{"no banner"}
+	switch {
+	case n > 0:
+		// some service answering garbage, eg a web server with 400
+		return nil
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		reasons = append(reasons, fmt.Sprintf("silent on random bytes and held the connection open for %s", HoldWait))
+		return []prober.Finding{{Kind: prober.KindInbound, Label: "shadowsocks", Confidence: 0.6, Reasons: reasons}}
+	case errors.Is(err, io.EOF) || isReset(err):
+		if elapsed < QuickClose {
+			reasons = append(reasons, fmt.Sprintf("closed immediately (%s) without an answer to random bytes", elapsed.Round(time.Millisecond)))
+			return []prober.Finding{{Kind: prober.KindInbound, Label: "shadowsocks", Confidence: 0.3, Reasons: reasons}}
+		}
+		reasons = append(reasons, fmt.Sprintf("drained random bytes and closed after %s without an answer", elapsed.Round(time.Millisecond)))
+		return []prober.Finding{{Kind: prober.KindInbound, Label: "vmess", Confidence: 0.5, Reasons: reasons}}
+	}
+	return nil
+}
+
+func isReset(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && !opErr.Timeout()
+}
@@ -0,0 +1,89 @@
+package inbound
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	oldBanner, oldHold := BannerWait, HoldWait
+	t.Cleanup(func() { BannerWait, HoldWait = oldBanner, oldHold })
+	BannerWait, HoldWait = 50*time.Millisecond, time.Second
+	n := simnet.New()
+	defer n.Close()
+	best := func(addr netip.AddrPort) *prober.Finding {
+		return (&prober.Result{Findings: Classify(context.Background(), addr)}).Best()
+	}
+
+	f := best(n.NewProxy(simnet.Shadowsocks).AddrPort())
+	assert.Equal(t, "shadowsocks", f.Label)
+	assert.Contains(t, f.Reasons[1], "held the connection open")
+
+	f = best(n.NewProxy(simnet.VMess).AddrPort())
+	assert.Equal(t, "vmess", f.Label)
+	assert.Contains(t, f.Reasons[1], "drained random bytes")
+
+	f = best(n.NewProxy(simnet.Trojan).AddrPort())
+	assert.Equal(t, "trojan", f.Label)
+	assert.Contains(t, f.Reasons, "self-signed certificate")
+	assert.Contains(t, f.Reasons, "connection closed without an answer inside tls")
+
+	// ordinary services
+	web := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer web.Close()
+	assert.Nil(t, best(netip.MustParseAddrPort(web.Listener.Addr().String())))
+
+	// https sites answer the invalid request, whatever their certificate
+	site := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	site.TLS = &tls.Config{Certificates: []tls.Certificate{simnet.NewCA("test").Issue("site.example")}}
+	site.StartTLS()
+	defer site.Close()
+	assert.Nil(t, best(netip.MustParseAddrPort(site.Listener.Addr().String())))
+	selfSigned := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer selfSigned.Close()
+	assert.Nil(t, best(netip.MustParseAddrPort(selfSigned.Listener.Addr().String())))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = c.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			c.Close()
+		}
+	}()
+	assert.Nil(t, best(netip.MustParseAddrPort(ln.Addr().String())))
+}
+
+func TestProbeSkipsKnown(t *testing.T) {
+	res := &prober.Result{AddrPort: netip.MustParseAddrPort("127.0.0.1:1"), Protocol: "socks5"}
+	assert.Equal(t, prober.Continue, Prober{}.Probe(context.Background(), prober.Options{}, res))
+	assert.Empty(t, res.Findings)
+}
+
+func TestRunPlainTLS(t *testing.T) {
+	oldBanner, oldHold := BannerWait, HoldWait
+	t.Cleanup(func() { BannerWait, HoldWait = oldBanner, oldHold })
+	BannerWait, HoldWait = 50*time.Millisecond, time.Second
+
+	site := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer site.Close()
+	addr := netip.MustParseAddrPort(site.Listener.Addr().String())
+	assert.Nil(t, prober.Set{Prober{}}.Run(context.Background(), prober.Options{}, addr))
+}
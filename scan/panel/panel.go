@@ -0,0 +1,183 @@
+// Package panel detects management apis reachable next to proxies: the
+// Clash RESTful api with its yacd/metacubexd dashboards, v2rayA and
+// OpenClash behind LuCI. An unauthenticated one hands over the whole proxy
+// configuration and is reported with high severity.
+//
+// Only GET requests are sent and redirects are not followed, the remote
+// configuration is never changed.
+package panel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+)
+
+// Timeout bounds every request, a port silent to http costs it once
+var Timeout = 3 * time.Second
+
+func init() {
+	prober.Register(Prober{})
+}
+
+// Prober runs every check on ports answering HTTP
+type Prober struct{}
+
+func (Prober) Info() prober.Info {
+	return prober.Info{Name: "panel", Protocols: []string{"clash-api", "v2raya", "openclash"}, Order: 50, Cost: 2}
+}
+
+func (Prober) Probe(ctx context.Context, _ prober.Options, res *prober.Result) prober.Verdict {
+	res.Findings = append(res.Findings, Detect(ctx, res.AddrPort)...)
+	return prober.Continue
+}
+
+// maxBody bounds what is read of a response
+const maxBody = 64 << 10
+
+type client struct {
+	c    *http.Client
+	base string
+}
+
+// get fetches path, body is nil when the response is not json
+func (c client) get(ctx context.Context, path string) (status int, body map[string]any, text string, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return 0, nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return 0, nil, "", err
+	}
+	if json.Unmarshal(data, &body) != nil {
+		body = nil
+	}
+	return resp.StatusCode, body, string(data), nil
+}
+
+// Detect returns a finding for every panel found on addr
+func Detect(ctx context.Context, addr netip.AddrPort) []prober.Finding {
+	c := client{
+		c: &http.Client{
+			Timeout: Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		base: "http://" + addr.String(),
+	}
+	defer c.c.CloseIdleConnections()
+
+	var findings []prober.Finding
+	// the first request also tells whether the port speaks http at all
+	status, body, _, err := c.get(ctx, "/version")
+	if err != nil {
+		return nil
+	}
+	if f := clashAPI(ctx, c, status, body); f != nil {
+		findings = append(findings, *f)
+	}
+	for _, check := range []func(context.Context, client) *prober.Finding{v2rayA, openClash} {
+		if f := check(ctx, c); f != nil {
+			findings = append(findings, *f)
+		}
+	}
+	return findings
+}
+
+// clashAPI looks at the answer to GET /version, then reads /configs
+func clashAPI(ctx context.Context, c client, status int, version map[string]any) *prober.Finding {
+	switch {
+	case status == http.StatusUnauthorized && version["message"] == "Unauthorized":
+		return &prober.Finding{
+			Kind: prober.KindPanel, Label: "clash-api", Confidence: 0.7, Severity: prober.SeverityMedium,
+			Reasons: []string{"GET /version requires a secret (401 Unauthorized)"},
+		}
+	case status != http.StatusOK || version["version"] == nil:
+		return nil
+	}
+
+	f := &prober.Finding{Kind: prober.KindPanel, Label: "clash-api", Confidence: 0.9, Severity: prober.SeverityHigh}
+	v := fmt.Sprintf("GET /version answered without a secret: %v", version["version"])
+	if version["meta"] == true {
+		v += " (mihomo)"
+	}
+	f.Reasons = append(f.Reasons, v)
+
+	status, configs, _, err := c.get(ctx, "/configs")
+	if err == nil && status == http.StatusOK && configs["mode"] != nil {
+		f.Confidence = 1
+		var parts []string
+		for _, k := range []string{"mode", "port", "socks-port", "mixed-port", "allow-lan"} {
+			if v, ok := configs[k]; ok {
+				parts = append(parts, fmt.Sprintf("%s %v", k, v))
+			}
+		}
+		f.Reasons = append(f.Reasons, "GET /configs readable: "+strings.Join(parts, ", "))
+	}
+	if status, _, _, err := c.get(ctx, "/ui/"); err == nil && (status == http.StatusOK || status == http.StatusMovedPermanently) {
+		f.Reasons = append(f.Reasons, "dashboard served at /ui")
+	}
+	return f
+}
+
+// v2rayA answers /api/version to anyone, /api/touch only with a token
+func v2rayA(ctx context.Context, c client) *prober.Finding {
+	status, body, _, err := c.get(ctx, "/api/version")
+	if err != nil || status != http.StatusOK || body["code"] != "SUCCESS" {
+		return nil
+	}
+	data, _ := body["data"].(map[string]any)
+	if data["version"] == nil {
+		return nil
+	}
+	f := &prober.Finding{
+		Kind: prober.KindPanel, Label: "v2raya", Confidence: 0.9,
+		Reasons: []string{fmt.Sprintf("GET /api/version: v2rayA %v", data["version"])},
+	}
+	status, body, _, err = c.get(ctx, "/api/touch")
+	switch {
+	case err == nil && status == http.StatusOK && body["code"] == "SUCCESS":
+		f.Severity, f.Confidence = prober.SeverityHigh, 1
+		f.Reasons = append(f.Reasons, "GET /api/touch returns the configuration without a token")
+	default:
+		f.Severity = prober.SeverityMedium
+		f.Reasons = append(f.Reasons, "GET /api/touch requires a token")
+	}
+	return f
+}
+
+// openClash looks for the OpenClash page of LuCI
+func openClash(ctx context.Context, c client) *prober.Finding {
+	status, _, text, err := c.get(ctx, "/cgi-bin/luci/admin/services/openclash")
+	if err != nil || status == http.StatusNotFound {
+		return nil
+	}
+	lower := strings.ToLower(text)
+	switch {
+	case strings.Contains(lower, "luci_password"):
+		return &prober.Finding{
+			Kind: prober.KindPanel, Label: "luci", Confidence: 0.8, Severity: prober.SeverityMedium,
+			Reasons: []string{"router admin login page exposed at /cgi-bin/luci"},
+		}
+	case status == http.StatusOK && strings.Contains(lower, "openclash"):
+		return &prober.Finding{
+			Kind: prober.KindPanel, Label: "openclash", Confidence: 0.9, Severity: prober.SeverityHigh,
+			Reasons: []string{"OpenClash page of LuCI served without a login"},
+		}
+	}
+	return nil
+}
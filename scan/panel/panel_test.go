@@ -0,0 +1,112 @@
+package panel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	mu      sync.Mutex
+	methods map[string]int
+}
+
+func (r *recorder) server(t *testing.T, routes map[string]string, status map[string]int) netip.AddrPort {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.methods[req.Method]++
+		r.mu.Unlock()
+		body, ok := routes[req.URL.Path]
+		if !ok {
+			http.NotFound(rw, req)
+			return
+		}
+		if code, ok := status[req.URL.Path]; ok {
+			rw.WriteHeader(code)
+		}
+		fmt.Fprint(rw, body)
+	}))
+	t.Cleanup(srv.Close)
+	return netip.MustParseAddrPort(srv.Listener.Addr().String())
+}
+
+func labels(fs []prober.Finding) map[string]string {
+	m := make(map[string]string)
+	for _, f := range fs {
+		m[f.Label] = f.Severity
+	}
+	return m
+}
+
+func TestDetect(t *testing.T) {
+	r := &recorder{methods: make(map[string]int)}
+	ctx := context.Background()
+
+	clash := Detect(ctx, r.server(t, map[string]string{
+		"/version": `{"meta":true,"version":"v1.18.5"}`,
+		"/configs": `{"port":0,"socks-port":0,"mixed-port":7890,"allow-lan":true,"mode":"rule"}`,
+		"/ui/":     `<html>metacubexd</html>`,
+	}, nil))
+	assert.Len(t, clash, 1)
+	assert.Equal(t, prober.SeverityHigh, clash[0].Severity)
+	assert.Equal(t, float64(1), clash[0].Confidence)
+	assert.Equal(t, []string{
+		"GET /version answered without a secret: v1.18.5 (mihomo)",
+		"GET /configs readable: mode rule, port 0, socks-port 0, mixed-port 7890, allow-lan true",
+		"dashboard served at /ui",
+	}, clash[0].Reasons)
+
+	secret := Detect(ctx, r.server(t, map[string]string{"/version": `{"message":"Unauthorized"}`},
+		map[string]int{"/version": http.StatusUnauthorized}))
+	assert.Equal(t, map[string]string{"clash-api": prober.SeverityMedium}, labels(secret))
+
+	open := Detect(ctx, r.server(t, map[string]string{
+		"/api/version": `{"code":"SUCCESS","data":{"version":"2.2.5"}}`,
+		"/api/touch":   `{"code":"SUCCESS","data":{"touch":{}}}`,
+	}, nil))
+	assert.Equal(t, map[string]string{"v2raya": prober.SeverityHigh}, labels(open))
+
+	auth := Detect(ctx, r.server(t, map[string]string{
+		"/api/version": `{"code":"SUCCESS","data":{"version":"2.2.5"}}`,
+		"/api/touch":   `{"code":"UNAUTHORIZED","message":"token is invalid"}`,
+	}, map[string]int{"/api/touch": http.StatusUnauthorized}))
+	assert.Equal(t, map[string]string{"v2raya": prober.SeverityMedium}, labels(auth))
+
+	luci := Detect(ctx, r.server(t, map[string]string{
+		"/cgi-bin/luci/admin/services/openclash": `<input name="luci_password" type="password">`,
+	}, map[string]int{"/cgi-bin/luci/admin/services/openclash": http.StatusForbidden}))
+	assert.Equal(t, map[string]string{"luci": prober.SeverityMedium}, labels(luci))
+
+	oc := Detect(ctx, r.server(t, map[string]string{
+		"/cgi-bin/luci/admin/services/openclash": `<title>OpenClash</title>`,
+	}, nil))
+	assert.Equal(t, map[string]string{"openclash": prober.SeverityHigh}, labels(oc))
+
+	assert.Empty(t, Detect(ctx, r.server(t, map[string]string{"/": "hello"}, nil)))
+	assert.Equal(t, []string{http.MethodGet}, keys(r.methods), "panels must never be modified")
+}
+
+func keys(m map[string]int) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func TestDetectNotHTTP(t *testing.T) {
+	Timeout = 200 * time.Millisecond
+	n := simnet.New()
+	defer n.Close()
+	assert.Empty(t, Detect(context.Background(), n.NewProxy(simnet.Shadowsocks).AddrPort()))
+	assert.Empty(t, Detect(context.Background(), n.NewProxy(simnet.Socks5).AddrPort()))
+}
@@ -0,0 +1,212 @@
+// Package ports parses port specifications.
+//
+// A specification is a comma separated list of items:
+//
+//	7890          a single port
+//	20170-20172   an inclusive range
+//	clash         a named preset, see Presets
+//	top:20        the first N ports of Top
+//	!7893         exclude any of the above, wins over inclusion
+package ports
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is used when no port is specified
+const Default = "clash,v2ray"
+
+// Presets are named port lists of common proxy software
+var Presets = map[string][]int{
+	// clash / mihomo: mixed, socks, redir, tproxy
+	"clash": {7890, 7891, 7892, 7893},
+	// v2rayN socks/http and v2rayA socks/http/mixed
+	"v2ray":        {10808, 10809, 20170, 20171, 20172},
+	"socks-common": {1080, 1081, 1086, 7891, 10808, 20170},
+	"http-proxy":   {3128, 8080, 8118, 8888, 7890, 10809, 20171},
+	// management apis: clash external-controller, clash verge, v2rayA web ui
+	"panel": {9090, 9097, 2017},
+}
+
+// Top is the port list of top:N, most likely first. The built-in one is
+// hand-ordered, not measured: the defaults of clash and v2ray clients
+// first, then other common proxy ports. Rank orders it by the hits of
+// earlier scans, ReadFile loads a list of your own.
+var Top = []int{
+	7890, 10808, 7891, 1080, 10809, 20170, 20171, 7893, 8080, 7892,
+	20172, 1081, 8888, 3128, 1086, 10810, 8118, 9090, 1089, 2080,
+	10800, 10801, 7897, 7899, 8889, 12345, 1088, 10086, 4444, 18080,
+}
+
+// Parse parses a port specification, see the package doc.
+// The result keeps the order of first appearance and has no duplicates.
+func Parse(spec string) ([]int, error) {
+	var include, exclude []int
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		neg := strings.HasPrefix(item, "!")
+		if neg {
+			item = strings.TrimSpace(item[1:])
+		}
+		p, err := parseItem(item)
+		if err != nil {
+			return nil, err
+		}
+		if neg {
+			exclude = append(exclude, p...)
+		} else {
+			include = append(include, p...)
+		}
+	}
+
+	seen := make(map[int]struct{}, len(include)+len(exclude))
+	for _, p := range exclude {
+		seen[p] = struct{}{}
+	}
+	res := make([]int, 0, len(include))
+	for _, p := range include {
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		res = append(res, p)
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("port spec %q selects no port", spec)
+	}
+	return res, nil
+}
+
+func parseItem(item string) ([]int, error) {
+	if p, ok := Presets[strings.ToLower(item)]; ok {
+		return p, nil
+	}
+	if n, ok := strings.CutPrefix(strings.ToLower(item), "top:"); ok {
+		v, err := strconv.Atoi(n)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid top count %q", n)
+		}
+		return Top[:min(v, len(Top))], nil
+	}
+	if from, to, ok := strings.Cut(item, "-"); ok {
+		start, err := parsePort(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parsePort(to)
+		if err != nil {
+			return nil, err
+		}
+		if end < start {
+			return nil, fmt.Errorf("reversed port range %q", item)
+		}
+		res := make([]int, 0, end-start+1)
+		for i := start; i <= end; i++ {
+			res = append(res, i)
+		}
+		return res, nil
+	}
+	p, err := parsePort(item)
+	if err != nil {
+		return nil, err
+	}
+	return []int{p}, nil
+}
+
+func parsePort(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	if v < 1 || v > 65535 {
+		return 0, fmt.Errorf("port %d out of range 1-65535", v)
+	}
+	return v, nil
+}
+
+// Rank returns the ports with hits, most first, followed by the ports of
+// Top without any. hits is the number of proxies found per port in earlier
+// scans, see store.PortHits.
+func Rank(hits map[int]int) []int {
+	res := make([]int, 0, len(hits)+len(Top))
+	for p, n := range hits {
+		if n > 0 {
+			res = append(res, p)
+		}
+	}
+	slices.SortFunc(res, func(a, b int) int {
+		return cmp.Or(cmp.Compare(hits[b], hits[a]), cmp.Compare(a, b))
+	})
+	for _, p := range Top {
+		if hits[p] <= 0 {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// ReadFile reads a Top list, one port per line, most likely first. Empty
+// lines and # comments are ignored.
+func ReadFile(name string) ([]int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read is ReadFile on a reader
+func Read(r io.Reader) ([]int, error) {
+	var res []int
+	seen := make(map[int]struct{})
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		p, err := parsePort(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if _, ok := seen[p]; ok {
+			continue
+		}
+		seen[p] = struct{}{}
+		res = append(res, p)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, errors.New("no port")
+	}
+	return res, nil
+}
+
+// PresetNames returns the sorted names of Presets
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,49 @@
+package ports
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse(Default)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{7890, 7891, 7892, 7893, 10808, 10809, 20170, 20171, 20172}, p)
+
+	p, err = Parse("10808,10809,20170-20172,7890-7893")
+	assert.NoError(t, err)
+	assert.Len(t, p, 9)
+
+	p, err = Parse("top:3,clash,!7891,!8000-9000")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{7890, 10808, 7892, 7893}, p)
+
+	p, err = Parse("top:1000")
+	assert.NoError(t, err)
+	assert.Equal(t, Top, p)
+
+	for _, bad := range []string{"0", "65536", "20-10", "abc", "top:0", "1-", "7890,!7890", ""} {
+		_, err := Parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestRead(t *testing.T) {
+	p, err := Read(strings.NewReader("# from last month\n1080\n\n7890 # clash\n1080\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1080, 7890}, p)
+
+	_, err = Read(strings.NewReader("1080\nclash\n"))
+	assert.ErrorContains(t, err, "line 2")
+	_, err = Read(strings.NewReader("# nothing\n"))
+	assert.Error(t, err)
+}
+
+func TestRank(t *testing.T) {
+	top := Rank(map[int]int{1080: 3, 8443: 5, 7890: 3, 9999: 0})
+	assert.Equal(t, []int{8443, 1080, 7890, 10808, 7891}, top[:5])
+	assert.Len(t, top, len(Top)+1)
+	assert.Equal(t, Top, Rank(nil))
+}
@@ -0,0 +1,314 @@
+// Package prober identifies the proxy protocol behind an open port.
+//
+// Probers register themselves like the tcpscanner backends, a blank import
+// of a package adds its probers. Every open port runs the probers in order
+// on a shared Result: a prober can enrich it and let the next one run, or
+// stop the chain when nothing more is to be learned.
+package prober
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout bounds a single probe of the probers without their own setting
+var Timeout = 5 * time.Second
+
+// Result is the record shared by the probers of one port
+type Result struct {
+	AddrPort netip.AddrPort
+	// Protocol is the protocol the port is used as, the first one confirmed
+	Protocol string
+	Success  bool
+	UDP      bool
+	// Auth is set when the server only accepts authenticated clients
+	Auth bool
+	// Protocols lists every protocol the port speaks, eg socks5 and http
+	// on a mixed port
+	Protocols []string
+	// Findings are guesses about a port no prober could confirm, eg an
+	// encrypted proxy inbound needing keys
+	Findings []Finding
+	// UDPStats measures the UDP relay of a proxy with UDP set
+	UDPStats *UDPStats
+	// DNSLeak is the remote resolution test of a proxy
+	DNSLeak *DNSLeak
+}
+
+// Finding kinds
+const (
+	KindInbound = "inbound"
+	KindPanel   = "panel"
+)
+
+// Severities, a finding without one is informational
+const (
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Finding is a heuristic label with its confidence between 0 and 1 and the
+// observations it is based on
+type Finding struct {
+	Kind       string   `json:"kind"`
+	Label      string   `json:"label"`
+	Confidence float64  `json:"confidence"`
+	Severity   string   `json:"severity,omitempty"`
+	Reasons    []string `json:"reasons"`
+}
+
+func (f Finding) String() string {
+	s := fmt.Sprintf("%s %.0f%%", f.Label, f.Confidence*100)
+	if f.Severity != "" {
+		s += " " + f.Severity
+	}
+	return s + " (" + strings.Join(f.Reasons, "; ") + ")"
+}
+
+// NAT mapping behaviours of UDPStats
+const (
+	NATEndpointIndependent = "endpoint-independent"
+	NATEndpointDependent   = "endpoint-dependent"
+)
+
+// UDPStats are the numbers of a UDP relay test, times are in milliseconds
+type UDPStats struct {
+	// Target is the test target that answered as kind:addr
+	Target   string `json:"target"`
+	Sent     int    `json:"sent"`
+	Received int    `json:"received"`
+	// Loss is the share of datagrams without reply between 0 and 1
+	Loss   float64 `json:"loss"`
+	RTTMin float64 `json:"rtt_min_ms"`
+	RTTAvg float64 `json:"rtt_avg_ms"`
+	RTTMax float64 `json:"rtt_max_ms"`
+	// NAT is the mapping behaviour seen by two echo servers, empty when
+	// it was not tested
+	NAT string `json:"nat,omitempty"`
+}
+
+func (s *UDPStats) String() string {
+	str := fmt.Sprintf("%.0fms %.0f%% loss", s.RTTAvg, s.Loss*100)
+	switch s.NAT {
+	case NATEndpointIndependent:
+		str += " EIM"
+	case NATEndpointDependent:
+		str += " EDM"
+	}
+	return str
+}
+
+// DNSLeak tells how a proxy resolves a domain it is asked to connect to
+type DNSLeak struct {
+	// Name is the unique name the proxy was asked for
+	Name string `json:"name"`
+	// Remote is set when the proxy resolved the name itself
+	Remote bool `json:"remote"`
+	// Resolvers are the addresses that queried the authoritative server
+	// for Name, the upstream of the proxy
+	Resolvers []netip.Addr `json:"resolvers,omitempty"`
+	// Tampered is set when the proxy connected somewhere else than the
+	// address the authoritative server answered, or could not connect
+	// after resolving it
+	Tampered bool `json:"tampered"`
+	// Error is why the connection through the proxy failed
+	Error string `json:"error,omitempty"`
+}
+
+// Best returns the most severe finding, the most confident one among equals,
+// nil if there is none
+func (r *Result) Best() *Finding {
+	var best *Finding
+	for i := range r.Findings {
+		f := &r.Findings[i]
+		if best == nil || cmp.Or(cmp.Compare(severityRank(f.Severity), severityRank(best.Severity)), cmp.Compare(f.Confidence, best.Confidence)) > 0 {
+			best = f
+		}
+	}
+	return best
+}
+
+func severityRank(s string) int {
+	switch s {
+	case SeverityHigh:
+		return 2
+	case SeverityMedium:
+		return 1
+	}
+	return 0
+}
+
+// Found reports whether a prober recognized a proxy, usable or not
+func (r *Result) Found() bool {
+	return r.Protocol != ""
+}
+
+// Fingerprint summarizes how the port behaved to the probers: its
+// protocols, auth, UDP relay and NAT mapping, and the labels of its
+// findings. Endpoints behaving alike share it, a changed fingerprint means
+// the software or its configuration changed.
+func (r *Result) Fingerprint() string {
+	parts := []string{"protocols=" + strings.Join(r.Protocols, ",")}
+	parts = append(parts, fmt.Sprintf("auth=%t", r.Auth), fmt.Sprintf("udp=%t", r.UDP))
+	if r.UDPStats != nil && r.UDPStats.NAT != "" {
+		parts = append(parts, "nat="+r.UDPStats.NAT)
+	}
+	labels := make([]string, 0, len(r.Findings))
+	for _, f := range r.Findings {
+		labels = append(labels, f.Kind+":"+f.Label)
+	}
+	slices.Sort(labels)
+	parts = append(parts, "findings="+strings.Join(labels, ","))
+	sum := sha256.Sum256([]byte(strings.Join(parts, ";")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Confirm records that the port speaks protocol. The first protocol
+// confirmed becomes Protocol, a usable proxy replaces one requiring auth.
+func (r *Result) Confirm(protocol string, usable bool) {
+	if !slices.Contains(r.Protocols, protocol) {
+		r.Protocols = append(r.Protocols, protocol)
+	}
+	switch {
+	case r.Protocol == "", usable && !r.Success:
+		r.Protocol = protocol
+		r.Success = usable
+		r.Auth = !usable
+	}
+}
+
+// Verdict tells the chain what to do after a probe
+type Verdict int
+
+const (
+	// Continue runs the next prober
+	Continue Verdict = iota
+	// Stop ends the chain, eg the port closed or is known not to be a proxy
+	Stop
+)
+
+// Info describes a prober
+type Info struct {
+	Name string
+	// Protocols the prober detects
+	Protocols []string
+	// Order puts the probers in sequence, lower first
+	Order int
+	// Cost is a rough price of a probe, between probers of the same Order
+	// the cheaper one runs first
+	Cost int
+}
+
+// Options are the settings of the scan passed to every probe
+type Options struct {
+	// TestURL is fetched through the candidate proxy
+	TestURL string
+}
+
+type Prober interface {
+	Info() Info
+	Probe(ctx context.Context, opts Options, res *Result) Verdict
+}
+
+// Registry maps prober names to probers
+type Registry struct {
+	mu   sync.RWMutex
+	list map[string]Prober
+}
+
+func NewRegistry() *Registry {
+	return &Registry{list: make(map[string]Prober)}
+}
+
+// Default has the probers registered by the imported packages
+var Default = NewRegistry()
+
+func Register(p Prober) {
+	Default.Register(p)
+}
+
+var ErrProberNotFound = errors.New("prober not found")
+
+func (r *Registry) Register(p Prober) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.list[p.Info().Name] = p
+}
+
+// Names returns the registered probers in the order they run
+func (r *Registry) Names() []string {
+	var names []string
+	for _, p := range r.All() {
+		names = append(names, p.Info().Name)
+	}
+	return names
+}
+
+// All returns every registered prober in the order they run
+func (r *Registry) All() Set {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := make(Set, 0, len(r.list))
+	for _, p := range r.list {
+		set = append(set, p)
+	}
+	set.sort()
+	return set
+}
+
+// Select returns the probers called names in the order they run
+func (r *Registry) Select(names ...string) (Set, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := make(Set, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		p, ok := r.list[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrProberNotFound, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			set = append(set, p)
+		}
+	}
+	set.sort()
+	return set, nil
+}
+
+// Set is a list of probers run in sequence
+type Set []Prober
+
+func (s Set) sort() {
+	slices.SortStableFunc(s, func(a, b Prober) int {
+		ia, ib := a.Info(), b.Info()
+		return cmp.Or(cmp.Compare(ia.Order, ib.Order), cmp.Compare(ia.Cost, ib.Cost), cmp.Compare(ia.Name, ib.Name))
+	})
+}
+
+// Run probes addr with every prober until one stops the chain. It returns
+// nil when none recognized a proxy or left a finding.
+func (s Set) Run(ctx context.Context, opts Options, addr netip.AddrPort) *Result {
+	res := &Result{AddrPort: addr}
+	for _, p := range s {
+		if ctx.Err() != nil {
+			break
+		}
+		if p.Probe(ctx, opts, res) == Stop {
+			break
+		}
+	}
+	if !res.Found() && len(res.Findings) == 0 {
+		return nil
+	}
+	return res
+}
@@ -0,0 +1,92 @@
+package prober
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fake struct {
+	info    Info
+	confirm string
+	usable  bool
+	verdict Verdict
+	calls   *[]string
+}
+
+func (f fake) Info() Info { return f.info }
+
+func (f fake) Probe(_ context.Context, _ Options, res *Result) Verdict {
+	*f.calls = append(*f.calls, f.info.Name)
+	if f.confirm != "" {
+		res.Confirm(f.confirm, f.usable)
+	}
+	return f.verdict
+}
+
+func TestRegistryOrder(t *testing.T) {
+	var calls []string
+	r := NewRegistry()
+	r.Register(fake{info: Info{Name: "c", Order: 10, Cost: 1}, calls: &calls})
+	r.Register(fake{info: Info{Name: "b", Order: 10, Cost: 1}, calls: &calls})
+	r.Register(fake{info: Info{Name: "a", Order: 10, Cost: 5}, calls: &calls})
+	r.Register(fake{info: Info{Name: "first", Order: 0, Cost: 9}, calls: &calls})
+	assert.Equal(t, []string{"first", "b", "c", "a"}, r.Names())
+
+	set, err := r.Select("a", "first", "a")
+	assert.NoError(t, err)
+	assert.Len(t, set, 2)
+	assert.Equal(t, "first", set[0].Info().Name)
+
+	_, err = r.Select("nope")
+	assert.ErrorIs(t, err, ErrProberNotFound)
+}
+
+func TestRun(t *testing.T) {
+	addr := netip.MustParseAddrPort("127.0.0.1:1080")
+	var calls []string
+	set := Set{
+		fake{info: Info{Name: "auth"}, confirm: "socks5", calls: &calls},
+		fake{info: Info{Name: "http"}, confirm: "http", usable: true, calls: &calls},
+		fake{info: Info{Name: "stop"}, verdict: Stop, calls: &calls},
+		fake{info: Info{Name: "never"}, confirm: "never", usable: true, calls: &calls},
+	}
+	res := set.Run(context.Background(), Options{}, addr)
+	assert.Equal(t, []string{"auth", "http", "stop"}, calls)
+	assert.Equal(t, &Result{
+		AddrPort:  addr,
+		Protocol:  "http",
+		Success:   true,
+		Protocols: []string{"socks5", "http"},
+	}, res)
+
+	calls = nil
+	assert.Nil(t, Set{fake{info: Info{Name: "none"}, calls: &calls}}.Run(context.Background(), Options{}, addr))
+}
+
+func TestConfirm(t *testing.T) {
+	var res Result
+	res.Confirm("socks5", true)
+	res.Confirm("http", true)
+	res.Confirm("socks5", true)
+	assert.Equal(t, "socks5", res.Protocol)
+	assert.Equal(t, []string{"socks5", "http"}, res.Protocols)
+	assert.True(t, res.Success)
+	assert.False(t, res.Auth)
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Result{Protocols: []string{"socks5", "http"}, UDP: true}
+	b := a
+	assert.Len(t, a.Fingerprint(), 16)
+	assert.Equal(t, a.Fingerprint(), b.Fingerprint())
+	b.UDPStats = &UDPStats{NAT: NATEndpointDependent}
+	assert.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+
+	// the order of findings and their confidence do not matter
+	c := Result{Findings: []Finding{{Kind: KindPanel, Label: "clash-api", Confidence: 1}, {Kind: KindInbound, Label: "trojan", Confidence: 0.5}}}
+	d := Result{Findings: []Finding{{Kind: KindInbound, Label: "trojan", Confidence: 0.6}, {Kind: KindPanel, Label: "clash-api"}}}
+	assert.Equal(t, c.Fingerprint(), d.Fingerprint())
+}
@@ -1,117 +1,256 @@
+// Package scan finds proxies: a tcp scanner backend probes the targets and
+// the probers of package prober identify every open port.
+//
+// To embed it, fill a Scanner (Default has the usual options) and call Run
+// with a context. Results stream through the On* hooks or Events, errors
+// are returned instead of exiting.
 package scan
 
 import (
+	"cmp"
 	"context"
+	"fmt"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
 	"github.com/dn-11/proxyScan/pool"
+	_ "github.com/dn-11/proxyScan/scan/httpproxy"
+	_ "github.com/dn-11/proxyScan/scan/inbound"
+	_ "github.com/dn-11/proxyScan/scan/panel"
+	"github.com/dn-11/proxyScan/scan/prober"
+	_ "github.com/dn-11/proxyScan/scan/socks4"
 	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
 	"github.com/dn-11/proxyScan/scan/tcpscanner"
 	_ "github.com/dn-11/proxyScan/scan/tcpscanner/system"
 	"github.com/dn-11/proxyScan/utils"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/netip"
 	"sync"
 	"time"
 )
 
+var (
+	genLog        = logging.New("generator")
+	tcpLog        = logging.New("tcpscanner")
+	verifyLog     = logging.New("socks5")
+	verifyFailLog = logging.Sampled(verifyLog, 20, time.Second)
+)
+
+// BackendError is returned when the tcp scanner backend can not start,
+// Err wraps tcpscanner.ErrScannerNotFound for an unknown one
+type BackendError struct {
+	Backend string
+	Err     error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("start tcp scanner %s: %v", e.Backend, e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Scanner holds the options of a scan, Default fills in the usual ones.
+// The hooks and Events are called from several goroutines.
 type Scanner struct {
 	ScannerType  string
 	TestUrl      string
 	TestCallback func(resp *http.Response) bool
 	TestTimeout  time.Duration
 	PortScanRate int
+	// Workers is the number of concurrent verifications, 128 if zero
+	Workers int
+
+	// Registry has the tcp scanner backends, tcpscanner.Default if nil
+	Registry *tcpscanner.Registry
+	// Probers verify every open port, every prober of prober.Default if empty
+	Probers prober.Set
+
+	// OnOpen is called for every open port before it is verified
+	OnOpen func(addr netip.AddrPort)
+	// OnFound is called for every result as soon as it is confirmed
+	OnFound func(res *socks5.Result)
+	// OnProgress is called every progressEvery generated ips and after the
+	// last one
+	OnProgress func(current, all int)
+	// Events receives the same events as the hooks when set, a slow reader
+	// slows the scan down
+	Events chan<- Event
 }
 
 func Default() *Scanner {
 	return &Scanner{
 		ScannerType:  "system",
-		TestUrl:      "http://www.gstatic.com/generate_204",
+		TestUrl:      socks5.TestURL,
 		TestTimeout:  time.Second * 15,
 		PortScanRate: 3000,
+		Workers:      128,
 	}
 }
 
-func ipGenerator(prefixs []netip.Prefix) func(func(addr netip.Addr)) {
-	return func(yield func(addr netip.Addr)) {
+// progressEvery is how many generated ips pass between progress updates, so
+// large sweeps don't report, and lock in the hooks, for every ip
+const progressEvery = 256
+
+func ipGenerator(ctx context.Context, groups []target.Group, progress func(current, all int)) func(func(addr netip.Addr, ports []int)) {
+	return func(yield func(addr netip.Addr, ports []int)) {
 		t := time.NewTicker(3 * time.Second)
+		defer t.Stop()
 		all := 0
 		current := 0
-		for _, prefix := range prefixs {
-			all += 1 << (32 - prefix.Bits())
+		for _, g := range groups {
+			all += int(target.Count(g.Prefixes))
 		}
-		for _, prefix := range prefixs {
-			count := 1 << (32 - prefix.Bits())
-			ip := prefix.Masked().Addr()
-			for i := 0; i < count; i++ {
-				yield(ip)
-				ip = ip.Next()
-				select {
-				case <-t.C:
-					log.Printf("IP Generator %d/%d(%f%%)\n", current, all, float64(current)/float64(all)*100)
-				default:
+		for _, g := range groups {
+			for _, prefix := range g.Prefixes {
+				count := 1 << (32 - prefix.Bits())
+				ip := prefix.Masked().Addr()
+				for i := 0; i < count; i++ {
+					if ctx.Err() != nil {
+						return
+					}
+					yield(ip, g.Ports)
+					ip = ip.Next()
+					select {
+					case <-t.C:
+						genLog.Info("progress", "current", current, "all", all, "percent", fmt.Sprintf("%.2f", float64(current)/float64(all)*100))
+					default:
+					}
+					current++
+					if current%progressEvery != 0 && current != all {
+						continue
+					}
+					metrics.Progress.Set(float64(current) / float64(all))
+					if progress != nil {
+						progress(current, all)
+					}
 				}
-				current++
 			}
 		}
 	}
 }
 
 func (s *Scanner) ScanSocks5(prefixs []netip.Prefix, port []int) []*socks5.Result {
-	c := utils.NewCollector[netip.AddrPort]()
+	return s.ScanTargets([]target.Group{{Prefixes: prefixs, Ports: port}})
+}
 
-	addrCount := 0
-	for _, prefix := range prefixs {
-		addrCount += 1 << (32 - prefix.Bits())
+// ScanTargets scans every group on its own ports.
+// Servers requiring authentication are returned with Success unset.
+// Errors are logged, use Run to get them.
+func (s *Scanner) ScanTargets(groups []target.Group) []*socks5.Result {
+	res, err := s.Run(context.Background(), groups)
+	if err != nil {
+		genLog.Error("scan failed", "err", err)
 	}
+	return res
+}
 
-	sc, err := tcpscanner.Get(s.ScannerType, context.Background(), s.PortScanRate)
+// Run scans every group on its own ports like ScanTargets. It stops early
+// once ctx is done and returns the results confirmed until then along with
+// ctx.Err(). A backend failing to start is a *BackendError.
+func (s *Scanner) Run(ctx context.Context, groups []target.Group) ([]*socks5.Result, error) {
+	registry := s.Registry
+	if registry == nil {
+		registry = tcpscanner.Default
+	}
+	probers := s.Probers
+	if len(probers) == 0 {
+		probers = prober.Default.All()
+	}
+	opts := prober.Options{TestURL: s.TestUrl}
+	if opts.TestURL == "" {
+		opts.TestURL = socks5.TestURL
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 128
+	}
+
+	sc, err := registry.Get(s.ScannerType, ctx, s.PortScanRate)
 	if err != nil {
-		log.Fatalf("get scanner failed: %v", err)
+		return nil, &BackendError{Backend: s.ScannerType, Err: err}
 	}
 
+	c := utils.NewCollector[netip.AddrPort]()
 	done := make(chan struct{})
 	go func() {
 		for addrPort := range sc.Alive() {
-			log.Println("[+]", addrPort.String())
+			tcpLog.Debug("open port", "addr", addrPort)
+			metrics.OpenPorts.Inc()
+			s.emit(ctx, Event{Kind: EventOpen, Addr: addrPort})
 			c.C <- addrPort
 		}
 		done <- struct{}{}
 	}()
 
-	ipGenerator(prefixs)(func(addr netip.Addr) {
-		for _, pt := range port {
+	ipGenerator(ctx, groups, func(current, all int) {
+		s.emit(ctx, Event{Kind: EventProgress, Current: current, All: all})
+	})(func(addr netip.Addr, ports []int) {
+		for _, pt := range ports {
 			sc.Send(netip.AddrPortFrom(addr, uint16(pt)))
+			metrics.ProbesSent.Inc()
 		}
 	})
-	log.Println("wait for tcp scan.")
+	genLog.Info("all probes sent, waiting for tcp scan")
 	sc.End()
 	<-done
 
-	log.Println("tcp scan done.")
 	aliveTCPAddrs := c.Return()
+	tcpLog.Info("tcp scan done", "open", len(aliveTCPAddrs))
 
-	log.Println("start socks5 scan with 128 threads.")
-	p := pool.Pool{Size: 128, Buffer: 128}
+	verifyLog.Info("start verification", "workers", workers)
+	p := pool.Pool{Size: workers, Buffer: workers}
 	p.Init()
 	defer p.Close()
 	res := utils.NewCollector[*socks5.Result]()
 	var wg sync.WaitGroup
 	wg.Add(len(aliveTCPAddrs))
+	metrics.VerifyQueue.Add(float64(len(aliveTCPAddrs)))
 	for _, addrPort := range aliveTCPAddrs {
 		p.Submit(func() {
 			defer wg.Done()
-			info := socks5.GetInfo(addrPort)
-			if info.Success {
-				res.C <- info
-				log.Printf("[+] socks5 %s", addrPort.String())
-			} else {
-				log.Printf("[-] not socks5 or too slow %s", addrPort.String())
+			defer metrics.VerifyQueue.Dec()
+			if ctx.Err() != nil {
+				return
+			}
+			info := probers.Run(ctx, opts, addrPort)
+			if info == nil {
+				verifyFailLog.Debug("not a proxy or too slow", "addr", addrPort)
+				return
 			}
+			res.C <- info
+			switch {
+			case info.Success:
+				verifyLog.Info("proxy confirmed", "addr", addrPort, "protocol", info.Protocol, "udp", info.UDP)
+			case info.Auth:
+				verifyLog.Info("proxy requires auth", "addr", addrPort, "protocol", info.Protocol)
+			}
+			logFindings(addrPort, info.Findings)
+			s.emit(ctx, Event{Kind: EventFound, Addr: addrPort, Result: info})
 		})
 	}
 
-	log.Println("wait for socks5 scan.")
+	verifyLog.Info("waiting for verification")
 	wg.Wait()
-	log.Println("socks5 scan done.")
-	return res.Return()
+	verifyLog.Info("verification done")
+	return res.Return(), ctx.Err()
+}
+
+var findingMessages = map[string]string{
+	prober.KindInbound: "likely encrypted proxy inbound",
+	prober.KindPanel:   "management panel found",
+}
+
+// logFindings logs every finding, high severity ones as warnings
+func logFindings(addr netip.AddrPort, findings []prober.Finding) {
+	for _, f := range findings {
+		level := slog.LevelInfo
+		if f.Severity == prober.SeverityHigh {
+			level = slog.LevelWarn
+		}
+		verifyLog.Log(context.Background(), level, cmp.Or(findingMessages[f.Kind], "finding"), "addr", addr,
+			"label", f.Label, "confidence", f.Confidence, "severity", f.Severity, "reasons", f.Reasons)
+	}
 }
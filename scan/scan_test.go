@@ -0,0 +1,147 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"github.com/dn-11/proxyScan/metrics"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/dn-11/proxyScan/scan/tcpscanner"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+func TestScanSocks5(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+
+	udp := n.NewProxy(simnet.Socks5UDP)
+	tcp := n.NewProxy(simnet.Socks5)
+	hp := n.NewProxy(simnet.HTTPProxy)
+	n.NewProxy(simnet.Broken)
+	auth := n.NewProxy(simnet.Behaviour{Socks5: true, Username: "u", Password: "p"})
+	n.RegisterScanner()
+
+	var ports []int
+	for _, p := range n.Proxies() {
+		ports = append(ports, int(p.AddrPort().Port()))
+	}
+
+	s := Default()
+	s.ScannerType = simnet.ScannerName
+	var (
+		mu       sync.Mutex
+		found    int
+		progress [2]int
+	)
+	s.OnFound = func(*socks5.Result) {
+		mu.Lock()
+		found++
+		mu.Unlock()
+	}
+	s.OnProgress = func(current, all int) { progress = [2]int{current, all} }
+	open := testutil.ToFloat64(metrics.OpenPorts)
+	res := s.ScanSocks5([]netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, ports)
+
+	type row struct {
+		protocol     string
+		success, udp bool
+	}
+	got := make(map[netip.AddrPort]row)
+	for _, r := range res {
+		got[r.AddrPort] = row{r.Protocol, r.Success, r.UDP}
+	}
+	assert.Equal(t, map[netip.AddrPort]row{
+		udp.AddrPort():  {"socks5", true, true},
+		tcp.AddrPort():  {"socks5", true, false},
+		hp.AddrPort():   {"http", true, false},
+		auth.AddrPort(): {"socks5", false, false},
+	}, got)
+	assert.Equal(t, 4, found)
+	assert.Equal(t, float64(5), testutil.ToFloat64(metrics.OpenPorts)-open)
+	assert.Zero(t, testutil.ToFloat64(metrics.VerifyQueue))
+	assert.Equal(t, [2]int{1, 1}, progress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err := s.Run(ctx, []target.Group{{Prefixes: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}, Ports: ports}})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, res)
+}
+
+func TestRunBackendError(t *testing.T) {
+	s := Default()
+	s.ScannerType = "nope"
+	s.Registry = tcpscanner.NewRegistry()
+	_, err := s.Run(context.Background(), nil)
+	var be *BackendError
+	assert.ErrorAs(t, err, &be)
+	assert.Equal(t, "nope", be.Backend)
+	assert.ErrorIs(t, err, tcpscanner.ErrScannerNotFound)
+
+	boom := errors.New("boom")
+	s.Registry.Register("nope", func(context.Context, int) (tcpscanner.Scanner, error) { return nil, boom })
+	_, err = s.Run(context.Background(), nil)
+	assert.ErrorIs(t, err, boom)
+}
+
+type fakeProber struct {
+	addr netip.AddrPort
+}
+
+func (fakeProber) Info() prober.Info { return prober.Info{Name: "fake", Protocols: []string{"fake"}} }
+
+func (p fakeProber) Probe(_ context.Context, _ prober.Options, res *prober.Result) prober.Verdict {
+	if res.AddrPort == p.addr {
+		res.Confirm("fake", true)
+	}
+	return prober.Continue
+}
+
+func TestRunProbersAndEvents(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	hp := n.NewProxy(simnet.HTTPProxy)
+	tcp := n.NewProxy(simnet.Socks5)
+	n.RegisterScanner()
+
+	s := Default()
+	s.ScannerType = simnet.ScannerName
+	s.Probers = prober.Set{fakeProber{addr: hp.AddrPort()}}
+	events := make(chan Event, 16)
+	s.Events = events
+	res, err := s.Run(context.Background(), []target.Group{{
+		Prefixes: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		Ports:    []int{int(hp.AddrPort().Port()), int(tcp.AddrPort().Port())},
+	}})
+	close(events)
+	assert.NoError(t, err)
+	assert.Len(t, res, 1)
+	assert.Equal(t, hp.AddrPort(), res[0].AddrPort)
+	assert.Equal(t, "fake", res[0].Protocol)
+
+	kinds := make(map[EventKind]int)
+	for e := range events {
+		kinds[e.Kind]++
+		if e.Kind == EventFound {
+			assert.Equal(t, hp.AddrPort(), e.Addr)
+		}
+	}
+	assert.Equal(t, map[EventKind]int{EventOpen: 2, EventFound: 1, EventProgress: 1}, kinds)
+}
+
+func TestProgressThrottled(t *testing.T) {
+	groups := []target.Group{{Prefixes: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/23"), netip.MustParsePrefix("198.51.100.0/30")}, Ports: []int{1080}}}
+	var progress [][2]int
+	ips := 0
+	ipGenerator(context.Background(), groups, func(current, all int) {
+		progress = append(progress, [2]int{current, all})
+	})(func(netip.Addr, []int) { ips++ })
+	assert.Equal(t, 516, ips)
+	assert.Equal(t, [][2]int{{256, 516}, {512, 516}, {516, 516}}, progress)
+}
@@ -0,0 +1,118 @@
+// Package socks4 detects SOCKS4 and SOCKS4a proxies
+package socks4
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+)
+
+func init() {
+	prober.Register(Prober{})
+}
+
+// reply codes of a CONNECT
+const (
+	granted  = 0x5a
+	rejected = 0x5b
+	// the server could not reach or did not accept the identd of the client
+	noIdentd  = 0x5c
+	badUserID = 0x5d
+)
+
+var errRejected = errors.New("socks4 request rejected")
+
+// Prober connects through the port with SOCKS4a and fetches the test url
+type Prober struct{}
+
+func (Prober) Info() prober.Info {
+	return prober.Info{Name: "socks4", Protocols: []string{"socks4"}, Order: 10, Cost: 2}
+}
+
+func (Prober) Probe(ctx context.Context, opts prober.Options, res *prober.Result) prober.Verdict {
+	auth, err := Check(ctx, res.AddrPort, opts.TestURL)
+	switch {
+	case err == nil:
+		res.Confirm("socks4", true)
+	case auth:
+		res.Confirm("socks4", false)
+	}
+	return prober.Continue
+}
+
+// Check fetches testURL through addr. auth is set when the server answered
+// in SOCKS4 but wants an identd or user id it could not verify.
+func Check(ctx context.Context, addr netip.AddrPort, testURL string) (auth bool, err error) {
+	u, err := url.Parse(testURL)
+	if err != nil {
+		return false, err
+	}
+	if u.Scheme != "http" {
+		return false, fmt.Errorf("socks4 test url must be http, got %s", u.Scheme)
+	}
+	port := 80
+	if p := u.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil {
+			return false, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, prober.Timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr.String())
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	// VN CD DSTPORT DSTIP USERID NUL, 0.0.0.1 asks the server to resolve the HOST NUL
+	req := []byte{0x04, 0x01, 0, 0, 0, 0, 0, 1, 0}
+	binary.BigEndian.PutUint16(req[2:4], uint16(port))
+	req = append(append(req, u.Hostname()...), 0)
+	if _, err := conn.Write(req); err != nil {
+		return false, err
+	}
+	var reply [8]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return false, err
+	}
+	if reply[0] != 0x00 {
+		return false, fmt.Errorf("not a socks4 reply: %#x", reply[0])
+	}
+	switch reply[1] {
+	case granted:
+	case noIdentd, badUserID:
+		return true, errRejected
+	case rejected:
+		return false, errRejected
+	default:
+		return false, fmt.Errorf("unknown socks4 reply code %#x", reply[1])
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, testURL, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := hreq.Write(conn); err != nil {
+		return false, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), hreq)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return false, nil
+}
@@ -0,0 +1,31 @@
+package socks4
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbe(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	opts := prober.Options{TestURL: "http://www.gstatic.com/generate_204"}
+	run := func(b simnet.Behaviour) *prober.Result {
+		return prober.Set{Prober{}}.Run(context.Background(), opts, n.NewProxy(b).AddrPort())
+	}
+
+	res := run(simnet.Socks4)
+	assert.Equal(t, "socks4", res.Protocol)
+	assert.True(t, res.Success)
+
+	res = run(simnet.Behaviour{Socks4: true, Username: "ident"})
+	assert.Equal(t, "socks4", res.Protocol)
+	assert.True(t, res.Auth)
+
+	assert.Nil(t, run(simnet.Socks5))
+	assert.Nil(t, run(simnet.HTTPProxy))
+	assert.Nil(t, run(simnet.Broken))
+}
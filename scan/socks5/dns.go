@@ -0,0 +1,69 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/dn-11/proxyScan/dnsleak"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/txthinking/socks5"
+)
+
+// LeakServer is the authoritative stand-in CheckDNS resolves through the
+// proxies, the test is skipped while it is nil
+var LeakServer *dnsleak.Server
+
+// CheckDNS connects through the socks5 proxy at addrPort to a fresh name of
+// LeakServer by domain, so the proxy has to resolve it itself, and records
+// which resolvers asked for it and where the proxy ended up. The result is
+// filled as far as the test got when an error is returned.
+func CheckDNS(ctx context.Context, addrPort netip.AddrPort) (*prober.DNSLeak, error) {
+	name := LeakServer.Name()
+	defer LeakServer.Forget(name)
+	res := &prober.DNSLeak{Name: name}
+
+	sc, err := socks5.NewClient(addrPort.String(), "", "", 15, 15)
+	if err != nil {
+		return res, err
+	}
+	c := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				// a domain is sent as ATYP 0x03
+				return sc.Dial(network, addr)
+			},
+			DisableKeepAlives: true,
+		},
+		Timeout: TestTimeout,
+	}
+	url := "http://" + net.JoinHostPort(strings.TrimSuffix(name, "."), strconv.Itoa(int(LeakServer.Target.Port()))) + "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return res, err
+	}
+	resp, err := c.Do(req)
+	res.Resolvers = LeakServer.Resolvers(name)
+	res.Remote = len(res.Resolvers) > 0
+	if err != nil {
+		// the upstream answered, yet the proxy could not reach
+		// LeakServer.Target: it was likely sent to a poisoned address
+		res.Tampered = res.Remote
+		res.Error = err.Error()
+		return res, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		res.Error = err.Error()
+		return res, err
+	}
+	res.Remote = true
+	label, _, _ := strings.Cut(name, ".")
+	res.Tampered = resp.StatusCode != http.StatusOK || string(body) != label
+	return res, nil
+}
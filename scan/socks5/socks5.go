@@ -3,29 +3,77 @@ package socks5
 import (
 	"context"
 	"errors"
-	"github.com/miekg/dns"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
+	"github.com/dn-11/proxyScan/scan/prober"
 	"github.com/txthinking/socks5"
-	"log"
+	"io"
 	"net"
 	"net/http"
 	"net/netip"
-	"strconv"
 	"time"
 )
 
 var (
-	TestURL         = "http://www.gstatic.com/generate_204"
-	TestTimeout     = time.Second * 5
-	TestUDPAddrPort = "1.1.1.1:53"
+	TestURL     = "http://www.gstatic.com/generate_204"
+	TestTimeout = time.Second * 5
 )
 
-type Result struct {
-	AddrPort netip.AddrPort
-	Success  bool
-	UDP      bool
+var (
+	logger = logging.New("socks5")
+	// failLog is for per address failures
+	failLog = logging.Sampled(logger, 20, time.Second)
+)
+
+// Result is the shared prober record, Auth is set when the server only
+// accepts username/password authentication
+type Result = prober.Result
+
+func init() {
+	prober.Register(Prober{})
+}
+
+// Prober runs Check on every open port. A port refusing the connection
+// stops the chain, a confirmed proxy lets other probers look for a mixed port.
+type Prober struct{}
+
+func (Prober) Info() prober.Info {
+	return prober.Info{Name: "socks5", Protocols: []string{"socks5"}, Order: 0, Cost: 3}
+}
+
+func (Prober) Probe(ctx context.Context, opts prober.Options, res *prober.Result) prober.Verdict {
+	url := opts.TestURL
+	if url == "" {
+		url = TestURL
+	}
+	r, reachable := check(ctx, res.AddrPort, url)
+	if !reachable {
+		return prober.Stop
+	}
+	if r.Found() {
+		res.Confirm("socks5", r.Success)
+		if res.Protocol == "socks5" {
+			res.UDP = r.UDP
+			res.UDPStats = r.UDPStats
+			res.DNSLeak = r.DNSLeak
+		}
+	}
+	return prober.Continue
 }
 
 func GetInfo(addrPort netip.AddrPort) *Result {
+	return Check(context.Background(), addrPort, TestURL)
+}
+
+// Check verifies addrPort by fetching testURL through it, it gives up once
+// ctx is done
+func Check(ctx context.Context, addrPort netip.AddrPort, testURL string) *Result {
+	res, _ := check(ctx, addrPort, testURL)
+	return res
+}
+
+// check is Check also reporting whether the port accepted a connection
+func check(ctx context.Context, addrPort netip.AddrPort, testURL string) (*Result, bool) {
 	res := &Result{
 		AddrPort: addrPort,
 		Success:  false,
@@ -33,8 +81,9 @@ func GetInfo(addrPort netip.AddrPort) *Result {
 	}
 	sc, err := socks5.NewClient(addrPort.String(), "", "", 15, 15)
 	if err != nil {
-		log.Printf("[-] new socks5 client failed (addr=%s): %v", addrPort, err)
-		return res
+		failLog.Debug("socks5 client failed", "addr", addrPort, "err", err)
+		metrics.Verified.WithLabelValues("error").Inc()
+		return res, true
 	}
 
 	c := http.Client{
@@ -46,54 +95,80 @@ func GetInfo(addrPort netip.AddrPort) *Result {
 		Timeout: TestTimeout,
 	}
 
-	resp, err := c.Get(TestURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, testURL, nil)
+	if err != nil {
+		metrics.Verified.WithLabelValues("error").Inc()
+		return res, true
+	}
+	resp, err := c.Do(req)
 	defer c.CloseIdleConnections()
 	if err != nil || resp == nil {
-		return res
+		auth, reachable := requiresAuth(ctx, addrPort)
+		if auth {
+			res.Confirm("socks5", false)
+		}
+		metrics.Verified.WithLabelValues(failureReason(auth, err)).Inc()
+		return res, reachable
 	}
-	res.Success = true
+	resp.Body.Close()
+	res.Confirm("socks5", true)
+	metrics.Verified.WithLabelValues("success").Inc()
 
-	if err := testUDPByDNS(sc); err != nil {
-		log.Printf("[-] test udp failed (addr=%s): %v", addrPort, err)
-		return res
+	if LeakServer != nil {
+		leak, err := CheckDNS(ctx, addrPort)
+		if err != nil {
+			failLog.Debug("remote dns failed", "addr", addrPort, "err", err)
+		}
+		if leak.Tampered {
+			logger.Warn("dns answer tampered", "addr", addrPort, "resolvers", leak.Resolvers)
+		}
+		res.DNSLeak = leak
 	}
 
-	res.UDP = true
-	return res
-}
-
-func testUDPByDNS(c *socks5.Client) error {
-	conn, err := c.Dial("udp", TestUDPAddrPort)
+	stats, err := CheckUDP(ctx, addrPort)
 	if err != nil {
-		return err
+		failLog.Debug("udp associate failed", "addr", addrPort, "err", err)
+		return res, true
 	}
-	conn.SetDeadline(time.Now().Add(TestTimeout))
 
-	msg := &dns.Msg{}
-	msg.SetQuestion(dns.Fqdn("example.com"), dns.TypeA)
-	data, err := msg.Pack()
-	n, err := conn.Write(data)
-	if err != nil {
-		return err
-	}
-	if n != len(data) {
-		return errors.New("write length error, expect: " + strconv.Itoa(len(data)) + ", actual: " + strconv.Itoa(n))
+	res.UDP = true
+	res.UDPStats = stats
+	metrics.UDPCapable.Inc()
+	return res, true
+}
+
+// failureReason labels a failed verification for metrics
+func failureReason(auth bool, err error) string {
+	var netErr net.Error
+	switch {
+	case auth:
+		return "auth_required"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case err != nil:
+		return "rejected"
 	}
+	return "error"
+}
 
-	var buf [1024]byte
-	recLen, err := conn.Read(buf[:])
+// requiresAuth offers no-auth and username/password and reports whether the
+// server picks the latter, and whether it accepted the connection at all
+func requiresAuth(ctx context.Context, addrPort netip.AddrPort) (auth, reachable bool) {
+	d := net.Dialer{Timeout: TestTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addrPort.String())
 	if err != nil {
-		return err
+		return false, false
 	}
-
-	recMsg := &dns.Msg{}
-	if err := recMsg.Unpack(buf[:recLen]); err != nil {
-		return err
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(TestTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if _, err := conn.Write([]byte{0x05, 0x02, 0x00, 0x02}); err != nil {
+		return false, true
 	}
-
-	if len(recMsg.Answer) == 0 {
-		return errors.New("no answer")
+	var buf [2]byte
+	if _, err := io.ReadFull(conn, buf[:]); err != nil {
+		return false, true
 	}
-
-	return nil
+	return buf[0] == 0x05 && buf[1] == 0x02, true
 }
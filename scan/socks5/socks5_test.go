@@ -1,25 +1,207 @@
 package socks5
 
 import (
-	"github.com/txthinking/socks5"
+	"context"
+	"github.com/dn-11/proxyScan/dnsleak"
+	"github.com/dn-11/proxyScan/echo"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http/httptest"
 	"net/netip"
 	"testing"
+	"time"
 )
 
 func TestGetInfo(t *testing.T) {
-	res := GetInfo(netip.MustParseAddrPort("172.16.4.6:18080"))
-	t.Log(*res)
+	n := simnet.New()
+	defer n.Close()
+
+	res := GetInfo(n.NewProxy(simnet.Socks5UDP).AddrPort())
+	assert.True(t, res.Success)
+	assert.True(t, res.UDP)
+
+	res = GetInfo(n.NewProxy(simnet.Socks5).AddrPort())
+	assert.True(t, res.Success)
+	assert.False(t, res.UDP)
+
+	res = GetInfo(n.NewProxy(simnet.HTTPProxy).AddrPort())
+	assert.False(t, res.Success)
+
+	res = GetInfo(n.NewProxy(simnet.Broken).AddrPort())
+	assert.False(t, res.Success)
+
+	res = GetInfo(n.NewProxy(simnet.Behaviour{Socks5: true, Username: "u", Password: "p"}).AddrPort())
+	assert.False(t, res.Success)
+	assert.True(t, res.Auth)
 }
 
-func TestRawDNS(t *testing.T) {
-	//ExampleServer()
-	c, err := socks5.NewClient("127.0.0.1:7890", "", "", 10, 10)
-	if err != nil {
-		t.Error(err)
+func TestGetInfoSlow(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+
+	old := TestTimeout
+	TestTimeout = 200 * time.Millisecond
+	defer func() { TestTimeout = old }()
+
+	res := GetInfo(n.NewProxy(simnet.Behaviour{Socks5: true, Delay: 300 * time.Millisecond}).AddrPort())
+	assert.False(t, res.Success)
+}
+
+func TestUDP(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+
+	stats, err := CheckUDP(context.Background(), n.NewProxy(simnet.Socks5UDP).AddrPort())
+	if !assert.NoError(t, err) {
 		return
 	}
-	err = testUDPByDNS(c)
+	assert.Equal(t, "dns:1.1.1.1:53", stats.Target)
+	assert.Equal(t, UDPPackets, stats.Received)
+	assert.Zero(t, stats.Loss)
+	assert.LessOrEqual(t, stats.RTTMin, stats.RTTAvg)
+	assert.LessOrEqual(t, stats.RTTAvg, stats.RTTMax)
+	assert.Empty(t, stats.NAT)
+
+	_, err = CheckUDP(context.Background(), n.NewProxy(simnet.Socks5).AddrPort())
+	assert.Error(t, err)
+}
+
+func TestUDPTargets(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+
+	ntp := listenUDP(t, func(pc net.PacketConn) {
+		buf := make([]byte, 512)
+		for {
+			l, from, err := pc.ReadFrom(buf)
+			if err != nil || l < 48 {
+				return
+			}
+			reply := make([]byte, 48)
+			reply[0] = 0x24
+			copy(reply[24:32], buf[40:48])
+			_, _ = pc.WriteTo(reply, from)
+		}
+	})
+	oldTargets, oldPackets, oldTimeout := UDPTargets, UDPPackets, UDPTimeout
+	defer func() { UDPTargets, UDPPackets, UDPTimeout = oldTargets, oldPackets, oldTimeout }()
+	// the dead dns target is skipped
+	UDPTargets = []UDPTarget{{Kind: UDPDNS, Addr: "127.0.0.1:1"}, {Kind: UDPNTP, Addr: ntp}}
+	UDPPackets, UDPTimeout = 4, 200*time.Millisecond
+
+	stats, err := CheckUDP(context.Background(), n.NewProxy(simnet.Behaviour{Socks5: true, UDP: true, UDPDrop: 2}).AddrPort())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "ntp:"+ntp, stats.Target)
+	assert.Equal(t, 4, stats.Sent)
+	assert.Equal(t, 2, stats.Received)
+	assert.Equal(t, 0.5, stats.Loss)
+}
+
+func TestUDPNAT(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+
+	old := NATEcho
+	defer func() { NATEcho = old }()
+	NATEcho = [2]string{listenUDP(t, serveEcho), listenUDP(t, serveEcho)}
+
+	stats, err := CheckUDP(context.Background(), n.NewProxy(simnet.Socks5UDP).AddrPort())
+	if assert.NoError(t, err) {
+		assert.Equal(t, prober.NATEndpointIndependent, stats.NAT)
+	}
+	stats, err = CheckUDP(context.Background(), n.NewProxy(simnet.Behaviour{Socks5: true, UDP: true, UDPDependent: true}).AddrPort())
+	if assert.NoError(t, err) {
+		assert.Equal(t, prober.NATEndpointDependent, stats.NAT)
+	}
+
+	res := GetInfo(n.NewProxy(simnet.Socks5UDP).AddrPort())
+	if assert.NotNil(t, res.UDPStats) {
+		assert.Equal(t, prober.NATEndpointIndependent, res.UDPStats.NAT)
+	}
+}
+
+func TestParseUDPTargets(t *testing.T) {
+	targets, err := ParseUDPTargets("dns:1.1.1.1:53, ntp:pool.ntp.org:123,echo:[::1]:3478")
+	assert.NoError(t, err)
+	assert.Equal(t, []UDPTarget{
+		{Kind: UDPDNS, Addr: "1.1.1.1:53"},
+		{Kind: UDPNTP, Addr: "pool.ntp.org:123"},
+		{Kind: UDPEcho, Addr: "[::1]:3478"},
+	}, targets)
+
+	_, err = ParseUDPTargets("stun:1.1.1.1:3478")
+	assert.Error(t, err)
+	_, err = ParseUDPTargets("dns:1.1.1.1")
+	assert.Error(t, err)
+}
+
+func TestCheckDNS(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+
+	web := httptest.NewServer(nil)
+	defer web.Close()
+	leak := dnsleak.NewServer("leak.test", netip.MustParseAddrPort(web.Listener.Addr().String()))
+	web.Config.Handler = leak
+	resolver := listenUDP(t, func(pc net.PacketConn) {
+		_ = (&dns.Server{PacketConn: pc, Handler: leak}).ActivateAndServe()
+	})
+	old := LeakServer
+	LeakServer = leak
+	defer func() { LeakServer = old }()
+
+	res, err := CheckDNS(context.Background(), n.NewProxy(simnet.Behaviour{Socks5: true, Resolver: resolver}).AddrPort())
+	if assert.NoError(t, err) {
+		assert.True(t, res.Remote)
+		assert.False(t, res.Tampered)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1")}, res.Resolvers)
+	}
+
+	// the hijacked resolver still asks upstream but sends the proxy elsewhere
+	n.Route("198.51.100.1", n.Web.Addr())
+	res, err = CheckDNS(context.Background(), n.NewProxy(simnet.Behaviour{Socks5: true, Resolver: resolver, Poison: "198.51.100.1"}).AddrPort())
+	if assert.NoError(t, err) {
+		assert.True(t, res.Remote)
+		assert.True(t, res.Tampered)
+		assert.Len(t, res.Resolvers, 1)
+	}
+
+	// a poisoned address that does not answer is tampering too
+	res, err = CheckDNS(context.Background(), n.NewProxy(simnet.Behaviour{Socks5: true, Resolver: resolver, Poison: "198.51.100.2"}).AddrPort())
+	assert.Error(t, err)
+	assert.True(t, res.Remote)
+	assert.True(t, res.Tampered)
+	assert.Len(t, res.Resolvers, 1)
+	assert.NotEmpty(t, res.Error)
+
+	// without a resolver the unique name is unknown to simnet
+	res, err = CheckDNS(context.Background(), n.NewProxy(simnet.Socks5).AddrPort())
+	assert.Error(t, err)
+	assert.False(t, res.Remote)
+	assert.Empty(t, res.Resolvers)
+
+	info := GetInfo(n.NewProxy(simnet.Behaviour{Socks5: true, Resolver: resolver}).AddrPort())
+	if assert.NotNil(t, info.DNSLeak) {
+		assert.True(t, info.DNSLeak.Remote)
+	}
+}
+
+func serveEcho(pc net.PacketConn) {
+	_ = echo.ServeUDP(pc)
+}
+
+// listenUDP serves a loopback udp port with serve until the test ends
+func listenUDP(t *testing.T, serve func(net.PacketConn)) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
+	t.Cleanup(func() { pc.Close() })
+	go serve(pc)
+	return pc.LocalAddr().String()
 }
@@ -0,0 +1,345 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dn-11/proxyScan/echo"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/miekg/dns"
+)
+
+// UDP test target kinds
+const (
+	UDPDNS  = "dns"
+	UDPNTP  = "ntp"
+	UDPEcho = "echo"
+)
+
+// UDPTarget is a server answering through the UDP relay of a proxy
+type UDPTarget struct {
+	Kind string `json:"kind" yaml:"kind"`
+	Addr string `json:"addr" yaml:"addr"`
+}
+
+func (t UDPTarget) String() string {
+	return t.Kind + ":" + t.Addr
+}
+
+var (
+	// UDPTargets are tried in order until one answers through the proxy
+	UDPTargets = []UDPTarget{{Kind: UDPDNS, Addr: "1.1.1.1:53"}}
+	// UDPPackets is the number of datagrams measuring RTT and loss
+	UDPPackets = 5
+	// UDPTimeout is how long a single datagram waits for its reply
+	UDPTimeout = 2 * time.Second
+	// NATEcho are two echo servers, see echo.ServeUDP, the mapping of the
+	// proxy is classified when both are set
+	NATEcho [2]string
+)
+
+var ErrNoUDPReply = errors.New("no udp reply")
+
+// ParseUDPTargets parses kind:host:port split by , eg dns:1.1.1.1:53
+func ParseUDPTargets(s string) ([]UDPTarget, error) {
+	var targets []UDPTarget
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, addr, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("udp target %q: want kind:host:port", item)
+		}
+		switch kind {
+		case UDPDNS, UDPNTP, UDPEcho:
+		default:
+			return nil, fmt.Errorf("udp target %q: unknown kind %q", item, kind)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("udp target %q: %w", item, err)
+		}
+		targets = append(targets, UDPTarget{Kind: kind, Addr: addr})
+	}
+	return targets, nil
+}
+
+// CheckUDP measures the UDP relay of the socks5 proxy at addrPort against
+// the first of UDPTargets answering, and classifies its NAT when NATEcho
+// is set
+func CheckUDP(ctx context.Context, addrPort netip.AddrPort) (*prober.UDPStats, error) {
+	s, err := associate(ctx, addrPort)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	var stats *prober.UDPStats
+	for _, t := range UDPTargets {
+		if stats = s.measure(ctx, t); stats != nil {
+			break
+		}
+	}
+	if stats == nil {
+		return nil, ErrNoUDPReply
+	}
+	if NATEcho[0] != "" && NATEcho[1] != "" {
+		stats.NAT = s.nat(NATEcho[0], NATEcho[1])
+	}
+	return stats, nil
+}
+
+// measure sends UDPPackets datagrams to t one after another, nil if none
+// was answered
+func (s *udpSession) measure(ctx context.Context, t UDPTarget) *prober.UDPStats {
+	stats := &prober.UDPStats{Target: t.String()}
+	var total time.Duration
+	for range max(UDPPackets, 1) {
+		if ctx.Err() != nil {
+			break
+		}
+		payload, valid := udpRequest(t.Kind)
+		stats.Sent++
+		_, rtt, err := s.exchange(t.Addr, payload, valid)
+		if err != nil {
+			failLog.Debug("udp datagram lost", "addr", s.proxy, "target", t, "err", err)
+			continue
+		}
+		stats.Received++
+		total += rtt
+		ms := float64(rtt) / float64(time.Millisecond)
+		if stats.Received == 1 || ms < stats.RTTMin {
+			stats.RTTMin = ms
+		}
+		stats.RTTMax = max(stats.RTTMax, ms)
+	}
+	if stats.Received == 0 {
+		return nil
+	}
+	stats.Loss = float64(stats.Sent-stats.Received) / float64(stats.Sent)
+	stats.RTTAvg = float64(total) / float64(stats.Received) / float64(time.Millisecond)
+	return stats
+}
+
+// nat compares the addresses two echo servers saw for the same client
+// socket. A proxy keeping one mapping for every destination is endpoint
+// independent, empty if an echo did not answer.
+func (s *udpSession) nat(a, b string) string {
+	var mapped [2]netip.AddrPort
+	for i, addr := range []string{a, b} {
+		payload, valid := udpRequest(UDPEcho)
+		reply, _, err := s.exchange(addr, payload, valid)
+		if err != nil {
+			failLog.Debug("nat echo failed", "addr", s.proxy, "echo", addr, "err", err)
+			return ""
+		}
+		mapped[i], _, _ = echo.ParseUDP(reply)
+	}
+	if mapped[0] == mapped[1] {
+		return prober.NATEndpointIndependent
+	}
+	return prober.NATEndpointDependent
+}
+
+// udpRequest builds a request of kind and the check of its reply
+func udpRequest(kind string) ([]byte, func([]byte) bool) {
+	switch kind {
+	case UDPNTP:
+		// client mode, version 4, the transmit timestamp comes back as
+		// the origin timestamp
+		req := make([]byte, 48)
+		req[0] = 0x23
+		_, _ = rand.Read(req[40:48])
+		return req, func(b []byte) bool {
+			return len(b) >= 48 && b[0]&0x07 == 4 && bytes.Equal(b[24:32], req[40:48])
+		}
+	case UDPEcho:
+		// the echo cuts the padding to make room for the address
+		req := make([]byte, 16, 16+echo.UDPPadding)
+		_, _ = rand.Read(req)
+		token := req
+		req = req[:cap(req)]
+		return req, func(b []byte) bool {
+			_, payload, err := echo.ParseUDP(b)
+			return err == nil && bytes.HasPrefix(payload, token)
+		}
+	}
+	msg := &dns.Msg{}
+	msg.SetQuestion(dns.Fqdn("example.com"), dns.TypeA)
+	req, _ := msg.Pack()
+	return req, func(b []byte) bool {
+		resp := &dns.Msg{}
+		return resp.Unpack(b) == nil && resp.Id == msg.Id && len(resp.Answer) > 0
+	}
+}
+
+// udpSession is a UDP ASSOCIATE, the relay lives as long as ctrl
+type udpSession struct {
+	proxy netip.AddrPort
+	ctrl  net.Conn
+	conn  *net.UDPConn
+	relay *net.UDPAddr
+}
+
+func associate(ctx context.Context, addrPort netip.AddrPort) (*udpSession, error) {
+	d := net.Dialer{Timeout: TestTimeout}
+	ctrl, err := d.DialContext(ctx, "tcp", addrPort.String())
+	if err != nil {
+		return nil, err
+	}
+	ctrl.SetDeadline(time.Now().Add(TestTimeout))
+	relay, err := udpAssociate(ctrl)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	if relay.Addr().IsUnspecified() {
+		relay = netip.AddrPortFrom(addrPort.Addr(), relay.Port())
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	ctrl.SetDeadline(time.Time{})
+	return &udpSession{proxy: addrPort, ctrl: ctrl, conn: conn, relay: net.UDPAddrFromAddrPort(relay)}, nil
+}
+
+// udpAssociate negotiates no authentication and returns the relay address
+func udpAssociate(c net.Conn) (netip.AddrPort, error) {
+	if _, err := c.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return netip.AddrPort{}, err
+	}
+	var method [2]byte
+	if _, err := io.ReadFull(c, method[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if method[0] != 0x05 || method[1] != 0x00 {
+		return netip.AddrPort{}, errors.New("no acceptable auth method")
+	}
+	if _, err := c.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
+		return netip.AddrPort{}, err
+	}
+	var head [3]byte
+	if _, err := io.ReadFull(c, head[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	if head[1] != 0x00 {
+		return netip.AddrPort{}, fmt.Errorf("udp associate rejected: %#x", head[1])
+	}
+	return readAddr(c)
+}
+
+// readAddr reads ATYP DST.ADDR DST.PORT, a domain is not resolved and
+// comes back unspecified
+func readAddr(r io.Reader) (netip.AddrPort, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	var addr netip.Addr
+	switch atyp[0] {
+	case 0x01:
+		var ip [4]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return netip.AddrPort{}, err
+		}
+		addr = netip.AddrFrom4(ip)
+	case 0x04:
+		var ip [16]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return netip.AddrPort{}, err
+		}
+		addr = netip.AddrFrom16(ip)
+	case 0x03:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return netip.AddrPort{}, err
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(l[0])); err != nil {
+			return netip.AddrPort{}, err
+		}
+		addr = netip.IPv4Unspecified()
+	default:
+		return netip.AddrPort{}, fmt.Errorf("unknown address type %#x", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(addr.Unmap(), binary.BigEndian.Uint16(port[:])), nil
+}
+
+// appendAddr appends ATYP DST.ADDR DST.PORT of host:port
+func appendAddr(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("port %q: %w", portStr, err)
+	}
+	if ip, err := netip.ParseAddr(host); err != nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("host %q too long", host)
+		}
+		b = append(append(b, 0x03, byte(len(host))), host...)
+	} else if ip = ip.Unmap(); ip.Is4() {
+		b = append(append(b, 0x01), ip.AsSlice()...)
+	} else {
+		b = append(append(b, 0x04), ip.AsSlice()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// exchange sends payload to dst through the relay and waits for the first
+// reply passing valid, replies to earlier lost requests are skipped
+func (s *udpSession) exchange(dst string, payload []byte, valid func([]byte) bool) ([]byte, time.Duration, error) {
+	pkt, err := appendAddr([]byte{0x00, 0x00, 0x00}, dst)
+	if err != nil {
+		return nil, 0, err
+	}
+	pkt = append(pkt, payload...)
+	start := time.Now()
+	s.conn.SetDeadline(start.Add(UDPTimeout))
+	if _, err := s.conn.WriteToUDP(pkt, s.relay); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			return nil, 0, err
+		}
+		rtt := time.Since(start)
+		// RSV RSV FRAG ATYP DST.ADDR DST.PORT DATA, fragments are dropped
+		if n < 4 || buf[2] != 0x00 {
+			continue
+		}
+		r := bytes.NewReader(buf[3:n])
+		if _, err := readAddr(r); err != nil {
+			continue
+		}
+		data := buf[n-r.Len() : n]
+		if valid(data) {
+			return append([]byte(nil), data...), rtt, nil
+		}
+	}
+}
+
+func (s *udpSession) Close() error {
+	s.conn.Close()
+	return s.ctrl.Close()
+}
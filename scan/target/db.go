@@ -0,0 +1,185 @@
+package target
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DB is a local prefix database used to expand ASNs and country codes
+type DB struct {
+	byASN     map[uint32][]netip.Prefix
+	byCountry map[string][]netip.Prefix
+}
+
+// LoadDB loads a prefix database. Two line formats are understood:
+//
+//	1.0.0.0	1.0.0.255	13335	US	CLOUDFLARENET   (iptoasn.com ip2asn-v4.tsv)
+//	1.0.0.0/24,13335,US                             (csv: prefix,asn,country)
+//
+// Files ending in .gz are decompressed. IPv6 entries are skipped.
+func LoadDB(name string) (*DB, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return ReadDB(r)
+}
+
+// ReadDB is LoadDB on a reader
+func ReadDB(r io.Reader) (*DB, error) {
+	db := &DB{
+		byASN:     make(map[uint32][]netip.Prefix),
+		byCountry: make(map[string][]netip.Prefix),
+	}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		prefixes, asn, country, err := parseDBLine(text)
+		if err != nil {
+			// tolerate a csv header
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("prefix db line %d: %v", line, err)
+		}
+		if prefixes == nil {
+			continue
+		}
+		if asn != 0 {
+			db.byASN[asn] = append(db.byASN[asn], prefixes...)
+		}
+		if country != "" && country != "NONE" {
+			db.byCountry[country] = append(db.byCountry[country], prefixes...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func parseDBLine(text string) (prefixes []netip.Prefix, asn uint32, country string, err error) {
+	var fields []string
+	if strings.Contains(text, "\t") {
+		fields = strings.Split(text, "\t")
+	} else {
+		fields = strings.Split(text, ",")
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	var asnField, countryField string
+	if len(fields) >= 4 && !strings.Contains(fields[0], "/") {
+		// range_start range_end asn country [description]
+		start, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, 0, "", err
+		}
+		end, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if !start.Is4() {
+			return nil, 0, "", nil
+		}
+		if prefixes, err = Range(start, end); err != nil {
+			return nil, 0, "", err
+		}
+		asnField, countryField = fields[2], fields[3]
+	} else if len(fields) >= 3 {
+		// prefix asn country
+		p, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if !p.Addr().Is4() {
+			return nil, 0, "", nil
+		}
+		prefixes = []netip.Prefix{p.Masked()}
+		asnField, countryField = fields[1], fields[2]
+	} else {
+		return nil, 0, "", fmt.Errorf("expect at least 3 fields, got %d", len(fields))
+	}
+
+	if asn, err = ParseASN(asnField); err != nil {
+		return nil, 0, "", err
+	}
+	return prefixes, asn, strings.ToUpper(countryField), nil
+}
+
+// ParseASN accepts 4538 as well as AS4538
+func ParseASN(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	v, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid asn %q", s)
+	}
+	return uint32(v), nil
+}
+
+// ASN returns every prefix originated by asn
+func (db *DB) ASN(asn uint32) []netip.Prefix {
+	return db.byASN[asn]
+}
+
+// Country returns every prefix registered to the ISO 3166 country code
+func (db *DB) Country(code string) []netip.Prefix {
+	return db.byCountry[strings.ToUpper(code)]
+}
+
+// Expand resolves comma separated ASN and country lists to prefixes.
+// It fails if any of them has no prefix in the database.
+func (db *DB) Expand(asns, countries string) ([]netip.Prefix, error) {
+	var res []netip.Prefix
+	for _, s := range strings.Split(asns, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		asn, err := ParseASN(s)
+		if err != nil {
+			return nil, err
+		}
+		p := db.ASN(asn)
+		if len(p) == 0 {
+			return nil, fmt.Errorf("AS%d not found in prefix db", asn)
+		}
+		res = append(res, p...)
+	}
+	for _, s := range strings.Split(countries, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		p := db.Country(s)
+		if len(p) == 0 {
+			return nil, fmt.Errorf("country %s not found in prefix db", s)
+		}
+		res = append(res, p...)
+	}
+	return res, nil
+}
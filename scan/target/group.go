@@ -0,0 +1,69 @@
+package target
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/dn-11/proxyScan/scan/ports"
+)
+
+// Group is a set of prefixes scanned on the same ports
+type Group struct {
+	Prefixes []netip.Prefix
+	Ports    []int
+}
+
+// Plan builds the scan groups from prefixes scanned on portSpec and the entries
+// of a targets file. Entries without ports join prefixes, entries with ports
+// override portSpec for their prefixes; when they overlap the later entry wins.
+// Every address ends up in exactly one group.
+func Plan(prefixes []netip.Prefix, portSpec string, entries []Entry) ([]Group, error) {
+	defaultPorts, err := ports.Parse(portSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	type override struct {
+		prefixes []netip.Prefix
+		ports    []int
+	}
+	var overrides []override
+	for _, e := range entries {
+		if e.Ports == "" {
+			prefixes = append(prefixes, e.Prefixes...)
+			continue
+		}
+		p, err := ports.Parse(e.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("ports of %v: %v", e.Prefixes, err)
+		}
+		overrides = append(overrides, override{prefixes: e.Prefixes, ports: p})
+	}
+
+	var groups []Group
+	var covered []netip.Prefix
+	for i := len(overrides) - 1; i >= 0; i-- {
+		p := Exclude(overrides[i].prefixes, covered)
+		covered = append(covered, overrides[i].prefixes...)
+		if len(p) > 0 {
+			groups = append(groups, Group{Prefixes: p, Ports: overrides[i].ports})
+		}
+	}
+	if p := Exclude(prefixes, covered); len(p) > 0 {
+		groups = append(groups, Group{Prefixes: p, Ports: defaultPorts})
+	}
+	// default group first, then overrides in file order
+	for i, j := 0, len(groups)-1; i < j; i, j = i+1, j-1 {
+		groups[i], groups[j] = groups[j], groups[i]
+	}
+	return groups, nil
+}
+
+// CountProbes returns the number of probes needed to scan groups
+func CountProbes(groups []Group) uint64 {
+	var n uint64
+	for _, g := range groups {
+		n += Count(g.Prefixes) * uint64(len(g.Ports))
+	}
+	return n
+}
@@ -0,0 +1,243 @@
+// Package target turns user input into the list of prefixes to scan.
+package target
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+var ErrNotIPv4 = errors.New("only ipv4 is supported")
+
+// Parse parses one target: a CIDR, a range like a.b.c.d-e.f.g.h,
+// a single IP or a hostname, which is resolved to its IPv4 addresses.
+func Parse(s string) ([]netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("empty target")
+	}
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		if !p.Addr().Is4() {
+			return nil, fmt.Errorf("%s: %w", s, ErrNotIPv4)
+		}
+		return []netip.Prefix{p.Masked()}, nil
+	}
+	if from, to, ok := strings.Cut(s, "-"); ok {
+		start, err1 := netip.ParseAddr(strings.TrimSpace(from))
+		end, err2 := netip.ParseAddr(strings.TrimSpace(to))
+		if err1 == nil && err2 == nil {
+			return Range(start, end)
+		}
+	}
+	if ip, err := netip.ParseAddr(s); err == nil {
+		if !ip.Is4() {
+			return nil, fmt.Errorf("%s: %w", s, ErrNotIPv4)
+		}
+		return []netip.Prefix{netip.PrefixFrom(ip, 32)}, nil
+	}
+
+	ips, err := net.LookupIP(s)
+	if err != nil {
+		return nil, err
+	}
+	var res []netip.Prefix
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			res = append(res, netip.PrefixFrom(netip.AddrFrom4([4]byte(ip4)), 32))
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%s: no ipv4 address", s)
+	}
+	return res, nil
+}
+
+// ParseList parses a comma separated list of targets
+func ParseList(s string) ([]netip.Prefix, error) {
+	var res []netip.Prefix
+	for _, t := range strings.Split(s, ",") {
+		if strings.TrimSpace(t) == "" {
+			continue
+		}
+		p, err := Parse(t)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p...)
+	}
+	return res, nil
+}
+
+// Entry is one line of a targets file
+type Entry struct {
+	Prefixes []netip.Prefix
+	// Ports is a port spec overriding the default ports for Prefixes, empty if not set
+	Ports string
+}
+
+// ReadFile reads one target per line, optionally followed by a port spec
+// separated by whitespace. Empty lines and # comments are ignored.
+//
+//	10.0.0.0/16
+//	10.1.0.0-10.1.255.255 clash,1080
+func ReadFile(name string) ([]Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read is ReadFile on a reader
+func Read(r io.Reader) ([]Entry, error) {
+	var res []Entry
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("line %d: expect target and optional ports, got %d fields", line, len(fields))
+		}
+		p, err := Parse(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		e := Entry{Prefixes: p}
+		if len(fields) == 2 {
+			e.Ports = fields[1]
+		}
+		res = append(res, e)
+	}
+	return res, sc.Err()
+}
+
+// Range returns the smallest list of prefixes covering start to end inclusive
+func Range(start, end netip.Addr) ([]netip.Prefix, error) {
+	if !start.Is4() || !end.Is4() {
+		return nil, ErrNotIPv4
+	}
+	if end.Less(start) {
+		return nil, fmt.Errorf("reversed range %s-%s", start, end)
+	}
+	return rangeToPrefixes(toUint32(start), toUint32(end)), nil
+}
+
+// Merge sorts prefixes, drops duplicates and merges overlapping or adjacent ones
+func Merge(prefixes []netip.Prefix) []netip.Prefix {
+	type span struct{ start, end uint64 }
+	spans := make([]span, 0, len(prefixes))
+	for _, p := range prefixes {
+		p = p.Masked()
+		start := uint64(toUint32(p.Addr()))
+		spans = append(spans, span{start, start + 1<<(32-p.Bits()) - 1})
+	}
+	slices.SortFunc(spans, func(a, b span) int {
+		switch {
+		case a.start < b.start:
+			return -1
+		case a.start > b.start:
+			return 1
+		}
+		return 0
+	})
+
+	var res []netip.Prefix
+	for i := 0; i < len(spans); {
+		cur := spans[i]
+		i++
+		for i < len(spans) && spans[i].start <= cur.end+1 {
+			cur.end = max(cur.end, spans[i].end)
+			i++
+		}
+		res = append(res, rangeToPrefixes(uint32(cur.start), uint32(cur.end))...)
+	}
+	return res
+}
+
+// Exclude returns the addresses of prefixes that are not covered by remove,
+// as a merged list of prefixes
+func Exclude(prefixes, remove []netip.Prefix) []netip.Prefix {
+	prefixes, remove = Merge(prefixes), Merge(remove)
+	var res []netip.Prefix
+	j := 0
+	for _, p := range prefixes {
+		start, end := uint64(toUint32(p.Addr())), uint64(toUint32(lastAddr(p)))
+		for j < len(remove) && uint64(toUint32(lastAddr(remove[j]))) < start {
+			j++
+		}
+		for k := j; k < len(remove) && start <= end; k++ {
+			rs, re := uint64(toUint32(remove[k].Addr())), uint64(toUint32(lastAddr(remove[k])))
+			if rs > end {
+				break
+			}
+			if rs > start {
+				res = append(res, rangeToPrefixes(uint32(start), uint32(rs-1))...)
+			}
+			start = re + 1
+		}
+		if start <= end {
+			res = append(res, rangeToPrefixes(uint32(start), uint32(end))...)
+		}
+	}
+	return res
+}
+
+func lastAddr(p netip.Prefix) netip.Addr {
+	return fromUint32(toUint32(p.Addr()) | uint32(uint64(1)<<(32-p.Bits())-1))
+}
+
+// Count returns the number of addresses covered by prefixes
+func Count(prefixes []netip.Prefix) uint64 {
+	var n uint64
+	for _, p := range prefixes {
+		n += 1 << (32 - p.Bits())
+	}
+	return n
+}
+
+func rangeToPrefixes(start, end uint32) []netip.Prefix {
+	var res []netip.Prefix
+	cur, last := uint64(start), uint64(end)
+	for cur <= last {
+		// largest block aligned at cur that fits in the range
+		bits := 32
+		for bits > 0 {
+			size := uint64(1) << (32 - bits + 1)
+			if cur%size != 0 || cur+size-1 > last {
+				break
+			}
+			bits--
+		}
+		res = append(res, netip.PrefixFrom(fromUint32(uint32(cur)), bits))
+		cur += 1 << (32 - bits)
+	}
+	return res
+}
+
+func toUint32(a netip.Addr) uint32 {
+	b := a.As4()
+	return binary.BigEndian.Uint32(b[:])
+}
+
+func fromUint32(v uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	return netip.AddrFrom4(b)
+}
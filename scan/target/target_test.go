@@ -0,0 +1,117 @@
+package target
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func prefixes(s ...string) []netip.Prefix {
+	var res []netip.Prefix
+	for _, v := range s {
+		res = append(res, netip.MustParsePrefix(v))
+	}
+	return res
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse("10.0.0.5/24")
+	assert.NoError(t, err)
+	assert.Equal(t, prefixes("10.0.0.0/24"), p)
+
+	p, err = Parse("10.0.0.1-10.0.0.6")
+	assert.NoError(t, err)
+	assert.Equal(t, prefixes("10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"), p)
+
+	p, err = Parse("192.168.1.1")
+	assert.NoError(t, err)
+	assert.Equal(t, prefixes("192.168.1.1/32"), p)
+
+	p, err = Parse("localhost")
+	assert.NoError(t, err)
+	assert.Contains(t, p, netip.MustParsePrefix("127.0.0.1/32"))
+
+	_, err = Parse("10.0.0.6-10.0.0.1")
+	assert.Error(t, err)
+	_, err = Parse("2001:db8::/32")
+	assert.ErrorIs(t, err, ErrNotIPv4)
+}
+
+func TestRead(t *testing.T) {
+	e, err := Read(strings.NewReader(`
+# campus
+10.0.0.0/16
+10.1.0.0-10.1.255.255 # dorm
+
+172.16.0.1	clash,!7893
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Prefixes: prefixes("10.0.0.0/16")},
+		{Prefixes: prefixes("10.1.0.0/16")},
+		{Prefixes: prefixes("172.16.0.1/32"), Ports: "clash,!7893"},
+	}, e)
+
+	_, err = Read(strings.NewReader("10.0.0.0/8\nnot-a-target.invalid\n"))
+	assert.ErrorContains(t, err, "line 2")
+	_, err = Read(strings.NewReader("10.0.0.0/8 7890 1080\n"))
+	assert.ErrorContains(t, err, "line 1")
+}
+
+func TestMerge(t *testing.T) {
+	assert.Equal(t,
+		prefixes("10.0.0.0/15", "10.3.0.0/16", "192.168.0.0/24"),
+		Merge(prefixes("192.168.0.0/24", "10.1.0.0/16", "10.0.0.0/16", "10.0.5.0/24", "10.3.0.0/16", "192.168.0.7/32", "10.1.0.0/16")))
+	assert.Equal(t, prefixes("0.0.0.0/0"), Merge(prefixes("0.0.0.0/1", "128.0.0.0/1", "1.2.3.4/32")))
+	assert.Equal(t, uint64(1<<17+1<<16+256), Count(Merge(prefixes("10.0.0.0/15", "10.3.0.0/16", "192.168.0.0/24", "10.0.0.0/24"))))
+}
+
+func TestExclude(t *testing.T) {
+	assert.Equal(t,
+		prefixes("10.0.0.0/17", "10.0.128.0/18", "10.0.192.0/21", "10.0.200.0/22", "10.0.204.0/24", "10.0.206.0/23", "10.0.208.0/20", "10.0.224.0/19"),
+		Exclude(prefixes("10.0.0.0/16"), prefixes("10.0.205.0/24")))
+	assert.Equal(t, prefixes("10.0.0.0/16"), Exclude(prefixes("10.0.0.0/16"), prefixes("192.168.0.0/16", "10.1.0.0/16")))
+	assert.Empty(t, Exclude(prefixes("10.0.1.0/24"), prefixes("10.0.0.0/16")))
+	assert.Equal(t, prefixes("10.0.0.0/24", "10.0.2.0/24"), Exclude(prefixes("10.0.0.0/22"), prefixes("10.0.1.0/24", "10.0.3.0/24")))
+}
+
+func TestDB(t *testing.T) {
+	db, err := ReadDB(strings.NewReader(`range_start	range_end	AS_number	country_code	AS_description
+1.0.0.0	1.0.0.255	13335	US	CLOUDFLARENET
+1.0.4.0	1.0.7.255	38803	AU	GTELECOM
+2001:200::	2001:200:ffff:ffff:ffff:ffff:ffff:ffff	2500	JP	WIDE
+59.64.0.0	59.79.255.255	4538	CN	ERX-CERNET-BKB
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, prefixes("59.64.0.0/12"), db.ASN(4538))
+	assert.Equal(t, prefixes("1.0.4.0/22"), db.Country("au"))
+
+	db, err = ReadDB(strings.NewReader("prefix,asn,country\n202.112.0.0/13,AS4538,CN\n"))
+	assert.NoError(t, err)
+	p, err := db.Expand("AS4538", "CN")
+	assert.NoError(t, err)
+	assert.Equal(t, prefixes("202.112.0.0/13", "202.112.0.0/13"), p)
+
+	_, err = db.Expand("4134", "")
+	assert.Error(t, err)
+}
+
+func TestPlan(t *testing.T) {
+	groups, err := Plan(prefixes("10.0.0.0/16"), "clash", []Entry{
+		{Prefixes: prefixes("192.168.0.0/24")},
+		{Prefixes: prefixes("10.0.0.0/24"), Ports: "1080"},
+		{Prefixes: prefixes("10.0.0.0/25"), Ports: "8080"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Group{
+		{Prefixes: prefixes("10.0.1.0/24", "10.0.2.0/23", "10.0.4.0/22", "10.0.8.0/21", "10.0.16.0/20", "10.0.32.0/19", "10.0.64.0/18", "10.0.128.0/17", "192.168.0.0/24"), Ports: []int{7890, 7891, 7892, 7893}},
+		{Prefixes: prefixes("10.0.0.128/25"), Ports: []int{1080}},
+		{Prefixes: prefixes("10.0.0.0/25"), Ports: []int{8080}},
+	}, groups)
+	assert.Equal(t, uint64((1<<16-256+256)*4+128+128), CountProbes(groups))
+
+	_, err = Plan(nil, "clash", []Entry{{Prefixes: prefixes("10.0.0.0/24"), Ports: "0"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,216 @@
+package pcap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dn-11/proxyScan/metrics"
+	"github.com/dn-11/proxyScan/scan/tcpscanner"
+	"github.com/dn-11/proxyScan/utils"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/yaklang/pcap"
+	"golang.org/x/time/rate"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	tcpscanner.Register("pcap-offline", NewOfflineScanner)
+}
+
+var (
+	// ReplayFile is a .pcap/.pcapng capture the offline scanner reads responses from
+	ReplayFile string
+	// CaptureFile receives the SYN probes instead of the wire, empty to discard them.
+	// A .pcapng suffix selects the pcapng format, everything else is written as pcap.
+	CaptureFile string
+	// OfflineSrcIP is the source address used in written probes
+	OfflineSrcIP = net.IPv4(192, 0, 2, 1)
+)
+
+type packetWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+}
+
+// OfflineScanner replays SYN-ACKs from ReplayFile instead of reading the wire.
+// Only responses to probes that were sent are reported, regardless of the
+// timestamps in the capture, so results are reproducible.
+type OfflineScanner struct {
+	ctx     context.Context
+	limiter *rate.Limiter
+	end     bool
+
+	replay  string
+	out     *os.File
+	writer  packetWriter
+	flush   func() error
+	writeMu sync.Mutex
+
+	sent   map[netip.AddrPort]struct{}
+	sentMu sync.Mutex
+
+	srcIP     net.IP
+	linkLayer *layers.Ethernet
+	alive     chan netip.AddrPort
+}
+
+var _ tcpscanner.Scanner = (*OfflineScanner)(nil)
+
+func NewOfflineScanner(ctx context.Context, r int) (tcpscanner.Scanner, error) {
+	if ReplayFile == "" {
+		return nil, errors.New("pcap-offline: no replay file")
+	}
+	if _, err := os.Stat(ReplayFile); err != nil {
+		return nil, fmt.Errorf("pcap-offline: %v", err)
+	}
+
+	s := &OfflineScanner{
+		ctx:     ctx,
+		limiter: utils.ParseLimiter(r),
+		replay:  ReplayFile,
+		sent:    make(map[netip.AddrPort]struct{}),
+		srcIP:   OfflineSrcIP,
+		linkLayer: &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		alive: make(chan netip.AddrPort, 1024),
+	}
+
+	if CaptureFile != "" {
+		if err := s.openCapture(CaptureFile); err != nil {
+			return nil, fmt.Errorf("pcap-offline: open capture: %v", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *OfflineScanner) openCapture(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(name), ".pcapng") {
+		w, err := pcapgo.NewNgWriter(f, layers.LinkTypeEthernet)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		s.writer, s.flush = w, w.Flush
+	} else {
+		w := pcapgo.NewWriter(f)
+		if err := w.WriteFileHeader(1600, layers.LinkTypeEthernet); err != nil {
+			f.Close()
+			return err
+		}
+		s.writer = w
+	}
+	s.out = f
+	return nil
+}
+
+func (s *OfflineScanner) Alive() chan netip.AddrPort {
+	return s.alive
+}
+
+func (s *OfflineScanner) Send(addr netip.AddrPort) {
+	if s.end {
+		logger.Error("Send called after End")
+		return
+	}
+
+	start := time.Now()
+	err := s.limiter.Wait(s.ctx)
+	metrics.LimiterWait.Add(time.Since(start).Seconds())
+	if err != nil {
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			logger.Warn("limiter wait", "err", err)
+		}
+		return
+	}
+
+	if s.writer != nil {
+		data, err := buildSYN(s.linkLayer, s.srcIP, addr)
+		if err != nil {
+			logger.Error("serialize layers", "err", err)
+			return
+		}
+		s.writeMu.Lock()
+		err = s.writer.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     time.Now(),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}, data)
+		s.writeMu.Unlock()
+		if err != nil {
+			failLog.Warn("write packet failed", "err", err)
+			return
+		}
+	}
+
+	s.sentMu.Lock()
+	s.sent[addr] = struct{}{}
+	s.sentMu.Unlock()
+}
+
+// End closes the capture file and replays the response file, then closes Alive.
+func (s *OfflineScanner) End() {
+	s.end = true
+	if s.out != nil {
+		if s.flush != nil {
+			if err := s.flush(); err != nil {
+				logger.Error("flush capture", "err", err)
+			}
+		}
+		if err := s.out.Close(); err != nil {
+			logger.Error("close capture", "err", err)
+		}
+	}
+
+	defer close(s.alive)
+	h, err := pcap.OpenOffline(s.replay)
+	if err != nil {
+		logger.Error("open replay file", "err", err)
+		return
+	}
+	defer h.Close()
+
+	seen := make(map[netip.AddrPort]struct{})
+	for {
+		if s.ctx.Err() != nil {
+			return
+		}
+		data, _, err := h.ReadPacketData()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, pcap.NextErrorNoMorePackets) {
+				return
+			}
+			failLog.Warn("read packet failed", "err", err)
+			return
+		}
+		addrPort, ok := parseSynAck(data, h.LinkType())
+		if !ok {
+			continue
+		}
+		metrics.SynAcks.Inc()
+		if _, ok := seen[addrPort]; ok {
+			continue
+		}
+		s.sentMu.Lock()
+		_, ok = s.sent[addrPort]
+		s.sentMu.Unlock()
+		if ok {
+			seen[addrPort] = struct{}{}
+			s.alive <- addrPort
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package pcap
+
+import (
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeReplay(t *testing.T, name string, replies map[netip.AddrPort]bool) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(1600, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	for addr, open := range replies {
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 2},
+			DstMAC:       net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		ip := &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolTCP,
+			SrcIP:    addr.Addr().AsSlice(),
+			DstIP:    OfflineSrcIP,
+		}
+		tcp := &layers.TCP{
+			SrcPort: layers.TCPPort(addr.Port()),
+			DstPort: 40000,
+			SYN:     open,
+			ACK:     true,
+			RST:     !open,
+			Window:  1024,
+		}
+		_ = tcp.SetNetworkLayerForChecksum(ip)
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, eth, ip, tcp); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		if err := w.WritePacket(gopacket.CaptureInfo{Timestamp: time.Now(), CaptureLength: len(data), Length: len(data)}, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOfflineScanner(t *testing.T) {
+	dir := t.TempDir()
+	open := netip.MustParseAddrPort("192.0.2.10:7890")
+	closed := netip.MustParseAddrPort("192.0.2.10:1080")
+	unsent := netip.MustParseAddrPort("192.0.2.11:7890")
+	oldReplay, oldCapture := ReplayFile, CaptureFile
+	t.Cleanup(func() { ReplayFile, CaptureFile = oldReplay, oldCapture })
+	ReplayFile = filepath.Join(dir, "replay.pcap")
+	CaptureFile = filepath.Join(dir, "probes.pcapng")
+	writeReplay(t, ReplayFile, map[netip.AddrPort]bool{open: true, closed: false, unsent: true})
+
+	sc, err := NewOfflineScanner(context.Background(), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var alive []netip.AddrPort
+	done := make(chan struct{})
+	go func() {
+		for addr := range sc.Alive() {
+			alive = append(alive, addr)
+		}
+		close(done)
+	}()
+	sc.Send(open)
+	sc.Send(closed)
+	sc.End()
+	<-done
+	assert.Equal(t, []netip.AddrPort{open}, alive)
+
+	f, err := os.Open(CaptureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for {
+		data, _, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		pk := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+		tcp, ok := pk.TransportLayer().(*layers.TCP)
+		assert.True(t, ok)
+		assert.True(t, tcp.SYN && !tcp.ACK)
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
 	"github.com/dn-11/proxyScan/scan/tcpscanner"
 	"github.com/dn-11/proxyScan/utils"
 	"github.com/google/gopacket"
@@ -13,7 +15,6 @@ import (
 	"github.com/yaklang/pcap"
 	"golang.org/x/time/rate"
 	"io"
-	"log"
 	"math/rand/v2"
 	"net"
 	"net/netip"
@@ -24,6 +25,12 @@ func init() {
 	tcpscanner.Register("pcap", NewScanner)
 }
 
+var (
+	logger = logging.New("tcpscanner")
+	// failLog is for errors repeating for every packet
+	failLog = logging.Sampled(logger, 10, time.Second)
+)
+
 type Scanner struct {
 	ctx        context.Context
 	cancelRead context.CancelFunc
@@ -87,19 +94,46 @@ func NewScanner(ctx context.Context, r int) (tcpscanner.Scanner, error) {
 		linkLayer:  linkLayer,
 	}
 	go scanner.recLoop(ctxRead)
+	go scanner.statsLoop(ctxRead)
 	return scanner, nil
 }
 
 func (t *Scanner) Send(addr netip.AddrPort) {
 	if t.end {
-		log.Println("calling Send after ended is not allowed.")
+		logger.Error("Send called after End")
+		return
+	}
+
+	data, err := buildSYN(t.linkLayer, t.srcIP, addr)
+	if err != nil {
+		logger.Error("serialize layers", "err", err)
+		return
+	}
+
+	start := time.Now()
+	err = t.limiter.Wait(t.ctx)
+	metrics.LimiterWait.Add(time.Since(start).Seconds())
+	if err != nil {
+		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			logger.Warn("limiter wait", "err", err)
+		}
+		return
+	}
+
+	if err := t.handle.WritePacketData(data); err != nil {
+		failLog.Warn("write packet failed", "err", err)
 		return
 	}
 
+	t.pending.Add(addr)
+}
+
+// buildSYN serializes a single SYN probe to addr, including the link layer
+func buildSYN(link *layers.Ethernet, srcIP net.IP, addr netip.AddrPort) ([]byte, error) {
 	linkLayer := &layers.Ethernet{
-		SrcMAC:       bytes.Clone(t.linkLayer.SrcMAC),
-		DstMAC:       bytes.Clone(t.linkLayer.DstMAC),
-		EthernetType: t.linkLayer.EthernetType,
+		SrcMAC:       bytes.Clone(link.SrcMAC),
+		DstMAC:       bytes.Clone(link.DstMAC),
+		EthernetType: link.EthernetType,
 	}
 
 	networkLayer := &layers.IPv4{
@@ -113,7 +147,7 @@ func (t *Scanner) Send(addr netip.AddrPort) {
 		TTL:        128,
 		Protocol:   layers.IPProtocolTCP,
 		Checksum:   0,
-		SrcIP:      t.srcIP,
+		SrcIP:      srcIP,
 		DstIP:      addr.Addr().AsSlice(),
 		Options:    nil,
 	}
@@ -130,29 +164,15 @@ func (t *Scanner) Send(addr netip.AddrPort) {
 	}
 
 	if err := transportLayer.SetNetworkLayerForChecksum(networkLayer); err != nil {
-		log.Println("set network layer for checksum error: ", err)
+		logger.Error("set network layer for checksum", "err", err)
 	}
 
 	buf := gopacket.NewSerializeBuffer()
 	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
 		linkLayer, networkLayer, transportLayer); err != nil {
-		log.Println("serialize layers error: ", err)
-		return
-	}
-
-	if err := t.limiter.Wait(t.ctx); err != nil {
-		if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
-			log.Printf("limiter: %v", err)
-		}
-		return
+		return nil, err
 	}
-
-	if err := t.handle.WritePacketData(buf.Bytes()); err != nil {
-		log.Printf("write packet fail: %v", err)
-		return
-	}
-
-	t.pending.Add(addr)
+	return buf.Bytes(), nil
 }
 
 func (t *Scanner) recLoop(ctx context.Context) {
@@ -167,34 +187,61 @@ func (t *Scanner) recLoop(ctx context.Context) {
 				if errors.Is(err, io.EOF) {
 					return
 				}
-				log.Printf("read packet error: %v", err)
+				failLog.Warn("read packet failed", "err", err)
 			}
-			// tcp syn-ack is short, skip big packet
-			if len(data) > 100 {
-				continue
-			}
-
-			pk := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
-			nwLayer, ok := pk.NetworkLayer().(*layers.IPv4)
-			if !ok {
-				continue
-			}
-			ip := nwLayer.SrcIP
-			tcpLayer, ok := pk.TransportLayer().(*layers.TCP)
-			if !ok {
-				continue
-			}
-			if !(tcpLayer.SYN && tcpLayer.ACK) {
-				continue
-			}
-			netipip, ok := netip.AddrFromSlice(ip.To4())
+			addrPort, ok := parseSynAck(data, layers.LayerTypeEthernet)
 			if !ok {
 				continue
 			}
-			addrPort := netip.AddrPortFrom(netipip, uint16(tcpLayer.SrcPort))
+			metrics.SynAcks.Inc()
 			if t.pending.Exist(addrPort) {
 				t.alive <- addrPort
 			}
 		}
 	}
 }
+
+// statsLoop exports pending probes and capture drops until ctx is done
+func (t *Scanner) statsLoop(ctx context.Context) {
+	tk := time.NewTicker(time.Second)
+	defer tk.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			metrics.Pending.Set(0)
+			return
+		case <-tk.C:
+		}
+		metrics.Pending.Set(float64(t.pending.Len()))
+		if stats, err := t.handle.Stats(); err == nil {
+			metrics.PcapDropped.Set(float64(stats.PacketsDropped + stats.PacketsIfDropped))
+		}
+	}
+}
+
+// parseSynAck decodes a captured frame and returns the remote endpoint
+// if it is a SYN-ACK, which means the probed port is open.
+func parseSynAck(data []byte, decoder gopacket.Decoder) (netip.AddrPort, bool) {
+	// tcp syn-ack is short, skip big packet
+	if len(data) > 100 {
+		return netip.AddrPort{}, false
+	}
+
+	pk := gopacket.NewPacket(data, decoder, gopacket.Default)
+	nwLayer, ok := pk.NetworkLayer().(*layers.IPv4)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	tcpLayer, ok := pk.TransportLayer().(*layers.TCP)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	if !(tcpLayer.SYN && tcpLayer.ACK) {
+		return netip.AddrPort{}, false
+	}
+	ip, ok := netip.AddrFromSlice(nwLayer.SrcIP.To4())
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ip, uint16(tcpLayer.SrcPort)), true
+}
@@ -3,22 +3,83 @@ package tcpscanner
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/netip"
+	"slices"
+	"sync"
 )
 
-var list = make(map[string]func(ctx context.Context, rate int) (Scanner, error))
+// Factory starts a backend sending at most rate probes per second
+type Factory func(ctx context.Context, rate int) (Scanner, error)
+
+// Registry maps backend names to their factories. Programs embedding the
+// scanner can build their own instead of using Default.
+type Registry struct {
+	mu   sync.RWMutex
+	list map[string]Factory
+}
+
+func NewRegistry() *Registry {
+	return &Registry{list: make(map[string]Factory)}
+}
+
+// Default has the backends registered by the imported packages
+var Default = NewRegistry()
 
 func Register(name string, f func(ctx context.Context, rate int) (Scanner, error)) {
-	list[name] = f
+	Default.Register(name, f)
 }
 
 var ErrScannerNotFound = errors.New("scanner not found")
 
 func Get(name string, ctx context.Context, rate int) (Scanner, error) {
-	if f, ok := list[name]; ok {
-		return f(ctx, rate)
+	return Default.Get(name, ctx, rate)
+}
+
+// Registered reports whether a backend called name exists
+func Registered(name string) bool {
+	return Default.Registered(name)
+}
+
+// Names returns the registered backends, sorted
+func Names() []string {
+	return Default.Names()
+}
+
+func (r *Registry) Register(name string, f Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.list[name] = f
+}
+
+// Get starts the backend called name, it fails with ErrScannerNotFound
+// when there is none
+func (r *Registry) Get(name string, ctx context.Context, rate int) (Scanner, error) {
+	r.mu.RLock()
+	f, ok := r.list[name]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrScannerNotFound, name)
+	}
+	return f(ctx, rate)
+}
+
+func (r *Registry) Registered(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.list[name]
+	return ok
+}
+
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.list))
+	for name := range r.list {
+		names = append(names, name)
 	}
-	return nil, ErrScannerNotFound
+	slices.Sort(names)
+	return names
 }
 
 type Scanner interface {
@@ -2,10 +2,11 @@ package system
 
 import (
 	"context"
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/metrics"
 	"github.com/dn-11/proxyScan/scan/tcpscanner"
 	"github.com/dn-11/proxyScan/utils"
 	"golang.org/x/time/rate"
-	"log"
 	"net"
 	"net/netip"
 	"sync"
@@ -18,6 +19,8 @@ func init() {
 
 const WaitTimeout = 2 * time.Second
 
+var logger = logging.New("tcpscanner")
+
 type Scanner struct {
 	alive chan netip.AddrPort
 	end   bool
@@ -35,9 +38,11 @@ func (c *Scanner) Send(addrPort netip.AddrPort) {
 	if c.end {
 		return
 	}
+	start := time.Now()
 	err := c.limiter.Wait(c.ctx)
+	metrics.LimiterWait.Add(time.Since(start).Seconds())
 	if err != nil {
-		log.Printf("limiter wait: %v", err)
+		logger.Warn("limiter wait", "err", err)
 		return
 	}
 	c.wg.Add(1)
@@ -0,0 +1,66 @@
+package simnet
+
+import (
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DNS is a UDP resolver answering every A question with a fixed address
+type DNS struct {
+	srv  *dns.Server
+	conn net.PacketConn
+
+	mu     sync.RWMutex
+	answer net.IP
+}
+
+func newDNS() *DNS {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic("simnet: listen dns: " + err.Error())
+	}
+	d := &DNS{conn: conn, answer: net.IPv4(192, 0, 2, 80)}
+	d.srv = &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(d.serve)}
+	started := make(chan struct{})
+	d.srv.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = d.srv.ActivateAndServe() }()
+	<-started
+	return d
+}
+
+// Addr is the listener address of the resolver
+func (d *DNS) Addr() string {
+	return d.conn.LocalAddr().String()
+}
+
+// SetAnswer changes the address returned for A questions
+func (d *DNS) SetAnswer(ip net.IP) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.answer = ip
+}
+
+func (d *DNS) Close() {
+	_ = d.srv.Shutdown()
+}
+
+func (d *DNS) serve(w dns.ResponseWriter, r *dns.Msg) {
+	d.mu.RLock()
+	answer := d.answer
+	d.mu.RUnlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	for _, q := range r.Question {
+		if q.Qtype != dns.TypeA {
+			continue
+		}
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   answer,
+		})
+	}
+	_ = w.WriteMsg(m)
+}
@@ -0,0 +1,112 @@
+// Package simnet is an in-memory "internet" for end-to-end tests.
+//
+// It runs on loopback only: proxy servers with scriptable behaviours, a fake
+// generate_204 endpoint, fake GeoIP and IP-check APIs, a DNS server and a
+// fake tcpscanner backend. Hostnames used by the proxies are resolved through
+// the routing table of Net, so nothing ever leaves the machine.
+package simnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnreachable = errors.New("simnet: host unreachable")
+
+// Net is a simulated internet. The zero value is not usable, use New.
+type Net struct {
+	Web *Web
+	DNS *DNS
+	// CA signs the certificate of the https web service
+	CA *CA
+
+	mu      sync.RWMutex
+	routes  map[string]string
+	proxies []*Proxy
+}
+
+// New starts the web and dns services of a simulated internet.
+// Well-known names used by the scanner are routed to them.
+func New() *Net {
+	n := &Net{routes: make(map[string]string), CA: NewCA("simnet")}
+	n.Web = newWeb(n.CA)
+	n.DNS = newDNS()
+	n.Route(WebHost, n.Web.Addr())
+	n.Route(WebHost+":443", n.Web.TLSAddr())
+	n.Route("www.gstatic.com", n.Web.Addr())
+	n.Route("1.1.1.1:53", n.DNS.Addr())
+	return n
+}
+
+// Route maps host or host:port to a local listener address.
+// A route without port matches every port of that host.
+func (n *Net) Route(host, addr string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.routes[strings.ToLower(host)] = addr
+}
+
+// Resolve returns the local address behind addr, or ErrUnreachable.
+// Loopback destinations are always reachable.
+func (n *Net) Resolve(addr string) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if to, ok := n.routes[strings.ToLower(addr)]; ok {
+		return to, nil
+	}
+	if to, ok := n.routes[strings.ToLower(host)]; ok {
+		return to, nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return addr, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnreachable, addr)
+}
+
+// DialContext dials addr after resolving it through the routing table
+func (n *Net) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	to, err := n.Resolve(addr)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, to)
+}
+
+// Proxies returns every proxy started on n
+func (n *Net) Proxies() []*Proxy {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]*Proxy(nil), n.proxies...)
+}
+
+// HTTPClient returns a client that reaches the simulated internet directly
+func (n *Net) HTTPClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{DialContext: n.DialContext},
+		Timeout:   5 * time.Second,
+	}
+}
+
+// Close stops every service and proxy of the simulated internet
+func (n *Net) Close() {
+	n.mu.Lock()
+	proxies := n.proxies
+	n.proxies = nil
+	n.mu.Unlock()
+	for _, p := range proxies {
+		p.Close()
+	}
+	n.Web.Close()
+	n.DNS.Close()
+}
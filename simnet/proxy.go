@@ -0,0 +1,674 @@
+package simnet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Behaviour scripts how a simulated proxy answers
+type Behaviour struct {
+	// Socks5, Socks4 and HTTP enable the protocols, several set means a
+	// mixed port like clash
+	Socks5 bool
+	Socks4 bool
+	HTTP   bool
+	// UDP allows socks5 UDP ASSOCIATE
+	UDP bool
+	// UDPDependent relays every destination from its own socket, like an
+	// endpoint-dependent NAT
+	UDPDependent bool
+	// UDPDrop drops every UDPDrop-th datagram of the client
+	UDPDrop int
+	// Resolver is a DNS server resolving the domains of socks5 CONNECTs
+	// missing from the routing table
+	Resolver string
+	// Poison replaces every address Resolver answers, like a hijacked
+	// resolver
+	Poison string
+	// MITM terminates the TLS of HTTP CONNECTs with a certificate of its
+	// own CA and forwards the requests inside
+	MITM bool
+	// Inject adds a header and a script to every response the HTTP proxy
+	// forwards itself, plain or intercepted
+	Inject bool
+	// ForwardHeaders are added to the requests the HTTP proxy forwards,
+	// X-Forwarded-For, X-Real-IP, X-Client-IP and Forwarded carry the
+	// client address
+	ForwardHeaders []string
+	// Username and Password require authentication when Username is set
+	Username string
+	Password string
+	// Delay is applied before every reply, to simulate slow proxies
+	Delay time.Duration
+	// Broken proxies accept the connection, write garbage and hang up
+	Broken bool
+
+	// The encrypted inbounds below never answer a client without the key.
+	// Silent reads everything, like a shadowsocks server.
+	Silent bool
+	// CloseAfter reads for the duration and hangs up, like vmess draining
+	// an unknown client
+	CloseAfter time.Duration
+	// TLS completes a handshake with a self-signed certificate and hangs
+	// up on whatever follows, like a trojan server without fallback
+	TLS bool
+}
+
+var (
+	Socks5    = Behaviour{Socks5: true}
+	Socks5UDP = Behaviour{Socks5: true, UDP: true}
+	HTTPProxy = Behaviour{HTTP: true}
+	Socks4    = Behaviour{Socks4: true}
+	Mixed     = Behaviour{Socks5: true, HTTP: true, UDP: true}
+	Broken    = Behaviour{Broken: true}
+
+	Shadowsocks = Behaviour{Silent: true}
+	VMess       = Behaviour{CloseAfter: 500 * time.Millisecond}
+	Trojan      = Behaviour{TLS: true}
+)
+
+// Proxy is a local proxy server dialing through the simulated internet
+type Proxy struct {
+	Behaviour
+
+	n  *Net
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewProxy starts a proxy server with behaviour b on a loopback port
+func (n *Net) NewProxy(b Behaviour) *Proxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("simnet: listen proxy: " + err.Error())
+	}
+	p := &Proxy{Behaviour: b, n: n, ln: ln, conns: make(map[net.Conn]struct{})}
+	n.mu.Lock()
+	n.proxies = append(n.proxies, p)
+	n.mu.Unlock()
+	p.wg.Add(1)
+	go p.serve()
+	return p
+}
+
+// Addr is the listener address of the proxy
+func (p *Proxy) Addr() string {
+	return p.ln.Addr().String()
+}
+
+// AddrPort is Addr as netip.AddrPort
+func (p *Proxy) AddrPort() netip.AddrPort {
+	return netip.MustParseAddrPort(p.Addr())
+}
+
+// Close stops the listener and every open connection
+func (p *Proxy) Close() {
+	_ = p.ln.Close()
+	p.mu.Lock()
+	for c := range p.conns {
+		_ = c.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+func (p *Proxy) serve() {
+	defer p.wg.Done()
+	for {
+		c, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.conns[c] = struct{}{}
+		p.mu.Unlock()
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer func() {
+				p.mu.Lock()
+				delete(p.conns, c)
+				p.mu.Unlock()
+				_ = c.Close()
+			}()
+			p.handle(c)
+		}()
+	}
+}
+
+func (p *Proxy) delay() {
+	if p.Delay > 0 {
+		time.Sleep(p.Delay)
+	}
+}
+
+func (p *Proxy) handle(c net.Conn) {
+	if p.Broken {
+		p.delay()
+		_, _ = c.Write([]byte("\x00garbage\r\n"))
+		return
+	}
+	switch {
+	case p.Silent:
+		_, _ = io.Copy(io.Discard, c)
+		return
+	case p.CloseAfter > 0:
+		_ = c.SetReadDeadline(time.Now().Add(p.CloseAfter))
+		_, _ = io.Copy(io.Discard, c)
+		return
+	case p.TLS:
+		tc := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{selfSigned()}})
+		var buf [512]byte
+		if _, err := tc.Read(buf[:]); err != nil {
+			return
+		}
+		return
+	}
+	br := bufio.NewReader(c)
+	first, err := br.Peek(1)
+	if err != nil {
+		return
+	}
+	conn := &bufConn{Conn: c, r: br}
+	switch {
+	case first[0] == 0x05 && p.Socks5:
+		p.handleSocks5(conn)
+	case first[0] == 0x04 && p.Socks4:
+		p.handleSocks4(conn)
+	case first[0] != 0x05 && first[0] != 0x04 && p.HTTP:
+		p.handleHTTP(conn, br)
+	}
+}
+
+type bufConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *bufConn) CloseWrite() error {
+	if tc, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return tc.CloseWrite()
+	}
+	return nil
+}
+
+func (p *Proxy) handleSocks5(c net.Conn) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return
+	}
+	want := byte(0x00)
+	if p.Username != "" {
+		want = 0x02
+	}
+	p.delay()
+	if bytes.IndexByte(methods, want) < 0 {
+		_, _ = c.Write([]byte{0x05, 0xff})
+		return
+	}
+	if _, err := c.Write([]byte{0x05, want}); err != nil {
+		return
+	}
+	if want == 0x02 && !p.socks5Auth(c) {
+		return
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil {
+		return
+	}
+	dst, err := readSocks5Addr(c, req[3])
+	if err != nil {
+		return
+	}
+	p.delay()
+	switch req[1] {
+	case 0x01:
+		remote, err := p.dial(dst)
+		if err != nil {
+			_, _ = c.Write(socks5Reply(0x04, nil))
+			return
+		}
+		defer remote.Close()
+		if _, err := c.Write(socks5Reply(0x00, remote.LocalAddr())); err != nil {
+			return
+		}
+		pipe(c, remote)
+	case 0x03:
+		if !p.UDP {
+			_, _ = c.Write(socks5Reply(0x07, nil))
+			return
+		}
+		p.udpAssociate(c)
+	default:
+		_, _ = c.Write(socks5Reply(0x07, nil))
+	}
+}
+
+// dial connects to dst, a domain unknown to the routing table is resolved
+// through Resolver when set
+func (p *Proxy) dial(dst string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(dst)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.n.Resolve(dst); err != nil && p.Resolver != "" && net.ParseIP(host) == nil {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(host), dns.TypeA)
+		r, err := dns.Exchange(m, p.Resolver)
+		if err != nil {
+			return nil, err
+		}
+		var ip string
+		for _, rr := range r.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				ip = a.A.String()
+				break
+			}
+		}
+		if ip == "" {
+			return nil, ErrUnreachable
+		}
+		if p.Poison != "" {
+			ip = p.Poison
+		}
+		dst = net.JoinHostPort(ip, port)
+	}
+	return p.n.DialContext(context.Background(), "tcp", dst)
+}
+
+// handleSocks4 serves a SOCKS4 or SOCKS4a CONNECT, a set Username must be
+// sent as the user id
+func (p *Proxy) handleSocks4(c net.Conn) {
+	br := bufio.NewReader(c)
+	var req [8]byte
+	if _, err := io.ReadFull(br, req[:]); err != nil {
+		return
+	}
+	user, err := br.ReadString(0)
+	if err != nil {
+		return
+	}
+	host := net.IP(req[4:8]).String()
+	if req[4] == 0 && req[5] == 0 && req[6] == 0 && req[7] != 0 {
+		if host, err = br.ReadString(0); err != nil {
+			return
+		}
+		host = strings.TrimSuffix(host, "\x00")
+	}
+	p.delay()
+	reply := []byte{0x00, 0x5a, 0, 0, 0, 0, 0, 0}
+	if req[1] != 0x01 {
+		reply[1] = 0x5b
+		_, _ = c.Write(reply)
+		return
+	}
+	if p.Username != "" && strings.TrimSuffix(user, "\x00") != p.Username {
+		reply[1] = 0x5d
+		_, _ = c.Write(reply)
+		return
+	}
+	dst := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(req[2:4]))))
+	remote, err := p.n.DialContext(context.Background(), "tcp", dst)
+	if err != nil {
+		reply[1] = 0x5b
+		_, _ = c.Write(reply)
+		return
+	}
+	defer remote.Close()
+	if _, err := c.Write(reply); err != nil {
+		return
+	}
+	pipe(&bufConn{Conn: c, r: br}, remote)
+}
+
+func (p *Proxy) socks5Auth(c net.Conn) bool {
+	var ver [2]byte
+	if _, err := io.ReadFull(c, ver[:]); err != nil {
+		return false
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return false
+	}
+	var plen [1]byte
+	if _, err := io.ReadFull(c, plen[:]); err != nil {
+		return false
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return false
+	}
+	if string(user) != p.Username || string(pass) != p.Password {
+		_, _ = c.Write([]byte{0x01, 0x01})
+		return false
+	}
+	_, err := c.Write([]byte{0x01, 0x00})
+	return err == nil
+}
+
+// udpAssociate relays datagrams until the control connection is closed
+func (p *Proxy) udpAssociate(c net.Conn) {
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		_, _ = c.Write(socks5Reply(0x01, nil))
+		return
+	}
+	defer relay.Close()
+	if _, err := c.Write(socks5Reply(0x00, relay.LocalAddr())); err != nil {
+		return
+	}
+
+	go func() {
+		_, _ = io.Copy(io.Discard, c)
+		_ = relay.Close()
+	}()
+
+	var (
+		client *net.UDPAddr
+		sent   int
+	)
+	// local address of a destination -> address the client asked for
+	virtual := make(map[string]string)
+	// local address of a destination -> its own socket when UDPDependent
+	outbound := make(map[string]*net.UDPConn)
+	defer func() {
+		for _, conn := range outbound {
+			conn.Close()
+		}
+	}()
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.String() == client.String() {
+			// from client: RSV RSV FRAG ATYP DST.ADDR DST.PORT DATA
+			client = from
+			if n < 4 || buf[2] != 0x00 {
+				continue
+			}
+			if sent++; p.UDPDrop > 0 && sent%p.UDPDrop == 0 {
+				continue
+			}
+			r := strings.NewReader(string(buf[4:n]))
+			dst, err := readSocks5Addr(r, buf[3])
+			if err != nil {
+				continue
+			}
+			to, err := p.n.Resolve(dst)
+			if err != nil {
+				continue
+			}
+			toAddr, err := net.ResolveUDPAddr("udp", to)
+			if err != nil {
+				continue
+			}
+			payload := buf[n-r.Len() : n]
+			virtual[toAddr.String()] = dst
+			p.delay()
+			if !p.UDPDependent {
+				_, _ = relay.WriteToUDP(payload, toAddr)
+				continue
+			}
+			conn, ok := outbound[toAddr.String()]
+			if !ok {
+				if conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); err != nil {
+					continue
+				}
+				outbound[toAddr.String()] = conn
+				go p.udpReturn(conn, relay, client, dst)
+			}
+			_, _ = conn.WriteToUDP(payload, toAddr)
+			continue
+		}
+		// from remote: wrap with header
+		dst, ok := virtual[from.String()]
+		if !ok {
+			continue
+		}
+		pkt := append([]byte{0x00, 0x00, 0x00}, socks5Addr(dst)...)
+		pkt = append(pkt, buf[:n]...)
+		_, _ = relay.WriteToUDP(pkt, client)
+	}
+}
+
+// udpReturn wraps the replies to the own socket of dst for the client
+func (p *Proxy) udpReturn(conn, relay *net.UDPConn, client *net.UDPAddr, dst string) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		pkt := append([]byte{0x00, 0x00, 0x00}, socks5Addr(dst)...)
+		pkt = append(pkt, buf[:n]...)
+		_, _ = relay.WriteToUDP(pkt, client)
+	}
+}
+
+func (p *Proxy) handleHTTP(c net.Conn, br *bufio.Reader) {
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		p.delay()
+		if p.Username != "" && !p.httpAuth(req) {
+			resp := &http.Response{
+				StatusCode: http.StatusProxyAuthRequired,
+				ProtoMajor: 1, ProtoMinor: 1,
+				Header: http.Header{"Proxy-Authenticate": {`Basic realm="simnet"`}},
+			}
+			_ = resp.Write(c)
+			return
+		}
+		if req.Method == http.MethodConnect && p.MITM {
+			p.intercept(c, req.Host)
+			return
+		}
+		if req.Method == http.MethodConnect {
+			remote, err := p.n.DialContext(req.Context(), "tcp", req.Host)
+			if err != nil {
+				_, _ = io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+				return
+			}
+			defer remote.Close()
+			if _, err := io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+				return
+			}
+			pipe(c, remote)
+			return
+		}
+		if !req.URL.IsAbs() {
+			_, _ = io.WriteString(c, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		if !p.forward(c, req) {
+			return
+		}
+	}
+}
+
+// intercept answers a CONNECT to host, terminates the TLS inside with a
+// forged certificate and forwards the requests
+func (p *Proxy) intercept(c net.Conn, host string) {
+	if _, err := io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	name, _, _ := net.SplitHostPort(host)
+	tc := tls.Server(c, &tls.Config{Certificates: []tls.Certificate{mitmCA().Issue(name)}})
+	defer tc.Close()
+	br := bufio.NewReader(tc)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		req.URL.Scheme, req.URL.Host = "https", host
+		if !p.forward(tc, req) {
+			return
+		}
+	}
+}
+
+// forward sends req upstream and writes the response to c, it reports
+// whether c can take another request
+func (p *Proxy) forward(c net.Conn, req *http.Request) bool {
+	req.RequestURI = ""
+	req.Header.Del("Proxy-Authorization")
+	req.Header.Del("Proxy-Connection")
+	client, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+	for _, name := range p.ForwardHeaders {
+		switch http.CanonicalHeaderKey(name) {
+		case "X-Forwarded-For", "X-Real-Ip", "X-Client-Ip":
+			req.Header.Set(name, client)
+		case "Forwarded":
+			req.Header.Set(name, "for="+client)
+		case "Via":
+			req.Header.Set(name, "1.1 simnet")
+		default:
+			req.Header.Set(name, "1")
+		}
+	}
+	t := &http.Transport{
+		DialContext: p.n.DialContext,
+		// the upstream is part of simnet
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	defer t.CloseIdleConnections()
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		_, _ = io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return false
+	}
+	defer resp.Body.Close()
+	if p.Inject {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return false
+		}
+		body = append(body, "<script src=\"//ads.simnet/ad.js\"></script>"...)
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+		resp.Header.Set("X-Simnet-Ad", "1")
+	}
+	return resp.Write(c) == nil
+}
+
+func (p *Proxy) httpAuth(req *http.Request) bool {
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return false
+	}
+	user, pass, _ := strings.Cut(string(raw), ":")
+	return user == p.Username && pass == p.Password
+}
+
+func readSocks5Addr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case 0x01:
+		var ip [4]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return "", err
+		}
+		host = netip.AddrFrom4(ip).String()
+	case 0x04:
+		var ip [16]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return "", err
+		}
+		host = netip.AddrFrom16(ip).String()
+	case 0x03:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errors.New("unknown address type")
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+func socks5Addr(addr string) []byte {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []byte{0x01, 0, 0, 0, 0, 0, 0}
+	}
+	port, _ := strconv.Atoi(portStr)
+	var b []byte
+	if ip, err := netip.ParseAddr(host); err != nil {
+		b = append([]byte{0x03, byte(len(host))}, host...)
+	} else if ip = ip.Unmap(); ip.Is4() {
+		b = append([]byte{0x01}, ip.AsSlice()...)
+	} else {
+		b = append([]byte{0x04}, ip.AsSlice()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+func socks5Reply(rep byte, bound net.Addr) []byte {
+	b := []byte{0x05, rep, 0x00}
+	if bound == nil {
+		return append(b, 0x01, 0, 0, 0, 0, 0, 0)
+	}
+	return append(b, socks5Addr(bound.String())...)
+}
+
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		if tc, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = tc.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+}
@@ -0,0 +1,84 @@
+package simnet
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+
+	"github.com/dn-11/proxyScan/scan/tcpscanner"
+)
+
+// ScannerName is the tcpscanner backend registered by RegisterScanner
+const ScannerName = "simnet"
+
+// Scanner is a fake tcpscanner.Scanner reporting a fixed set of open ports
+type Scanner struct {
+	alive chan netip.AddrPort
+
+	mu   sync.Mutex
+	open map[netip.AddrPort]struct{}
+	sent []netip.AddrPort
+	end  bool
+}
+
+var _ tcpscanner.Scanner = (*Scanner)(nil)
+
+// NewScanner returns a scanner where exactly open are reachable
+func NewScanner(open ...netip.AddrPort) *Scanner {
+	s := &Scanner{
+		alive: make(chan netip.AddrPort, 1024),
+		open:  make(map[netip.AddrPort]struct{}, len(open)),
+	}
+	for _, a := range open {
+		s.open[a] = struct{}{}
+	}
+	return s
+}
+
+// RegisterScanner makes the open ports of every proxy of n, plus extra,
+// reachable through the tcpscanner backend named ScannerName.
+// Proxies created after the call are picked up as well.
+func (n *Net) RegisterScanner(extra ...netip.AddrPort) {
+	tcpscanner.Register(ScannerName, func(ctx context.Context, rate int) (tcpscanner.Scanner, error) {
+		n.mu.RLock()
+		open := append([]netip.AddrPort(nil), extra...)
+		for _, p := range n.proxies {
+			open = append(open, p.AddrPort())
+		}
+		n.mu.RUnlock()
+		return NewScanner(open...), nil
+	})
+}
+
+func (s *Scanner) Alive() chan netip.AddrPort {
+	return s.alive
+}
+
+func (s *Scanner) Send(addr netip.AddrPort) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.end {
+		return
+	}
+	s.sent = append(s.sent, addr)
+	if _, ok := s.open[addr]; ok {
+		s.alive <- addr
+	}
+}
+
+func (s *Scanner) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.end {
+		return
+	}
+	s.end = true
+	close(s.alive)
+}
+
+// Sent returns every probe the scanner received, in order
+func (s *Scanner) Sent() []netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]netip.AddrPort(nil), s.sent...)
+}
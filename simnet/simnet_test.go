@@ -0,0 +1,40 @@
+package simnet
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	n := New()
+	defer n.Close()
+
+	addr, err := n.Resolve("www.gstatic.com:80")
+	assert.NoError(t, err)
+	assert.Equal(t, n.Web.Addr(), addr)
+
+	_, err = n.Resolve("example.com:80")
+	assert.True(t, errors.Is(err, ErrUnreachable))
+}
+
+func TestHTTPProxyAuth(t *testing.T) {
+	n := New()
+	defer n.Close()
+	p := n.NewProxy(Behaviour{HTTP: true, Username: "u", Password: "p"})
+
+	get := func(user *url.Userinfo) int {
+		c := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: p.Addr(), User: user})}}
+		resp, err := c.Get(n.Web.URL("/generate_204"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusProxyAuthRequired, get(nil))
+	assert.Equal(t, http.StatusNoContent, get(url.UserPassword("u", "p")))
+}
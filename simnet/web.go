@@ -0,0 +1,201 @@
+package simnet
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/dn-11/proxyScan/echo"
+)
+
+// WebHost is the hostname the web service is routed under
+const WebHost = "web.simnet"
+
+// Geo is what the fake GeoIP and IP-check APIs report
+type Geo struct {
+	IP      string
+	Country string
+	Region  string
+	City    string
+	Org     string
+	ASN     int
+}
+
+// StaticBody is served by /static, a resource with known content
+const StaticBody = "simnet static resource\n"
+
+// Web serves generate_204, fake GeoIP/IP-check APIs, a sized download and
+// an upload sink.
+// The same handlers are served over TLS with a certificate of the CA of Net.
+type Web struct {
+	srv    *httptest.Server
+	tlsSrv *httptest.Server
+
+	mu  sync.RWMutex
+	geo Geo
+}
+
+var DefaultGeo = Geo{
+	Country: "CN",
+	Region:  "Jiangsu",
+	City:    "Nanjing",
+	Org:     "CERNET",
+	ASN:     4538,
+}
+
+func newWeb(ca *CA) *Web {
+	w := &Web{geo: DefaultGeo}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate_204", func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/__down", w.download)
+	mux.HandleFunc("/__up", func(rw http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	})
+	mux.HandleFunc("/cdn-cgi/trace", w.trace)
+	mux.HandleFunc("/cf/geo", w.cloudflare)
+	mux.HandleFunc("/ipsb/geoip", w.ipsb)
+	mux.HandleFunc("/ipwho/", w.ipwho)
+	mux.HandleFunc("/ipinfo/json", w.ipinfo)
+	mux.HandleFunc("/headers", echo.Headers)
+	mux.HandleFunc("/static", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Header().Set("Etag", `"static"`)
+		_, _ = io.WriteString(rw, StaticBody)
+	})
+	w.srv = httptest.NewServer(mux)
+	w.tlsSrv = httptest.NewUnstartedServer(mux)
+	w.tlsSrv.TLS = &tls.Config{Certificates: []tls.Certificate{ca.Issue(WebHost)}}
+	w.tlsSrv.StartTLS()
+	return w
+}
+
+// TLSAddr is the listener address of the https service
+func (w *Web) TLSAddr() string {
+	return w.tlsSrv.Listener.Addr().String()
+}
+
+// TLSURL is URL over https
+func (w *Web) TLSURL(path string) string {
+	return "https://" + WebHost + path
+}
+
+// Addr is the listener address of the web service
+func (w *Web) Addr() string {
+	return w.srv.Listener.Addr().String()
+}
+
+// URL returns an url of path on the web service, using WebHost as host.
+// It only resolves through simnet proxies or Net.HTTPClient.
+func (w *Web) URL(path string) string {
+	return "http://" + WebHost + path
+}
+
+// SetGeo changes what the fake APIs report
+func (w *Web) SetGeo(g Geo) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.geo = g
+}
+
+func (w *Web) Close() {
+	w.srv.Close()
+	w.tlsSrv.Close()
+}
+
+func (w *Web) current(r *http.Request) Geo {
+	w.mu.RLock()
+	g := w.geo
+	w.mu.RUnlock()
+	if g.IP == "" {
+		g.IP, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	return g
+}
+
+func (w *Web) download(rw http.ResponseWriter, r *http.Request) {
+	n, err := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+	if err != nil || n < 0 {
+		http.Error(rw, "bad bytes", http.StatusBadRequest)
+		return
+	}
+	rw.Header().Set("Content-Length", strconv.FormatInt(n, 10))
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = io.CopyN(rw, zeroReader{}, n)
+}
+
+func (w *Web) trace(rw http.ResponseWriter, r *http.Request) {
+	g := w.current(r)
+	fmt.Fprintf(rw, "h=%s\nip=%s\nloc=%s\n", r.Host, g.IP, g.Country)
+}
+
+func (w *Web) cloudflare(rw http.ResponseWriter, r *http.Request) {
+	g := w.current(r)
+	writeJSON(rw, map[string]any{
+		"ip":             g.IP,
+		"city":           g.City,
+		"country":        g.Country,
+		"region":         g.Region,
+		"asOrganization": g.Org,
+		"asn":            g.ASN,
+	})
+}
+
+func (w *Web) ipsb(rw http.ResponseWriter, r *http.Request) {
+	g := w.current(r)
+	writeJSON(rw, map[string]any{
+		"ip":               g.IP,
+		"city":             g.City,
+		"country":          g.Country,
+		"region":           g.Region,
+		"organization":     g.Org,
+		"asn":              g.ASN,
+		"asn_organization": g.Org,
+	})
+}
+
+func (w *Web) ipwho(rw http.ResponseWriter, r *http.Request) {
+	g := w.current(r)
+	writeJSON(rw, map[string]any{
+		"ip":      g.IP,
+		"success": true,
+		"country": g.Country,
+		"region":  g.Region,
+		"city":    g.City,
+		"connection": map[string]any{
+			"asn": g.ASN,
+			"org": g.Org,
+		},
+	})
+}
+
+func (w *Web) ipinfo(rw http.ResponseWriter, r *http.Request) {
+	g := w.current(r)
+	writeJSON(rw, map[string]any{
+		"ip":      g.IP,
+		"country": g.Country,
+		"region":  g.Region,
+		"city":    g.City,
+		"org":     g.Org,
+		"asn":     fmt.Sprintf("AS%d", g.ASN),
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, v any) {
+	rw.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dn-11/proxyScan/convert"
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats are the output formats accepted by Write
+var Formats = []string{"text", "json", "clash"}
+
+// Write writes records as a text table, json or a clash proxy list.
+// The clash list only has the socks5 and http endpoints without
+// authentication, see convert.ClashSupports.
+func Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case "text", "":
+		return WriteText(w, records)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "clash", "yaml":
+		return WriteClash(w, records)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+func WriteText(w io.Writer, records []Record) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDR\tPROTOCOL\tUDP\tAUTH\tCOUNTRY\tOPEN\tFIRST SEEN\tLAST SEEN\tNOTIFIED\tCHECKS")
+	for _, r := range records {
+		country := "-"
+		if r.Geo != nil && r.Geo.Country != "" {
+			country = r.Geo.Country
+		}
+		protocol := r.Protocol
+		if protocol == "" && len(r.Findings) > 0 {
+			best := (&prober.Result{Findings: r.Findings}).Best()
+			protocol = fmt.Sprintf("%s? %.0f%%", best.Label, best.Confidence*100)
+		}
+		udp := strconv.FormatBool(r.UDP)
+		if r.UDP && r.UDPStats != nil {
+			udp = r.UDPStats.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\t%t\t%s\t%s\t%s\t%s\n",
+			r.AddrPort, protocol, udp, r.Auth, country, r.Open,
+			formatTime(r.FirstSeen), formatTime(r.LastSeen), formatTime(r.NotifiedAt), formatChecks(r.Test))
+	}
+	return tw.Flush()
+}
+
+func WriteClash(w io.Writer, records []Record) error {
+	proxies := make([]*convert.ClashSocks5Proxy, 0, len(records))
+	for _, r := range records {
+		if !convert.ClashSupports(r.Protocol) || r.Auth {
+			continue
+		}
+		res := &socks5.Result{AddrPort: r.AddrPort, Protocol: r.Protocol, Success: true, UDP: r.UDP, UDPStats: r.UDPStats}
+		proxies = append(proxies, convert.ToClashGeo(res, r.Geo))
+	}
+	data, err := yaml.Marshal(map[string][]*convert.ClashSocks5Proxy{"proxies": proxies})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// formatChecks counts the passed checks of the latest test, the failed
+// ones are named
+func formatChecks(test *proxy.ProxyResult) string {
+	if test == nil || len(test.Checks) == 0 {
+		return "-"
+	}
+	var failed []string
+	for _, c := range test.Checks {
+		if !c.Pass {
+			failed = append(failed, c.Name)
+		}
+	}
+	s := fmt.Sprintf("%d/%d", len(test.Checks)-len(failed), len(test.Checks))
+	if len(failed) > 0 {
+		s += " failed: " + strings.Join(failed, ",")
+	}
+	return s
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
@@ -0,0 +1,118 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Filter selects records, zero fields match everything
+type Filter struct {
+	// FirstSeenSince selects endpoints that appeared at or after the time
+	FirstSeenSince time.Time
+	// LastSeenSince selects endpoints seen open at or after the time
+	LastSeenSince time.Time
+	Protocol      string
+	Country       string
+	ASN           uint32
+	UDP           *bool
+	Open          *bool
+	// StillOpenAfterNotify selects notified endpoints that were seen open afterwards
+	StillOpenAfterNotify bool
+}
+
+// Match reports whether rec is selected by f
+func (f *Filter) Match(rec *Record) bool {
+	if !f.FirstSeenSince.IsZero() && rec.FirstSeen.Before(f.FirstSeenSince) {
+		return false
+	}
+	if !f.LastSeenSince.IsZero() && rec.LastSeen.Before(f.LastSeenSince) {
+		return false
+	}
+	if f.Protocol != "" && !strings.EqualFold(f.Protocol, rec.Protocol) {
+		return false
+	}
+	if f.Country != "" && (rec.Geo == nil || !strings.EqualFold(f.Country, rec.Geo.Country)) {
+		return false
+	}
+	if f.ASN != 0 && (rec.Geo == nil || f.ASN != rec.Geo.ASN) {
+		return false
+	}
+	if f.UDP != nil && *f.UDP != rec.UDP {
+		return false
+	}
+	if f.Open != nil && *f.Open != rec.Open {
+		return false
+	}
+	if f.StillOpenAfterNotify && (rec.NotifiedAt.IsZero() || !rec.Open || !rec.LastSeen.After(rec.NotifiedAt)) {
+		return false
+	}
+	return true
+}
+
+// Query returns the records selected by f, ordered by address
+func (s *Store) Query(f Filter) ([]Record, error) {
+	res := make([]Record, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketEndpoints).ForEach(func(k, v []byte) error {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if f.Match(&rec) {
+				res = append(res, rec)
+			}
+			return nil
+		})
+	})
+	slices.SortFunc(res, func(a, b Record) int {
+		return a.AddrPort.Compare(b.AddrPort)
+	})
+	return res, err
+}
+
+// PortHits counts the proxies ever found on every port, open or closed,
+// the order of ports.Rank
+func (s *Store) PortHits() (map[int]int, error) {
+	hits := make(map[int]int)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketEndpoints).ForEach(func(k, v []byte) error {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			// findings alone are no proxy
+			if rec.Protocol != "" {
+				hits[int(rec.AddrPort.Port())]++
+			}
+			return nil
+		})
+	})
+	return hits, err
+}
+
+// ParseSince accepts a duration before now (7d, 12h) or a date
+func ParseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.DateOnly, time.DateTime, time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
@@ -0,0 +1,325 @@
+// Package store is the persistent results database.
+//
+// Every endpoint ever confirmed is kept in a bbolt file together with the
+// first and last time it was seen, so later scans update the history instead
+// of replacing it.
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan/geoip"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketEndpoints = []byte("endpoints")
+	bucketScans     = []byte("scans")
+	bucketJobs      = []byte("jobs")
+)
+
+var ErrNotFound = errors.New("endpoint not found")
+
+// Record is everything known about one endpoint
+type Record struct {
+	AddrPort netip.AddrPort `json:"addr"`
+	Protocol string         `json:"protocol"`
+	// Protocols lists every protocol the endpoint speaks, eg a mixed port
+	Protocols []string `json:"protocols,omitempty"`
+	UDP       bool     `json:"udp"`
+	Auth      bool     `json:"auth"`
+	// Findings are the heuristic labels of a port no prober recognized
+	Findings []prober.Finding `json:"findings,omitempty"`
+	// UDPStats is the latest UDP relay test of a socks5 endpoint
+	UDPStats *prober.UDPStats `json:"udp_stats,omitempty"`
+	// DNSLeak is the latest remote dns test of a socks5 endpoint
+	DNSLeak *prober.DNSLeak `json:"dns_leak,omitempty"`
+	// Fingerprint is prober.Result.Fingerprint of the latest scan
+	Fingerprint string             `json:"fingerprint,omitempty"`
+	Geo         *geoip.GeoIP       `json:"geo,omitempty"`
+	Test        *proxy.ProxyResult `json:"test,omitempty"`
+	TestedAt    time.Time          `json:"tested_at"`
+
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// Open is false once a later scan covering the endpoint did not find it
+	Open       bool      `json:"open"`
+	ClosedAt   time.Time `json:"closed_at"`
+	NotifiedAt time.Time `json:"notified_at"`
+	SeenCount  int       `json:"seen_count"`
+}
+
+// ScanRun describes one finished scan
+type ScanRun struct {
+	ID     uint64    `json:"id"`
+	Job    string    `json:"job,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Probes uint64    `json:"probes"`
+	Found  int       `json:"found"`
+	New    int       `json:"new"`
+	Closed int       `json:"closed"`
+}
+
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database at path
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketEndpoints, bucketScans, bucketJobs} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Found is one endpoint confirmed by a scan
+type Found struct {
+	Result   *socks5.Result
+	Protocol string
+	Geo      *geoip.GeoIP
+}
+
+// RecordScan merges the results of a scan over groups that started at start,
+// job names the scheduled job and is empty for ad-hoc scans.
+// Endpoints inside groups that were open before but not found this time are
+// marked closed.
+func (s *Store) RecordScan(job string, start time.Time, groups []target.Group, found []Found) (*ScanRun, error) {
+	now := time.Now()
+	run := &ScanRun{Job: job, Start: start, End: now, Probes: target.CountProbes(groups), Found: len(found)}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketEndpoints)
+		seen := make(map[netip.AddrPort]struct{}, len(found))
+		for _, f := range found {
+			addr := f.Result.AddrPort
+			seen[addr] = struct{}{}
+			rec, err := get(b, addr)
+			if errors.Is(err, ErrNotFound) {
+				rec = &Record{AddrPort: addr, FirstSeen: now}
+				run.New++
+			} else if err != nil {
+				return err
+			}
+			rec.Protocol = f.Protocol
+			rec.Protocols = f.Result.Protocols
+			rec.UDP = f.Result.UDP
+			rec.Auth = f.Result.Auth
+			rec.Findings = f.Result.Findings
+			rec.UDPStats = f.Result.UDPStats
+			rec.DNSLeak = f.Result.DNSLeak
+			rec.Fingerprint = f.Result.Fingerprint()
+			if f.Geo != nil {
+				rec.Geo = f.Geo
+			}
+			rec.LastSeen = now
+			rec.Open = true
+			rec.ClosedAt = time.Time{}
+			rec.SeenCount++
+			if err := put(b, rec); err != nil {
+				return err
+			}
+		}
+
+		var closed []*Record
+		err := b.ForEach(func(k, v []byte) error {
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+			if _, ok := seen[rec.AddrPort]; ok || !rec.Open || !covers(groups, rec.AddrPort) {
+				return nil
+			}
+			rec.Open = false
+			rec.ClosedAt = now
+			closed = append(closed, &rec)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, rec := range closed {
+			if err := put(b, rec); err != nil {
+				return err
+			}
+		}
+		run.Closed = len(closed)
+
+		scans := tx.Bucket(bucketScans)
+		id, err := scans.NextSequence()
+		if err != nil {
+			return err
+		}
+		run.ID = id
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return scans.Put(binary.BigEndian.AppendUint64(nil, id), data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func covers(groups []target.Group, addr netip.AddrPort) bool {
+	for _, g := range groups {
+		if !slices.Contains(g.Ports, int(addr.Port())) {
+			continue
+		}
+		for _, p := range g.Prefixes {
+			if p.Contains(addr.Addr()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RecordTests stores ProxyTester results, keyed by ProxyResult.Proxy.
+// Results of unknown endpoints are ignored.
+func (s *Store) RecordTests(results []proxy.ProxyResult) error {
+	now := time.Now()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketEndpoints)
+		for i := range results {
+			addr, err := netip.ParseAddrPort(results[i].Proxy)
+			if err != nil {
+				continue
+			}
+			rec, err := get(b, addr)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			rec.Test = &results[i]
+			rec.TestedAt = now
+			if err := put(b, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MarkNotified records that the owners of addrs were notified at t
+func (s *Store) MarkNotified(addrs []netip.AddrPort, t time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketEndpoints)
+		for _, addr := range addrs {
+			rec, err := get(b, addr)
+			if err != nil {
+				return fmt.Errorf("%s: %w", addr, err)
+			}
+			rec.NotifiedAt = t
+			if err := put(b, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the record of addr or ErrNotFound
+func (s *Store) Get(addr netip.AddrPort) (*Record, error) {
+	var rec *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rec, err = get(tx.Bucket(bucketEndpoints), addr)
+		return err
+	})
+	return rec, err
+}
+
+// Scans returns every recorded scan, oldest first
+func (s *Store) Scans() ([]ScanRun, error) {
+	var res []ScanRun
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketScans).ForEach(func(k, v []byte) error {
+			var run ScanRun
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			res = append(res, run)
+			return nil
+		})
+	})
+	return res, err
+}
+
+// JobState is what the daemon remembers about a job between restarts
+type JobState struct {
+	LastSweep  time.Time `json:"last_sweep"`
+	LastVerify time.Time `json:"last_verify"`
+}
+
+// JobState returns the state of job, zero if it never ran
+func (s *Store) JobState(job string) (JobState, error) {
+	var st JobState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketJobs).Get([]byte(job))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &st)
+	})
+	return st, err
+}
+
+func (s *Store) SetJobState(job string, st JobState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketJobs).Put([]byte(job), data)
+	})
+}
+
+func get(b *bolt.Bucket, addr netip.AddrPort) (*Record, error) {
+	v := b.Get([]byte(addr.String()))
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	var rec Record
+	if err := json.Unmarshal(v, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func put(b *bolt.Bucket, rec *Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(rec.AddrPort.String()), data)
+}
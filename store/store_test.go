@@ -0,0 +1,122 @@
+package store
+
+import (
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan/geoip"
+	"github.com/dn-11/proxyScan/scan/prober"
+	"github.com/dn-11/proxyScan/scan/socks5"
+	"github.com/dn-11/proxyScan/scan/target"
+	"github.com/stretchr/testify/assert"
+)
+
+func found(addr string, udp bool) Found {
+	return Found{
+		Result:   &socks5.Result{AddrPort: netip.MustParseAddrPort(addr), Success: true, UDP: udp},
+		Protocol: "socks5",
+		Geo:      &geoip.GeoIP{Country: "CN", ASN: 4538},
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	groups := []target.Group{{Prefixes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}, Ports: []int{7890, 1080}}}
+	run, err := s.RecordScan("", time.Now(), groups, []Found{found("10.0.0.1:7890", true), found("10.0.0.2:1080", false)})
+	assert.NoError(t, err)
+	assert.Equal(t, &ScanRun{ID: 1, Start: run.Start, End: run.End, Probes: 512, Found: 2, New: 2}, run)
+
+	notified := time.Now()
+	assert.NoError(t, s.MarkNotified([]netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:7890"), netip.MustParseAddrPort("10.0.0.2:1080")}, notified))
+	assert.ErrorIs(t, s.MarkNotified([]netip.AddrPort{netip.MustParseAddrPort("10.0.0.9:1")}, notified), ErrNotFound)
+
+	// second scan: .1 is still open, .2 is gone, .3 is new
+	run, err = s.RecordScan("campus", time.Now(), groups, []Found{found("10.0.0.1:7890", false), found("10.0.0.3:7890", false)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, run.New)
+	assert.Equal(t, 1, run.Closed)
+
+	rec, err := s.Get(netip.MustParseAddrPort("10.0.0.1:7890"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rec.SeenCount)
+	assert.False(t, rec.UDP)
+	// the udp relay went away
+	assert.Equal(t, found("10.0.0.1:7890", false).Result.Fingerprint(), rec.Fingerprint)
+	assert.NotEqual(t, found("10.0.0.1:7890", true).Result.Fingerprint(), rec.Fingerprint)
+	assert.True(t, rec.FirstSeen.Before(rec.LastSeen))
+
+	rec, err = s.Get(netip.MustParseAddrPort("10.0.0.2:1080"))
+	assert.NoError(t, err)
+	assert.False(t, rec.Open)
+
+	assert.NoError(t, s.RecordTests([]proxy.ProxyResult{{Proxy: "10.0.0.3:7890", Status: "Available"}, {Proxy: "1.1.1.1:1", Status: "Available"}}))
+	rec, err = s.Get(netip.MustParseAddrPort("10.0.0.3:7890"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Available", rec.Test.Status)
+
+	addrs := func(f Filter) []string {
+		recs, err := s.Query(f)
+		assert.NoError(t, err)
+		var res []string
+		for _, r := range recs {
+			res = append(res, r.AddrPort.String())
+		}
+		return res
+	}
+	yes := true
+	assert.Equal(t, []string{"10.0.0.1:7890", "10.0.0.2:1080", "10.0.0.3:7890"}, addrs(Filter{Country: "cn"}))
+	assert.Equal(t, []string{"10.0.0.1:7890", "10.0.0.2:1080", "10.0.0.3:7890"}, addrs(Filter{ASN: 4538}))
+	assert.Empty(t, addrs(Filter{ASN: 4134}))
+	assert.Equal(t, []string{"10.0.0.3:7890"}, addrs(Filter{FirstSeenSince: notified}))
+	assert.Equal(t, []string{"10.0.0.1:7890"}, addrs(Filter{StillOpenAfterNotify: true}))
+	assert.Equal(t, []string{"10.0.0.1:7890", "10.0.0.3:7890"}, addrs(Filter{Open: &yes}))
+
+	// closed endpoints were hits too
+	hits, err := s.PortHits()
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]int{7890: 2, 1080: 1}, hits)
+
+	scans, err := s.Scans()
+	assert.NoError(t, err)
+	if assert.Len(t, scans, 2) {
+		assert.Equal(t, "campus", scans[1].Job)
+	}
+
+	st, err := s.JobState("campus")
+	assert.NoError(t, err)
+	assert.True(t, st.LastSweep.IsZero())
+	st.LastSweep = notified.Round(0)
+	assert.NoError(t, s.SetJobState("campus", st))
+	got, err := s.JobState("campus")
+	assert.NoError(t, err)
+	assert.True(t, got.LastSweep.Equal(st.LastSweep))
+}
+
+func TestWriteTextFindings(t *testing.T) {
+	var b strings.Builder
+	assert.NoError(t, WriteText(&b, []Record{{
+		AddrPort: netip.MustParseAddrPort("10.0.0.1:8388"),
+		Findings: []prober.Finding{{Label: "vmess", Confidence: 0.3}, {Label: "shadowsocks", Confidence: 0.6}},
+	}}))
+	assert.Contains(t, b.String(), "shadowsocks? 60%")
+}
+
+func TestWriteTextUDPStats(t *testing.T) {
+	var b strings.Builder
+	assert.NoError(t, WriteText(&b, []Record{{
+		AddrPort: netip.MustParseAddrPort("10.0.0.1:1080"),
+		Protocol: "socks5",
+		UDP:      true,
+		UDPStats: &prober.UDPStats{Sent: 5, Received: 4, Loss: 0.2, RTTAvg: 31.6, NAT: prober.NATEndpointDependent},
+	}}))
+	assert.Contains(t, b.String(), "32ms 20% loss EDM")
+}
@@ -1,21 +1,23 @@
 package utils
 
 import (
+	"github.com/dn-11/proxyScan/logging"
 	"golang.org/x/time/rate"
-	"log"
 	"math"
 	"time"
 )
 
+var logger = logging.New("limiter")
+
 func ParseLimiter(r int) *rate.Limiter {
 	var interval rate.Limit
 	var b int
 	if r <= 0 {
-		log.Printf("rate unlimited mode")
+		logger.Info("rate unlimited")
 		interval = rate.Inf
 		b = math.MaxInt
 	} else {
-		log.Printf("rate %d/s", r)
+		logger.Info("rate limited", "pps", r)
 		interval = rate.Every(time.Second / time.Duration(r))
 		b = max(r/200, 1)
 	}
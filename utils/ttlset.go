@@ -7,7 +7,8 @@ import (
 )
 
 // TTLSet a set that can set ttl
-// Attention: this is not a thread-safe set
+// Attention: this is not a thread-safe set, only Len may be called
+// concurrently with the others
 type TTLSet[K comparable] struct {
 	old map[K]time.Time
 	m   map[K]time.Time
@@ -45,15 +46,21 @@ func (s *TTLSet[K]) Add(k K) {
 func (s *TTLSet[K]) Exist(k K) bool {
 	s.checkRotate(true)
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	entry, ok := s.old[k]
 	if ok && entry.After(time.Now()) {
 		return true
 	}
+	entry, ok = s.m[k]
+	return ok && entry.After(time.Now())
+}
 
+// Len counts the keys added during the last two ttl periods, expired ones included
+func (s *TTLSet[K]) Len() int {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	entry, ok = s.m[k]
-	return ok && entry.After(time.Now())
+	return len(s.m) + len(s.old)
 }
 
 // Wait all key to be expired
@@ -74,8 +81,10 @@ func (s *TTLSet[K]) checkRotate(rotate bool) {
 	select {
 	case <-s.t.C:
 		if rotate {
+			s.lock.Lock()
 			s.old = s.m
 			s.m = make(map[K]time.Time)
+			s.lock.Unlock()
 		}
 	default:
 	}
@@ -46,3 +46,24 @@ func TestTTLSet2(t *testing.T) {
 	set.Wait()
 	t.Log(time.Since(begin))
 }
+
+func TestTTLSetLen(t *testing.T) {
+	set := NewTTLSet[int](10 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			set.Add(i)
+			time.Sleep(time.Millisecond / 2)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			assert.Equal(t, 100, set.Len())
+			return
+		default:
+			set.Len()
+		}
+	}
+}
@@ -0,0 +1,358 @@
+// Package watch re-probes a set of proxies on an interval and keeps the
+// history of their availability, latency and egress address.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dn-11/proxyScan/logging"
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/scan/socks5"
+)
+
+var logger = logging.New("watch")
+
+// Event kinds
+const (
+	Down          = "down"
+	Up            = "up"
+	EgressChanged = "egress_changed"
+	// UptimeLow and LatencyHigh are raised when a threshold is crossed,
+	// UptimeOK and LatencyOK when it is crossed back
+	UptimeLow   = "uptime_low"
+	UptimeOK    = "uptime_ok"
+	LatencyHigh = "latency_high"
+	LatencyOK   = "latency_ok"
+)
+
+// Event is a change in the state of a proxy
+type Event struct {
+	Time   time.Time      `json:"time"`
+	Addr   netip.AddrPort `json:"addr"`
+	Kind   string         `json:"kind"`
+	Detail string         `json:"detail,omitempty"`
+}
+
+func (e Event) String() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s %s", e.Addr, e.Kind)
+	}
+	return fmt.Sprintf("%s %s: %s", e.Addr, e.Kind, e.Detail)
+}
+
+// Observation is the outcome of one probe
+type Observation struct {
+	Up bool
+	// Latency is in milliseconds, 0 when unknown
+	Latency float64
+	// Egress is the address the proxy exits from, empty when unknown
+	Egress string
+	Err    string
+}
+
+// Transition is a change between up and down
+type Transition struct {
+	Time time.Time `json:"time"`
+	Up   bool      `json:"up"`
+}
+
+// Sample is a latency in milliseconds
+type Sample struct {
+	Time    time.Time `json:"time"`
+	Latency float64   `json:"latency_ms"`
+}
+
+// Status is the history of one proxy
+type Status struct {
+	Addr  netip.AddrPort `json:"addr"`
+	Up    bool           `json:"up"`
+	Since time.Time      `json:"since"`
+	// Uptime is the percentage of Checks the proxy was up
+	Uptime   float64 `json:"uptime"`
+	Checks   int     `json:"checks"`
+	UpChecks int     `json:"up_checks"`
+	// Failures counts the failed checks in a row
+	Failures      int          `json:"failures"`
+	Transitions   []Transition `json:"transitions,omitempty"`
+	Latency       []Sample     `json:"latency,omitempty"`
+	Egress        string       `json:"egress,omitempty"`
+	EgressChanges int          `json:"egress_changes"`
+	LastCheck     time.Time    `json:"last_check"`
+	LastError     string       `json:"last_error,omitempty"`
+	// UptimeLow and LatencyHigh are the threshold states, events are only
+	// raised when they change
+	UptimeLow   bool `json:"uptime_low"`
+	LatencyHigh bool `json:"latency_high"`
+}
+
+// MedianLatency of the kept samples, 0 without any
+func (s *Status) MedianLatency() float64 {
+	if len(s.Latency) == 0 {
+		return 0
+	}
+	ms := make([]float64, len(s.Latency))
+	for i, l := range s.Latency {
+		ms[i] = l.Latency
+	}
+	slices.Sort(ms)
+	return ms[len(ms)/2]
+}
+
+// Thresholds raise events, zero fields are disabled
+type Thresholds struct {
+	// DownAfter failed checks in a row take an up proxy down, 1 when 0
+	DownAfter int
+	// MinUptime is a percentage, checked once MinChecks were made
+	MinUptime float64
+	MinChecks int
+	// MaxLatency is compared with the median latency in milliseconds
+	MaxLatency float64
+}
+
+// Watcher probes its proxies every Interval plus up to Jitter
+type Watcher struct {
+	Interval   time.Duration
+	Jitter     time.Duration
+	Thresholds Thresholds
+	// History is the number of latency samples and transitions kept
+	History     int
+	Concurrency int
+	// Probe checks one proxy, the default is socks5.Check followed by
+	// ProxyTester for the latency and egress address
+	Probe func(ctx context.Context, addr netip.AddrPort) Observation
+	// OnEvent is called for every event
+	OnEvent func(Event)
+	// OnRound is called with every status after each round
+	OnRound func([]Status)
+
+	mu     sync.Mutex
+	status map[netip.AddrPort]*Status
+}
+
+// New watches addrs, the history of prev is continued
+func New(addrs []netip.AddrPort, prev []Status) *Watcher {
+	w := &Watcher{
+		Interval:    5 * time.Minute,
+		History:     100,
+		Concurrency: 10,
+		Probe:       Probe,
+		status:      make(map[netip.AddrPort]*Status, len(addrs)),
+	}
+	for _, a := range addrs {
+		w.status[a] = &Status{Addr: a}
+	}
+	for _, s := range prev {
+		if _, ok := w.status[s.Addr]; ok {
+			w.status[s.Addr] = &s
+		}
+	}
+	return w
+}
+
+// Probe is the default probe of socks5 proxies. The tester skips its speed
+// test, its first latency url gives the latency and the ip check apis the
+// egress address.
+func Probe(ctx context.Context, addr netip.AddrPort) Observation {
+	res := socks5.Check(ctx, addr, socks5.TestURL)
+	if !res.Success {
+		if res.Auth {
+			return Observation{Err: "authentication required"}
+		}
+		return Observation{Err: "check failed"}
+	}
+	obs := Observation{Up: true}
+	tester := proxy.NewProxyTester(ctx, nil)
+	tester.SkipSpeed = true
+	r := tester.TestProxy(proxy.ProxyURL(proxy.ProtocolSocks5, addr.String()))
+	if len(r.Timings) > 0 && r.Timings[0].Failed < r.Timings[0].Samples {
+		obs.Latency = r.Timings[0].Total.Median
+	}
+	obs.Egress = egress(r.IPInfo)
+	return obs
+}
+
+// egress is the ip the apis agree on, or else the one most of them saw
+func egress(info proxy.IPInfoResult) string {
+	if ip, ok := info.Same["ip"]; ok && len(ip.Sources) > 0 {
+		return ip.Value
+	}
+	var best proxy.FieldValue
+	for _, v := range info.Different["ip"] {
+		if len(v.Sources) > len(best.Sources) || len(v.Sources) == len(best.Sources) && v.Value < best.Value {
+			best = v
+		}
+	}
+	return best.Value
+}
+
+// Run probes every proxy right away and then on the interval, until ctx
+// is done or after rounds rounds when rounds is positive
+func (w *Watcher) Run(ctx context.Context, rounds int) {
+	for i := 0; rounds <= 0 || i < rounds; i++ {
+		if i > 0 {
+			wait := w.Interval
+			if w.Jitter > 0 {
+				wait += rand.N(w.Jitter)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		w.Round(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Round probes every proxy once
+func (w *Watcher) Round(ctx context.Context) {
+	sem := make(chan struct{}, max(w.Concurrency, 1))
+	var wg sync.WaitGroup
+	for _, addr := range w.addrs() {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			obs := w.Probe(ctx, addr)
+			if ctx.Err() != nil {
+				return
+			}
+			for _, e := range w.observe(addr, obs, time.Now()) {
+				logger.Info("proxy "+e.Kind, "addr", e.Addr, "detail", e.Detail)
+				if w.OnEvent != nil {
+					w.OnEvent(e)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	statuses := w.Statuses()
+	up := 0
+	for _, s := range statuses {
+		if s.Up {
+			up++
+		}
+	}
+	logger.Info("round done", "up", up, "proxies", len(statuses))
+	if w.OnRound != nil {
+		w.OnRound(statuses)
+	}
+}
+
+// Statuses returns a copy of every status, ordered by address
+func (w *Watcher) Statuses() []Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	res := make([]Status, 0, len(w.status))
+	for _, s := range w.status {
+		c := *s
+		c.Transitions = slices.Clone(s.Transitions)
+		c.Latency = slices.Clone(s.Latency)
+		res = append(res, c)
+	}
+	slices.SortFunc(res, func(a, b Status) int { return a.Addr.Compare(b.Addr) })
+	return res
+}
+
+func (w *Watcher) addrs() []netip.AddrPort {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	addrs := make([]netip.AddrPort, 0, len(w.status))
+	for a := range w.status {
+		addrs = append(addrs, a)
+	}
+	slices.SortFunc(addrs, netip.AddrPort.Compare)
+	return addrs
+}
+
+// observe records obs and returns the events it raised
+func (w *Watcher) observe(addr netip.AddrPort, obs Observation, now time.Time) []Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.status[addr]
+	var events []Event
+	event := func(kind, detail string) {
+		events = append(events, Event{Time: now, Addr: addr, Kind: kind, Detail: detail})
+	}
+
+	first := s.Checks == 0
+	s.Checks++
+	s.LastCheck = now
+	s.LastError = obs.Err
+	if obs.Up {
+		s.UpChecks++
+		s.Failures = 0
+	} else {
+		s.Failures++
+	}
+	s.Uptime = 100 * float64(s.UpChecks) / float64(s.Checks)
+
+	switch {
+	case first:
+		s.Up = obs.Up
+		s.Since = now
+		s.Transitions = keep(append(s.Transitions, Transition{Time: now, Up: obs.Up}), w.History)
+	case obs.Up && !s.Up:
+		event(Up, fmt.Sprintf("down for %s", now.Sub(s.Since).Round(time.Second)))
+		s.Up = true
+		s.Since = now
+		s.Transitions = keep(append(s.Transitions, Transition{Time: now, Up: true}), w.History)
+	case !obs.Up && s.Up && s.Failures >= max(w.Thresholds.DownAfter, 1):
+		event(Down, obs.Err)
+		s.Up = false
+		s.Since = now
+		s.Transitions = keep(append(s.Transitions, Transition{Time: now, Up: false}), w.History)
+	}
+
+	if obs.Up && obs.Latency > 0 {
+		s.Latency = keep(append(s.Latency, Sample{Time: now, Latency: obs.Latency}), w.History)
+	}
+	if obs.Egress != "" {
+		if s.Egress != "" && s.Egress != obs.Egress {
+			event(EgressChanged, s.Egress+" -> "+obs.Egress)
+			s.EgressChanges++
+		}
+		s.Egress = obs.Egress
+	}
+
+	if t := w.Thresholds.MinUptime; t > 0 && s.Checks >= w.Thresholds.MinChecks {
+		if low := s.Uptime < t; low != s.UptimeLow {
+			s.UptimeLow = low
+			kind := UptimeOK
+			if low {
+				kind = UptimeLow
+			}
+			event(kind, fmt.Sprintf("%.1f%% over %d checks", s.Uptime, s.Checks))
+		}
+	}
+	if t := w.Thresholds.MaxLatency; t > 0 && len(s.Latency) > 0 {
+		median := s.MedianLatency()
+		if high := median > t; high != s.LatencyHigh {
+			s.LatencyHigh = high
+			kind := LatencyOK
+			if high {
+				kind = LatencyHigh
+			}
+			event(kind, fmt.Sprintf("median %.0fms", median))
+		}
+	}
+	return events
+}
+
+// keep trims a history to the last History entries
+func keep[T any](history []T, n int) []T {
+	if n > 0 && len(history) > n {
+		return slices.Clone(history[len(history)-n:])
+	}
+	return history
+}
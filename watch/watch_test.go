@@ -0,0 +1,121 @@
+package watch
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/dn-11/proxyScan/proxy"
+	"github.com/dn-11/proxyScan/simnet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserve(t *testing.T) {
+	addr := netip.MustParseAddrPort("192.0.2.1:1080")
+	w := New([]netip.AddrPort{addr}, nil)
+	w.History = 3
+	w.Thresholds = Thresholds{DownAfter: 2, MinUptime: 60, MinChecks: 3, MaxLatency: 100}
+
+	start := time.Now()
+	var kinds []string
+	check := func(i int, obs Observation) {
+		for _, e := range w.observe(addr, obs, start.Add(time.Duration(i)*time.Minute)) {
+			kinds = append(kinds, e.Kind)
+		}
+	}
+	up := func(latency float64, egress string) Observation {
+		return Observation{Up: true, Latency: latency, Egress: egress}
+	}
+	down := Observation{Err: "check failed"}
+
+	check(0, up(50, "203.0.113.1"))
+	check(1, up(60, "203.0.113.1"))
+	assert.Empty(t, kinds)
+
+	// a single failure is not enough to go down
+	check(2, down)
+	assert.Empty(t, kinds)
+	check(3, down)
+	assert.Equal(t, []string{Down, UptimeLow}, kinds)
+	check(4, down)
+	assert.Equal(t, []string{Down, UptimeLow}, kinds)
+
+	kinds = nil
+	check(5, up(300, "203.0.113.2"))
+	check(6, up(300, "203.0.113.2"))
+	assert.Equal(t, []string{Up, EgressChanged, LatencyHigh}, kinds)
+
+	s := w.Statuses()[0]
+	assert.True(t, s.Up)
+	assert.Equal(t, 7, s.Checks)
+	assert.InDelta(t, 400.0/7, s.Uptime, 0.01)
+	assert.Equal(t, 1, s.EgressChanges)
+	assert.Len(t, s.Latency, 3)
+	assert.Len(t, s.Transitions, 3)
+	assert.Equal(t, start.Add(5*time.Minute), s.Since)
+
+	// the history carries over
+	w = New([]netip.AddrPort{addr}, []Status{s})
+	assert.Equal(t, 7, w.Statuses()[0].Checks)
+}
+
+func TestProbe(t *testing.T) {
+	n := simnet.New()
+	defer n.Close()
+	oldURLs, oldSamples, oldAPIs := proxy.LatencyURLs, proxy.LatencySamples, proxy.IPCheckAPIs
+	defer func() { proxy.LatencyURLs, proxy.LatencySamples, proxy.IPCheckAPIs = oldURLs, oldSamples, oldAPIs }()
+	proxy.LatencyURLs = []string{n.Web.URL("/generate_204")}
+	proxy.LatencySamples = 2
+	api, err := proxy.NewIPCheckAPI("trace", n.Web.URL("/cdn-cgi/trace"), proxy.ParserKV, map[string]string{"ip": "ip"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	proxy.IPCheckAPIs = []proxy.IPCheckAPI{api}
+
+	n.Web.SetGeo(simnet.Geo{IP: "203.0.113.7"})
+
+	p := n.NewProxy(simnet.Socks5)
+	w := New([]netip.AddrPort{p.AddrPort()}, nil)
+	w.Thresholds.DownAfter = 1
+	var events []Event
+	w.OnEvent = func(e Event) { events = append(events, e) }
+
+	w.Run(context.Background(), 1)
+	s := w.Statuses()[0]
+	assert.True(t, s.Up)
+	if assert.Len(t, s.Latency, 1) {
+		assert.Greater(t, s.Latency[0].Latency, 0.0)
+	}
+	assert.Equal(t, "203.0.113.7", s.Egress)
+
+	p.Close()
+	w.Round(context.Background())
+	s = w.Statuses()[0]
+	assert.False(t, s.Up)
+	assert.Equal(t, 50.0, s.Uptime)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, Down, events[0].Kind)
+	}
+}
+
+func TestEgress(t *testing.T) {
+	info := proxy.IPInfoResult{Same: map[string]proxy.FieldValue{"ip": {Value: "203.0.113.7", Sources: []string{"a", "b"}}}}
+	assert.Equal(t, "203.0.113.7", egress(info))
+
+	// the apis disagree, most of them win
+	info = proxy.IPInfoResult{Different: map[string][]proxy.FieldValue{"ip": {
+		{Value: "2001:db8::1", Sources: []string{"a"}},
+		{Value: "203.0.113.8", Sources: []string{"b", "c"}},
+	}}}
+	assert.Equal(t, "203.0.113.8", egress(info))
+	// a tie does not depend on the order of the apis
+	info.Different["ip"][0].Sources = []string{"a", "d"}
+	assert.Equal(t, "2001:db8::1", egress(info))
+	slices.Reverse(info.Different["ip"])
+	assert.Equal(t, "2001:db8::1", egress(info))
+
+	info = proxy.IPInfoResult{Same: map[string]proxy.FieldValue{"ip": {Value: "Failed to get"}}}
+	assert.Empty(t, egress(info))
+}